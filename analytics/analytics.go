@@ -0,0 +1,165 @@
+// analytics/analytics.go
+package analytics
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"url-shortener/models"
+	"url-shortener/storage"
+)
+
+// NewClick builds the click event for a redirect of shortCode served by r to
+// the client at clientIP.
+func NewClick(r *http.Request, shortCode, clientIP string, now time.Time) models.Click {
+	referrer := r.Referer()
+	return models.Click{
+		ShortCode:      shortCode,
+		ClickedAt:      now.UTC(),
+		Referrer:       referrer,
+		ReferrerHost:   referrerHost(referrer),
+		UserAgent:      r.UserAgent(),
+		Browser:        ParseBrowser(r.UserAgent()),
+		IP:             AnonymizeIP(clientIP),
+		AcceptLanguage: r.Header.Get("Accept-Language"),
+		Country:        Country(r),
+	}
+}
+
+// browserMarkers are checked in order, since most user agents mention
+// several engines (every Chrome UA also claims to be Safari).
+var browserMarkers = []struct {
+	marker, name string
+}{
+	{"bot", "Bot"},
+	{"crawler", "Bot"},
+	{"spider", "Bot"},
+	{"edg/", "Edge"},
+	{"opr/", "Opera"},
+	{"samsungbrowser/", "Samsung Internet"},
+	{"firefox/", "Firefox"},
+	{"fxios/", "Firefox"},
+	{"crios/", "Chrome"},
+	{"chrome/", "Chrome"},
+	{"safari/", "Safari"},
+	{"curl/", "curl"},
+}
+
+// ParseBrowser reduces a User-Agent header to a browser family name.
+func ParseBrowser(userAgent string) string {
+	if userAgent == "" {
+		return ""
+	}
+	ua := strings.ToLower(userAgent)
+	for _, b := range browserMarkers {
+		if strings.Contains(ua, b.marker) {
+			return b.name
+		}
+	}
+	return "Other"
+}
+
+// unfurlerMarkers identify the crawlers that fetch links pasted into chat
+// apps and social networks to render a preview, lowercased.
+var unfurlerMarkers = []string{
+	"slackbot",
+	"slack-imgproxy",
+	"twitterbot",
+	"facebookexternalhit",
+	"facebot",
+	"linkedinbot",
+	"discordbot",
+	"telegrambot",
+	"whatsapp",
+	"skypeuripreview",
+	"microsoftpreview",
+	"pinterest",
+	"redditbot",
+	"mastodon",
+	"embedly",
+	"iframely",
+	"vkshare",
+	"bluesky",
+}
+
+// IsUnfurler reports whether userAgent belongs to a crawler building a link
+// preview for a chat app or social network.
+func IsUnfurler(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	for _, marker := range unfurlerMarkers {
+		if strings.Contains(ua, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// AnonymizeIP truncates an IPv4 address to its /24 network and an IPv6
+// address to its /48 network so individual visitors cannot be identified.
+func AnonymizeIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// Country returns the visitor's ISO country code. It prefers a country header
+// set by a CDN or proxy and falls back to the region of the first
+// Accept-Language tag, e.g. "US" for "en-US".
+func Country(r *http.Request) string {
+	for _, h := range []string{"CF-IPCountry", "X-Country-Code"} {
+		if c := strings.TrimSpace(r.Header.Get(h)); len(c) == 2 {
+			return strings.ToUpper(c)
+		}
+	}
+	lang := r.Header.Get("Accept-Language")
+	if i := strings.IndexAny(lang, ",;"); i >= 0 {
+		lang = lang[:i]
+	}
+	parts := strings.Split(strings.TrimSpace(lang), "-")
+	if len(parts) >= 2 && len(parts[len(parts)-1]) == 2 {
+		return strings.ToUpper(parts[len(parts)-1])
+	}
+	return ""
+}
+
+func referrerHost(referrer string) string {
+	u, err := url.Parse(referrer)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// bucketSteps gives the length of each bucket size.
+var bucketSteps = map[string]func(time.Time) time.Time{
+	storage.BucketHour: func(t time.Time) time.Time { return t.Add(time.Hour) },
+	storage.BucketDay:  func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+	storage.BucketWeek: func(t time.Time) time.Time { return t.AddDate(0, 0, 7) },
+}
+
+// FillSeries expands a sparse series into one entry per bucket from the
+// bucket containing since up to the one containing until, filling gaps with
+// zero counts.
+func FillSeries(series []models.ClickBucket, bucket string, since, until time.Time) []models.ClickBucket {
+	step, ok := bucketSteps[bucket]
+	if !ok {
+		return series
+	}
+	counts := make(map[time.Time]int, len(series))
+	for _, b := range series {
+		counts[b.Start.UTC()] = b.Count
+	}
+	filled := []models.ClickBucket{}
+	end := storage.TruncateToBucket(until, bucket)
+	for t := storage.TruncateToBucket(since, bucket); !t.After(end); t = step(t) {
+		filled = append(filled, models.ClickBucket{Start: t, Count: counts[t]})
+	}
+	return filled
+}
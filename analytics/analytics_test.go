@@ -0,0 +1,83 @@
+// analytics/analytics_test.go
+package analytics
+
+import (
+	"net/http"
+	"testing"
+	"time"
+	"url-shortener/models"
+)
+
+func TestAnonymizeIP(t *testing.T) {
+	tests := map[string]string{
+		"203.0.113.57":              "203.0.113.0",
+		"2001:db8:85a3:8d3:1319::1": "2001:db8:85a3::",
+		"not-an-ip":                 "",
+	}
+	for in, want := range tests {
+		if got := AnonymizeIP(in); got != want {
+			t.Errorf("AnonymizeIP(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestParseBrowser(t *testing.T) {
+	tests := map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0 Safari/537.36":           "Chrome",
+		"Mozilla/5.0 (Windows NT 10.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0 Safari/537.36 Edg/123.0": "Edge",
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":                                  "Bot",
+		"curl/8.5.0": "curl",
+		"":           "",
+	}
+	for ua, want := range tests {
+		if got := ParseBrowser(ua); got != want {
+			t.Errorf("ParseBrowser(%q) = %q, want %q", ua, got, want)
+		}
+	}
+}
+
+func TestIsUnfurler(t *testing.T) {
+	tests := map[string]bool{
+		"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)": true,
+		"Twitterbot/1.0": true,
+		"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)":     true,
+		"Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)":             true,
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":      false,
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 Chrome/123.0 Safari/537.36": false,
+		"": false,
+	}
+	for ua, want := range tests {
+		if got := IsUnfurler(ua); got != want {
+			t.Errorf("IsUnfurler(%q) = %v, want %v", ua, got, want)
+		}
+	}
+}
+
+func TestCountry(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Language", "pt-BR,pt;q=0.9")
+	if got := Country(r); got != "BR" {
+		t.Errorf("Country from Accept-Language = %q, want BR", got)
+	}
+	r.Header.Set("CF-IPCountry", "nz")
+	if got := Country(r); got != "NZ" {
+		t.Errorf("Country from CF-IPCountry = %q, want NZ", got)
+	}
+}
+
+func TestFillSeries(t *testing.T) {
+	since := time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)
+	until := since.Add(3 * time.Hour)
+	sparse := []models.ClickBucket{{Start: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), Count: 4}}
+
+	got := FillSeries(sparse, "hour", since, until)
+	want := []int{0, 0, 4, 0}
+	if len(got) != len(want) {
+		t.Fatalf("got %d buckets want %d", len(got), len(want))
+	}
+	for i, b := range got {
+		if b.Count != want[i] {
+			t.Errorf("bucket %s: got %d want %d", b.Start, b.Count, want[i])
+		}
+	}
+}
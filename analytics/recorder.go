@@ -0,0 +1,67 @@
+// analytics/recorder.go
+package analytics
+
+import (
+	"context"
+	"log"
+	"url-shortener/models"
+	"url-shortener/storage"
+)
+
+// Recorder accepts click events from the redirect path.
+type Recorder interface {
+	Record(click models.Click)
+}
+
+// SyncRecorder writes each click to the store before returning.
+type SyncRecorder struct {
+	Store storage.ClickStore
+}
+
+// Record writes click to the store, logging failures.
+func (s SyncRecorder) Record(click models.Click) {
+	if err := s.Store.RecordClick(click); err != nil {
+		log.Printf("Error recording click: %v", err)
+	}
+}
+
+// AsyncRecorder queues clicks and writes them from a background goroutine so
+// redirects don't wait on the store. Clicks are dropped when the queue is full.
+type AsyncRecorder struct {
+	store storage.ClickStore
+	queue chan models.Click
+}
+
+// NewAsyncRecorder creates an AsyncRecorder with room for size queued clicks.
+// Call Run to start writing them.
+func NewAsyncRecorder(store storage.ClickStore, size int) *AsyncRecorder {
+	return &AsyncRecorder{store: store, queue: make(chan models.Click, size)}
+}
+
+// Record queues click without blocking.
+func (a *AsyncRecorder) Record(click models.Click) {
+	select {
+	case a.queue <- click:
+	default:
+		log.Printf("Click queue full, dropping click on %s", click.ShortCode)
+	}
+}
+
+// Run writes queued clicks until ctx is cancelled, then drains the queue.
+func (a *AsyncRecorder) Run(ctx context.Context) {
+	for {
+		select {
+		case click := <-a.queue:
+			SyncRecorder{Store: a.store}.Record(click)
+		case <-ctx.Done():
+			for {
+				select {
+				case click := <-a.queue:
+					SyncRecorder{Store: a.store}.Record(click)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// api/middleware.go
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"url-shortener/auth"
+)
+
+// Auth authenticates requests once per route and stores the resulting
+// auth.Principal in the request context, where handlers read it with
+// auth.FromContext.
+type Auth struct {
+	authn *auth.Authenticator
+}
+
+// NewAuth creates the middleware factory for authn.
+func NewAuth(authn *auth.Authenticator) *Auth {
+	return &Auth{authn: authn}
+}
+
+// policy is how a route authenticates its callers.
+type policy struct {
+	// required rejects anonymous requests.
+	required bool
+	// scope is the scope API keys need; "" means API keys are refused.
+	scope string
+}
+
+// Required rejects requests without valid credentials. API keys must carry
+// scope.
+func (a *Auth) Required(scope string) func(http.Handler) http.Handler {
+	return a.middleware(policy{required: true, scope: scope})
+}
+
+// Optional lets anonymous requests through without a principal. A bearer
+// token that fails to validate is treated as anonymous, so a stale session
+// degrades to guest access, but a bad API key is always rejected.
+func (a *Auth) Optional(scope string) func(http.Handler) http.Handler {
+	return a.middleware(policy{scope: scope})
+}
+
+// Session requires a bearer token and refuses API keys, for routes such as
+// key management that a leaked key must not reach.
+func (a *Auth) Session() func(http.Handler) http.Handler {
+	return a.middleware(policy{required: true})
+}
+
+func (a *Auth) middleware(p policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			isAPIKey := auth.IsAPIKeyRequest(r)
+			if isAPIKey && p.scope == "" {
+				http.Error(w, "API keys are not accepted here", http.StatusUnauthorized)
+				return
+			}
+
+			principal, err := a.authn.Authenticate(r)
+			switch {
+			case errors.Is(err, auth.ErrUnavailable):
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			case err != nil && (p.required || isAPIKey):
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			case err != nil:
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !principal.HasScope(p.scope) {
+				writeScopeError(w, p.scope)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), principal)))
+		})
+	}
+}
+
+// writeScopeError responds 403 in the API's structured error format.
+func writeScopeError(w http.ResponseWriter, scope string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]map[string]string{
+		"error": {"code": "insufficient_scope", "message": "API key lacks the " + scope + " scope"},
+	})
+}
@@ -3,27 +3,91 @@ package api
 
 import (
 	"net/http"
+	"strconv"
+	"url-shortener/auth"
+	"url-shortener/config"
 	"url-shortener/handlers"
-	"github.com/gorilla/mux"
 	"url-shortener/storage"
+
+	"github.com/gorilla/mux"
 )
 
-func NewRouter() *mux.Router {
+// NewRouter builds the API handler on top of the given stores and maps the
+// endpoints to it. opts are applied after cfg.
+func NewRouter(cfg config.Config, stores storage.Stores, opts ...handlers.Option) *mux.Router {
 	router := mux.NewRouter()
+	h := handlers.NewHandler(stores, append([]handlers.Option{handlers.WithConfig(cfg)}, opts...)...)
+	rateLimit := storage.NewRateLimitMiddleware(cfg.RateLimit)
+	bulkLimit := storage.NewKeyedRateLimitMiddleware(cfg.Bulk.RateLimit, userKey)
+
+	authn := NewAuth(h.Authenticator())
+	optional := func(scope string, f http.HandlerFunc) http.Handler { return authn.Optional(scope)(f) }
+	required := func(scope string, f http.HandlerFunc) http.Handler { return authn.Required(scope)(f) }
+	session := func(f http.HandlerFunc) http.Handler { return authn.Session()(f) }
+	// Inside the auth middleware, which identifies the user
+	bulk := func(f http.HandlerFunc) http.HandlerFunc { return bulkLimit(f).ServeHTTP }
 
 	// Define the API endpoints and map them to handlers
-	router.HandleFunc("/create", handlers.CreateShortURLHandler).Methods("POST").Handler(storage.RateLimitMiddleware(http.HandlerFunc(handlers.CreateShortURLHandler)))
-	router.HandleFunc("/{shortCode}", handlers.RedirectShortURLHandler).Methods("GET")
-	router.HandleFunc("/analytics/{shortCode}", handlers.GetURLAnalyticsHandler).Methods("GET")
+	router.Handle("/create", rateLimit(optional(auth.ScopeLinksWrite, h.CreateShortURLHandler))).Methods("POST")
+	// Registered ahead of /{shortCode}, which would otherwise match it
+	router.Handle("/workspaces", required(auth.ScopeLinksRead, h.ListWorkspacesHandler)).Methods("GET")
+	router.Handle("/export", required(auth.ScopeLinksRead, h.ExportURLsHandler)).Methods("GET")
+	router.HandleFunc("/{shortCode}", h.RedirectShortURLHandler).Methods("GET")
+	router.HandleFunc("/{shortCode}/qr", h.QRCodeHandler).Methods("GET")
+	router.Handle("/analytics/{shortCode}", optional(auth.ScopeAnalyticsRead, h.GetURLAnalyticsHandler)).Methods("GET")
+
+	router.HandleFunc("/signup", h.SignUpHandler).Methods("POST")
+	router.HandleFunc("/login", h.LoginHandler).Methods("POST")
+	router.HandleFunc("/token/refresh", h.RefreshTokenHandler).Methods("POST")
+	router.Handle("/logout", authn.Optional("")(http.HandlerFunc(h.LogoutHandler))).Methods("POST")
+	router.HandleFunc("/.well-known/jwks.json", h.JWKSHandler).Methods("GET")
+
+	router.HandleFunc("/auth/oidc/login", h.OIDCLoginHandler).Methods("GET")
+	router.HandleFunc("/auth/oidc/callback", h.OIDCCallbackHandler).Methods("GET")
+	router.HandleFunc("/auth/oidc/token", h.OIDCTokenHandler).Methods("POST")
 
-	router.HandleFunc("/signup", handlers.SignUpHandler).Methods("POST")
-	router.HandleFunc("/login", handlers.LoginHandler).Methods("POST")
+	router.HandleFunc("/verify-email", h.VerifyEmailHandler).Methods("POST")
+	router.Handle("/verify-email/resend", session(h.ResendVerificationHandler)).Methods("POST")
+	router.HandleFunc("/password/forgot", h.ForgotPasswordHandler).Methods("POST")
+	router.HandleFunc("/password/reset", h.ResetPasswordHandler).Methods("POST")
+	router.Handle("/password/change", session(h.ChangePasswordHandler)).Methods("POST")
 
-	router.HandleFunc("/user/urls", handlers.GetUserURLsHandler).Methods("GET")
-	router.HandleFunc("/delete/{shortCode}", handlers.DeleteURLHandler).Methods("DELETE")
-	router.HandleFunc("/urls/{shortCode}/visit", handlers.AuthenticatedVisitCountHandler).Methods("POST")
+	router.Handle("/user/urls", required(auth.ScopeLinksRead, h.GetUserURLsHandler)).Methods("GET")
+	router.Handle("/delete/{shortCode}", required(auth.ScopeLinksWrite, h.DeleteURLHandler)).Methods("DELETE")
+	router.Handle("/urls/{shortCode}", required(auth.ScopeLinksWrite, h.UpdateURLHandler)).Methods("PATCH")
+	router.Handle("/urls/{shortCode}/revisions", required(auth.ScopeLinksRead, h.GetURLRevisionsHandler)).Methods("GET")
 
-	router.HandleFunc("/user/urls/{shortCode}/visitcount", handlers.GetURLVisitCountHandler).Methods("GET")
+	router.Handle("/user/apikeys", session(h.CreateAPIKeyHandler)).Methods("POST")
+	router.Handle("/user/apikeys", session(h.ListAPIKeysHandler)).Methods("GET")
+	router.Handle("/user/apikeys/{id}", session(h.RevokeAPIKeyHandler)).Methods("DELETE")
+
+	router.Handle("/bulk/create", required(auth.ScopeLinksWrite, bulk(h.BulkCreateHandler))).Methods("POST")
+	router.Handle("/bulk/delete", required(auth.ScopeLinksWrite, bulk(h.BulkDeleteHandler))).Methods("POST")
+	router.Handle("/bulk/jobs/{id}", required(auth.ScopeLinksRead, h.GetBulkJobHandler)).Methods("GET")
+	router.Handle("/import", required(auth.ScopeLinksWrite, bulk(h.ImportURLsHandler))).Methods("POST")
+
+	router.Handle("/user/urls/{shortCode}/visitcount", required(auth.ScopeAnalyticsRead, h.GetURLVisitCountHandler)).Methods("GET")
+
+	router.Handle("/workspaces", session(h.CreateWorkspaceHandler)).Methods("POST")
+	router.Handle("/workspaces/{id}/urls", required(auth.ScopeLinksRead, h.GetWorkspaceURLsHandler)).Methods("GET")
+	router.Handle("/workspaces/{id}/members", session(h.ListMembersHandler)).Methods("GET")
+	router.Handle("/workspaces/{id}/members/{userId}", session(h.UpdateMemberHandler)).Methods("PATCH")
+	router.Handle("/workspaces/{id}/members/{userId}", session(h.RemoveMemberHandler)).Methods("DELETE")
+	router.Handle("/workspaces/{id}/invitations", session(h.CreateInvitationHandler)).Methods("POST")
+	router.Handle("/workspaces/{id}/invitations", session(h.ListInvitationsHandler)).Methods("GET")
+	router.Handle("/workspaces/{id}/invitations/{invitationId}", session(h.RevokeInvitationHandler)).Methods("DELETE")
+	router.Handle("/invitations/accept", session(h.AcceptInvitationHandler)).Methods("POST")
+
+	// Last, so it doesn't shadow the other single-segment POST routes
+	router.HandleFunc("/{shortCode}", h.UnlockURLHandler).Methods("POST")
 
 	return router
-}
\ No newline at end of file
+}
+
+// userKey keys rate limits by the authenticated user.
+func userKey(r *http.Request) string {
+	if p, ok := auth.FromContext(r.Context()); ok {
+		return strconv.Itoa(p.User.ID)
+	}
+	return ""
+}
@@ -0,0 +1,170 @@
+// api/router_test.go
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"url-shortener/config"
+	"url-shortener/models"
+	"url-shortener/storage"
+)
+
+// newTestRouter builds the full router, auth middleware included, on top of
+// an in-memory store.
+func newTestRouter() http.Handler {
+	cfg := config.Default()
+	cfg.RateLimit.Burst = 100
+	return NewRouter(cfg, storage.NewMemoryStore().Stores())
+}
+
+// do sends a JSON request with the given Authorization header.
+func do(router http.Handler, method, path, authorization string, payload interface{}) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	if payload != nil {
+		json.NewEncoder(&body).Encode(payload)
+	}
+	req, _ := http.NewRequest(method, path, &body)
+	req.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// signUp registers a user and returns its Authorization header.
+func signUp(t *testing.T, router http.Handler, email string) string {
+	t.Helper()
+	rr := do(router, "POST", "/signup", "", map[string]string{"email": email, "password": "s3cret-passw0rd"})
+	var result struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil || result.Token == "" {
+		t.Fatalf("signup failed: %v %s", rr.Code, rr.Body)
+	}
+	return "Bearer " + result.Token
+}
+
+// createAPIKey issues an API key with scopes and returns it and its ID.
+func createAPIKey(t *testing.T, router http.Handler, bearer string, scopes ...string) (string, int) {
+	t.Helper()
+	rr := do(router, "POST", "/user/apikeys", bearer, map[string]interface{}{"name": "ci", "scopes": scopes})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("CreateAPIKeyHandler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+	var created struct {
+		ID     int    `json:"id"`
+		Prefix string `json:"prefix"`
+		Key    string `json:"key"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if created.Key == "" || !strings.HasPrefix(created.Key, created.Prefix) {
+		t.Fatalf("CreateAPIKeyHandler returned unexpected key: %s", rr.Body)
+	}
+	return "ApiKey " + created.Key, created.ID
+}
+
+func TestAuthPolicies(t *testing.T) {
+	router := newTestRouter()
+	bearer := signUp(t, router, "alice@example.com")
+
+	tests := []struct {
+		name          string
+		method, path  string
+		authorization string
+		status        int
+	}{
+		{"required without credentials", "GET", "/user/urls", "", http.StatusUnauthorized},
+		{"required with bad token", "GET", "/user/urls", "Bearer not-a-token", http.StatusUnauthorized},
+		{"required with token", "GET", "/user/urls", bearer, http.StatusOK},
+		{"optional without credentials", "POST", "/create", "", http.StatusOK},
+		{"optional with stale token", "POST", "/create", "Bearer null", http.StatusOK},
+		{"optional with bad API key", "POST", "/create", "ApiKey usk_nope", http.StatusUnauthorized},
+		{"session-only with API key", "GET", "/user/apikeys", "ApiKey usk_nope", http.StatusUnauthorized},
+		{"bulk without credentials", "POST", "/bulk/create", "", http.StatusUnauthorized},
+		{"bulk job of nobody", "GET", "/bulk/jobs/nope", bearer, http.StatusNotFound},
+		{"export without credentials", "GET", "/export", "", http.StatusUnauthorized},
+		{"import without credentials", "POST", "/import", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := do(router, tt.method, tt.path, tt.authorization, map[string]string{"originalUrl": "https://example.com/" + tt.name})
+			if rr.Code != tt.status {
+				t.Errorf("got status %v want %v: %s", rr.Code, tt.status, rr.Body)
+			}
+		})
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	router := newTestRouter()
+	bearer := signUp(t, router, "erin@example.com")
+	apiKey, id := createAPIKey(t, router, bearer, "links:write")
+
+	if rr := do(router, "POST", "/create", apiKey, map[string]string{"originalUrl": "https://example.com/ci"}); rr.Code != http.StatusOK {
+		t.Errorf("API key with links:write could not create a link: %v", rr.Code)
+	}
+	rr := do(router, "GET", "/user/urls", apiKey, nil)
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "insufficient_scope") {
+		t.Errorf("API key without links:read listed links: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if rr := do(router, "POST", "/user/apikeys", apiKey, map[string]interface{}{"name": "more", "scopes": []string{"links:read"}}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("API key minted another key: %v", rr.Code)
+	}
+
+	var urls storage.URLPage
+	json.Unmarshal(do(router, "GET", "/user/urls", bearer, nil).Body.Bytes(), &urls)
+	if len(urls.Items) != 1 || urls.Items[0].OriginalURL != "https://example.com/ci" {
+		t.Errorf("link created with API key not owned by the user: %+v", urls)
+	}
+
+	keysRR := do(router, "GET", "/user/apikeys", bearer, nil)
+	var keys []models.APIKey
+	json.Unmarshal(keysRR.Body.Bytes(), &keys)
+	if len(keys) != 1 || keys[0].LastUsedAt == nil || strings.Contains(keysRR.Body.String(), strings.TrimPrefix(apiKey, "ApiKey ")) {
+		t.Errorf("ListAPIKeysHandler returned unexpected keys: %s", keysRR.Body)
+	}
+
+	if rr := do(router, "DELETE", fmt.Sprintf("/user/apikeys/%d", id), bearer, nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("RevokeAPIKeyHandler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr := do(router, "POST", "/create", apiKey, map[string]string{"originalUrl": "https://example.com/ci2"}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("revoked API key created a link: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	if rr := do(router, "POST", "/user/apikeys", bearer, map[string]interface{}{"name": "bot", "scopes": []string{"admin"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown scope accepted: %v", rr.Code)
+	}
+}
+
+func TestBulkRateLimitIsPerUser(t *testing.T) {
+	cfg := config.Default()
+	cfg.Bulk.RateLimit = config.RateLimitConfig{RequestsPerSecond: 0.001, Burst: 1}
+	router := NewRouter(cfg, storage.NewMemoryStore().Stores())
+	alice := signUp(t, router, "alice@example.com")
+	bob := signUp(t, router, "bob@example.com")
+
+	rows := []map[string]string{{"originalUrl": "https://example.com"}}
+	if rr := do(router, "POST", "/bulk/create", alice, rows); rr.Code != http.StatusOK {
+		t.Fatalf("first bulk create: got status %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := do(router, "POST", "/bulk/delete", alice, []string{"x"}); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("bulk request over the limit: got status %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+	if rr := do(router, "POST", "/import", alice, rows); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("import over the limit: got status %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+	// Neither other users nor single creates are held back
+	if rr := do(router, "POST", "/bulk/create", bob, rows); rr.Code != http.StatusOK {
+		t.Errorf("another user's bulk create: got status %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := do(router, "POST", "/create", alice, map[string]string{"originalUrl": "https://example.com/single"}); rr.Code != http.StatusOK {
+		t.Errorf("create after bulk requests: got status %v want %v", rr.Code, http.StatusOK)
+	}
+}
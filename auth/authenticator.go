@@ -0,0 +1,137 @@
+// auth/authenticator.go
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"url-shortener/storage"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// APIKeyScheme is the Authorization scheme for API keys, as in
+// "Authorization: ApiKey usk_...".
+const APIKeyScheme = "ApiKey "
+
+// apiKeyTouchInterval limits how often a key's last use is written.
+const apiKeyTouchInterval = time.Minute
+
+var (
+	ErrNoCredentials = errors.New("authorization header is missing")
+	ErrTokenRevoked  = errors.New("token has been revoked")
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrUnavailable   = errors.New("could not validate credentials")
+)
+
+// Claims are the claims of the access tokens we issue.
+type Claims struct {
+	Email  string `json:"email"`
+	UserID int    `json:"uid"`
+	// SessionID names the session the token was issued for, so revoking the
+	// session revokes the token.
+	SessionID string `json:"sid"`
+	jwt.StandardClaims
+}
+
+// Authenticator resolves the credentials of a request to a Principal.
+type Authenticator struct {
+	keys     *KeySet
+	users    storage.UserStore
+	sessions storage.SessionStore
+	apiKeys  storage.APIKeyStore
+}
+
+// NewAuthenticator creates an Authenticator that verifies tokens with keys
+// and looks users, sessions and API keys up in stores.
+func NewAuthenticator(keys *KeySet, stores storage.Stores) *Authenticator {
+	return &Authenticator{keys: keys, users: stores.Users, sessions: stores.Sessions, apiKeys: stores.APIKeys}
+}
+
+// IsAPIKeyRequest reports whether r authenticates with an API key.
+func IsAPIKeyRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Authorization"), APIKeyScheme)
+}
+
+// Authenticate validates the bearer token or API key of r and loads the
+// user it belongs to. It returns ErrNoCredentials if r carries neither.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	header := r.Header.Get("Authorization")
+	switch {
+	case header == "":
+		return nil, ErrNoCredentials
+	case IsAPIKeyRequest(r):
+		return a.authenticateAPIKey(strings.TrimPrefix(header, APIKeyScheme))
+	default:
+		return a.authenticateToken(strings.TrimPrefix(header, "Bearer "))
+	}
+}
+
+func (a *Authenticator) authenticateToken(tokenString string) (*Principal, error) {
+	claims := &Claims{}
+	if err := a.keys.Parse(tokenString, claims); err != nil {
+		return nil, err
+	}
+	if claims.SessionID == "" || claims.UserID == 0 {
+		return nil, ErrInvalidToken
+	}
+	session, err := a.sessions.GetSession(claims.SessionID)
+	if err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			return nil, ErrTokenRevoked
+		}
+		log.Printf("Error retrieving session: %v", err)
+		return nil, ErrUnavailable
+	}
+	if session.RevokedAt != nil || session.UserID != claims.UserID {
+		return nil, ErrTokenRevoked
+	}
+
+	user, err := a.users.GetUserByID(claims.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, ErrTokenRevoked
+		}
+		log.Printf("Error retrieving user: %v", err)
+		return nil, ErrUnavailable
+	}
+	return &Principal{User: user, SessionID: session.ID}, nil
+}
+
+func (a *Authenticator) authenticateAPIKey(secret string) (*Principal, error) {
+	key, err := a.apiKeys.GetAPIKeyByHash(HashToken(secret))
+	if err != nil {
+		if !errors.Is(err, storage.ErrAPIKeyNotFound) {
+			log.Printf("Error retrieving API key: %v", err)
+			return nil, ErrUnavailable
+		}
+		return nil, ErrInvalidAPIKey
+	}
+	if key.RevokedAt != nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		if err := a.apiKeys.TouchAPIKey(key.ID, now); err != nil {
+			log.Printf("Error recording API key use: %v", err)
+		}
+	}
+
+	user, err := a.users.GetUserByID(key.UserID)
+	if err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+	return &Principal{User: user, APIKey: &key}, nil
+}
+
+// HashToken returns the form refresh tokens and API keys are stored in.
+// They are random and long, so a fast unsalted hash is enough.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
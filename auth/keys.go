@@ -0,0 +1,185 @@
+// auth/keys.go
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"url-shortener/config"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrUnknownKey   = errors.New("unknown signing key")
+)
+
+// Key is a token signing key identified by the kid header of the tokens it
+// signs.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// signKey and verifyKey are the values passed to Method. They are the
+	// same secret for HMAC keys and the private and public halves otherwise.
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// NewHMACKey returns an HS256 key.
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{ID: id, Method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}
+}
+
+// NewRSAKey returns an RS256 key.
+func NewRSAKey(id string, priv *rsa.PrivateKey) *Key {
+	return &Key{ID: id, Method: jwt.SigningMethodRS256, signKey: priv, verifyKey: &priv.PublicKey}
+}
+
+// NewEd25519Key returns an EdDSA key.
+func NewEd25519Key(id string, priv ed25519.PrivateKey) *Key {
+	return &Key{ID: id, Method: SigningMethodEdDSA, signKey: priv, verifyKey: priv.Public()}
+}
+
+// RandomKey returns an HS256 key with a fresh 256-bit secret.
+func RandomKey(id string) *Key {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return NewHMACKey(id, secret)
+}
+
+// ParsePrivateKeyPEM reads an RSA (PKCS #1 or PKCS #8) or Ed25519 (PKCS #8)
+// private key.
+func ParsePrivateKeyPEM(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if priv, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return NewRSAKey(id, priv), nil
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch priv := priv.(type) {
+	case *rsa.PrivateKey:
+		return NewRSAKey(id, priv), nil
+	case ed25519.PrivateKey:
+		return NewEd25519Key(id, priv), nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", priv)
+	}
+}
+
+// KeySet signs tokens with its active key and verifies tokens signed by any
+// of its keys.
+type KeySet struct {
+	active *Key
+	keys   map[string]*Key
+	// order keeps the keys in configuration order for JWKS.
+	order []*Key
+}
+
+// NewKeySet returns a KeySet that signs with active and also accepts tokens
+// signed by others.
+func NewKeySet(active *Key, others ...*Key) *KeySet {
+	s := &KeySet{active: active, keys: make(map[string]*Key)}
+	for _, k := range append([]*Key{active}, others...) {
+		if _, ok := s.keys[k.ID]; ok {
+			continue
+		}
+		s.keys[k.ID] = k
+		s.order = append(s.order, k)
+	}
+	return s
+}
+
+// LoadKeySet builds the KeySet described by cfg. Without configured keys it
+// falls back to a random HS256 key.
+func LoadKeySet(cfg config.AuthConfig) (*KeySet, error) {
+	var active *Key
+	var others []*Key
+	for _, kc := range cfg.Keys() {
+		k, err := loadKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %q: %v", kc.ID, err)
+		}
+		if k.ID == cfg.ActiveKey() {
+			active = k
+		} else {
+			others = append(others, k)
+		}
+	}
+	if active == nil {
+		if len(others) > 0 {
+			return nil, fmt.Errorf("no signing key with id %q", cfg.ActiveKey())
+		}
+		active = RandomKey(config.DefaultKeyID)
+	}
+	return NewKeySet(active, others...), nil
+}
+
+func loadKey(kc config.SigningKeyConfig) (*Key, error) {
+	if kc.Secret != "" {
+		return NewHMACKey(kc.ID, []byte(kc.Secret)), nil
+	}
+	data, err := os.ReadFile(kc.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKeyPEM(kc.ID, data)
+}
+
+// Sign returns claims as a token signed by the active key.
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.active.Method, claims)
+	token.Header["kid"] = s.active.ID
+	return token.SignedString(s.active.signKey)
+}
+
+// Parse verifies tokenString and decodes it into claims. Tokens without a
+// kid header are checked against the key with ID config.DefaultKeyID, which
+// is the one that signed tokens before key IDs were introduced.
+func (s *KeySet) Parse(tokenString string, claims jwt.Claims) error {
+	token, err := jwt.ParseWithClaims(tokenString, claims, s.keyFunc)
+	if err != nil || !token.Valid {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func (s *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = config.DefaultKeyID
+	}
+	k, ok := s.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	// Never let the token pick the algorithm, or an RSA public key could
+	// be used as an HMAC secret.
+	if token.Method.Alg() != k.Method.Alg() {
+		return nil, ErrInvalidToken
+	}
+	return k.verifyKey, nil
+}
+
+// publicKey returns the key's public half, or nil for HMAC keys.
+func (k *Key) publicKey() crypto.PublicKey {
+	switch pub := k.verifyKey.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+		return pub
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,101 @@
+// auth/keys_test.go
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"url-shortener/config"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{"email": "a@example.com", "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := NewHMACKey("2023", []byte("an-old-secret-that-is-32-bytes-long"))
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	newKey := NewEd25519Key("2024", priv)
+
+	oldToken, err := NewKeySet(oldKey).Sign(testClaims())
+	if err != nil {
+		t.Fatalf("Sign returned error: %v", err)
+	}
+
+	rotated := NewKeySet(newKey, oldKey)
+	newToken, err := rotated.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("Sign returned error: %v", err)
+	}
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		claims := jwt.MapClaims{}
+		if err := rotated.Parse(token, claims); err != nil || claims["email"] != "a@example.com" {
+			t.Errorf("%s token rejected after rotation: %v", name, err)
+		}
+	}
+
+	retired := NewKeySet(newKey)
+	if err := retired.Parse(oldToken, jwt.MapClaims{}); err == nil {
+		t.Error("token signed by a retired key was accepted")
+	}
+}
+
+func TestParseRejectsAlgorithmMismatch(t *testing.T) {
+	priv, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keys := NewKeySet(NewRSAKey("rsa", priv))
+
+	// An attacker signs an HS256 token with the published public key.
+	pub := x509.MarshalPKCS1PublicKey(&priv.PublicKey)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	token.Header["kid"] = "rsa"
+	forged, _ := token.SignedString(pub)
+
+	if err := keys.Parse(forged, jwt.MapClaims{}); err == nil {
+		t.Error("HS256 token accepted for an RS256 key")
+	}
+}
+
+func TestParseAcceptsLegacyTokensWithoutKeyID(t *testing.T) {
+	secret := []byte("a-legacy-secret-that-is-32-bytes!")
+	legacy, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims()).SignedString(secret)
+
+	keys := NewKeySet(RandomKey("next"), NewHMACKey(config.DefaultKeyID, secret))
+	if err := keys.Parse(legacy, jwt.MapClaims{}); err != nil {
+		t.Errorf("legacy token rejected: %v", err)
+	}
+}
+
+func TestLoadKeySet(t *testing.T) {
+	priv, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der, _ := x509.MarshalPKCS8PrivateKey(priv)
+	path := filepath.Join(t.TempDir(), "rsa.pem")
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+
+	cfg := config.Default().Auth
+	cfg.JWTSecret = "a-default-secret-that-is-32-bytes"
+	cfg.SigningKeys = []config.SigningKeyConfig{{ID: "rsa-1", PrivateKeyFile: path}}
+	cfg.ActiveKeyID = "rsa-1"
+
+	keys, err := LoadKeySet(cfg)
+	if err != nil {
+		t.Fatalf("LoadKeySet returned error: %v", err)
+	}
+	token, _ := keys.Sign(testClaims())
+	parsed, _ := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return &priv.PublicKey, nil })
+	if parsed == nil || !parsed.Valid || parsed.Header["kid"] != "rsa-1" || parsed.Method.Alg() != "RS256" {
+		t.Errorf("token not signed by the active RSA key: %v", parsed)
+	}
+
+	jwks := keys.JWKS()
+	if len(jwks.Keys) != 1 || jwks.Keys[0].KeyID != "rsa-1" || jwks.Keys[0].KeyType != "RSA" || jwks.Keys[0].E != "AQAB" {
+		t.Errorf("unexpected JWKS, HMAC secrets must not be published: %+v", jwks)
+	}
+}
@@ -0,0 +1,35 @@
+// auth/principal.go
+package auth
+
+import (
+	"context"
+	"url-shortener/models"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	User models.User
+	// SessionID is set when the caller used a bearer token, APIKey when it
+	// used an API key.
+	SessionID string
+	APIKey    *models.APIKey
+}
+
+// HasScope reports whether the principal may act with scope. Session
+// tokens carry every scope.
+func (p *Principal) HasScope(scope string) bool {
+	return p.APIKey == nil || p.APIKey.HasScope(scope)
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying p.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal stored in ctx by NewContext.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
@@ -0,0 +1,22 @@
+// auth/scopes.go
+package auth
+
+// Scopes granted to API keys. Session tokens carry every scope.
+const (
+	ScopeLinksRead     = "links:read"
+	ScopeLinksWrite    = "links:write"
+	ScopeAnalyticsRead = "analytics:read"
+)
+
+// Scopes lists every scope an API key can be granted.
+var Scopes = []string{ScopeLinksRead, ScopeLinksWrite, ScopeAnalyticsRead}
+
+// ValidScope reports whether scope is one of Scopes.
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
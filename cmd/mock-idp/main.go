@@ -0,0 +1,27 @@
+// cmd/mock-idp/main.go
+
+// Command mock-idp runs the oidctest identity provider for trying single
+// sign-on locally. It signs every authorization request in as the given
+// user without asking for credentials, so never expose it.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"url-shortener/oidc/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9998", "listen address")
+	clientID := flag.String("client-id", "url-shortener", "client ID to accept")
+	subject := flag.String("subject", "alice", "subject of the signed-in user")
+	email := flag.String("email", "alice@example.com", "email of the signed-in user")
+	flag.Parse()
+
+	p := oidctest.New("http://"+*addr, *clientID)
+	p.Subject, p.Email = *subject, *email
+
+	log.Printf("Mock identity provider for %s at http://%s", *email, *addr)
+	log.Fatal(http.ListenAndServe(*addr, p))
+}
@@ -0,0 +1,645 @@
+// config/config.go
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"url-shortener/utils"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete server configuration.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Redis     RedisConfig     `yaml:"redis"`
+	CORS      CORSConfig      `yaml:"cors"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Links     LinksConfig     `yaml:"links"`
+	Cache     CacheConfig     `yaml:"cache"`
+	Workers   WorkersConfig   `yaml:"workers"`
+	Mail      MailConfig      `yaml:"mail"`
+	Previews  PreviewsConfig  `yaml:"previews"`
+	QR        QRConfig        `yaml:"qr"`
+	Bulk      BulkConfig      `yaml:"bulk"`
+}
+
+// ServerConfig configures the HTTP listener.
+type ServerConfig struct {
+	Addr      string `yaml:"addr"`
+	StaticDir string `yaml:"static_dir"`
+	// DefaultRedirectStatus is used for links without their own redirect type.
+	DefaultRedirectStatus int `yaml:"default_redirect_status"`
+	// PublicURL is where users reach the app, used to build links in emails.
+	PublicURL string `yaml:"public_url"`
+	// TrustedProxies are the addresses and CIDR ranges of reverse proxies
+	// whose X-Forwarded-For headers are believed.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// DatabaseConfig holds the PostgreSQL connection settings.
+type DatabaseConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+	SSLMode  string `yaml:"sslmode"`
+}
+
+// DSN returns the PostgreSQL connection string.
+func (d DatabaseConfig) DSN() string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s", d.User, d.Password, d.Host, d.Port, d.Name, d.SSLMode)
+}
+
+// RedisConfig holds the Redis connection settings.
+type RedisConfig struct {
+	Addr     string `yaml:"addr"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+}
+
+// CORSConfig configures cross-origin requests.
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	Debug          bool     `yaml:"debug"`
+}
+
+// RateLimitConfig configures the limiter in front of POST /create.
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+// DefaultKeyID identifies the key configured by AuthConfig.JWTSecret or
+// AuthConfig.JWTKeyFile. Tokens without a kid header are checked against it.
+const DefaultKeyID = "default"
+
+// AuthConfig configures token issuing. When no key is configured a random
+// secret is generated at startup, so tokens don't survive a restart.
+type AuthConfig struct {
+	// JWTSecret is shorthand for an HS256 key with ID DefaultKeyID.
+	JWTSecret string `yaml:"jwt_secret"`
+	// JWTKeyFile is shorthand for a PEM private key with ID DefaultKeyID.
+	JWTKeyFile string `yaml:"jwt_key_file"`
+	// SigningKeys lists further keys. Tokens signed by any of them are
+	// accepted, so a retired key can stay listed until its tokens expire.
+	SigningKeys []SigningKeyConfig `yaml:"signing_keys"`
+	// ActiveKeyID names the key that signs new tokens. It defaults to
+	// DefaultKeyID when that key is set, and to the first listed key otherwise.
+	ActiveKeyID string `yaml:"active_key_id"`
+	// TokenTTL is the lifetime of access tokens. Clients renew them with a
+	// refresh token, which is valid for RefreshTokenTTL after it is issued.
+	TokenTTL        time.Duration `yaml:"token_ttl"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"`
+	// VerificationTokenTTL, PasswordResetTokenTTL and InvitationTTL bound
+	// the single-use tokens emailed to users.
+	VerificationTokenTTL  time.Duration `yaml:"verification_token_ttl"`
+	PasswordResetTokenTTL time.Duration `yaml:"password_reset_token_ttl"`
+	InvitationTTL         time.Duration `yaml:"invitation_ttl"`
+	// EmailRequests requests for verification or password reset emails are
+	// allowed per client in each EmailWindow.
+	EmailRequests int           `yaml:"email_requests"`
+	EmailWindow   time.Duration `yaml:"email_window"`
+	// OIDC enables single sign-on through an OpenID Connect provider.
+	OIDC OIDCConfig `yaml:"oidc"`
+}
+
+// OIDCConfig configures login through an OpenID Connect provider. It is
+// disabled while Issuer is empty.
+type OIDCConfig struct {
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// RedirectURL is the callback registered with the provider. It defaults
+	// to /auth/oidc/callback under server.public_url.
+	RedirectURL string   `yaml:"redirect_url"`
+	Scopes      []string `yaml:"scopes"`
+}
+
+// Enabled reports whether OIDC login is configured.
+func (o OIDCConfig) Enabled() bool {
+	return o.Issuer != ""
+}
+
+// SigningKeyConfig is a token signing key. Exactly one of Secret and
+// PrivateKeyFile is set.
+type SigningKeyConfig struct {
+	ID string `yaml:"id"`
+	// Secret is an HS256 secret.
+	Secret string `yaml:"secret"`
+	// PrivateKeyFile is a PEM-encoded RSA (RS256) or Ed25519 (EdDSA) key.
+	PrivateKeyFile string `yaml:"private_key_file"`
+}
+
+// Keys returns every configured signing key, including the shorthand one.
+func (a AuthConfig) Keys() []SigningKeyConfig {
+	var keys []SigningKeyConfig
+	if a.JWTSecret != "" || a.JWTKeyFile != "" {
+		keys = append(keys, SigningKeyConfig{ID: DefaultKeyID, Secret: a.JWTSecret, PrivateKeyFile: a.JWTKeyFile})
+	}
+	return append(keys, a.SigningKeys...)
+}
+
+// ActiveKey returns the ID of the key that signs new tokens, or "" when no
+// key is configured.
+func (a AuthConfig) ActiveKey() string {
+	if a.ActiveKeyID != "" {
+		return a.ActiveKeyID
+	}
+	if keys := a.Keys(); len(keys) > 0 {
+		return keys[0].ID
+	}
+	return ""
+}
+
+// LinksConfig configures link creation.
+type LinksConfig struct {
+	GuestTTL time.Duration `yaml:"guest_ttl"`
+	// ShortCodeStrategy is one of random, sequence, obfuscated or words.
+	ShortCodeStrategy string `yaml:"short_code_strategy"`
+	ShortCodeLength   int    `yaml:"short_code_length"`
+	ShortCodeSalt     string `yaml:"short_code_salt"`
+	// UnlockTTL is how long a password-protected link stays unlocked in
+	// the browser that entered its password.
+	UnlockTTL time.Duration `yaml:"unlock_ttl"`
+	// UnlockAttempts wrong passwords per client and link are allowed in
+	// each UnlockWindow.
+	UnlockAttempts int           `yaml:"unlock_attempts"`
+	UnlockWindow   time.Duration `yaml:"unlock_window"`
+}
+
+// CacheConfig configures the link cache used by redirects.
+type CacheConfig struct {
+	// Backend is one of memory, redis or none.
+	Backend     string        `yaml:"backend"`
+	TTL         time.Duration `yaml:"ttl"`
+	NegativeTTL time.Duration `yaml:"negative_ttl"`
+	Size        int           `yaml:"size"`
+	// UserTTL is how long users resolved by the auth middleware are cached
+	// by the same backend; 0 disables the user cache.
+	UserTTL time.Duration `yaml:"user_ttl"`
+}
+
+// WorkersConfig configures the background goroutines.
+type WorkersConfig struct {
+	ReaperInterval     time.Duration `yaml:"reaper_interval"`
+	ReaperArchive      bool          `yaml:"reaper_archive"`
+	VisitFlushInterval time.Duration `yaml:"visit_flush_interval"`
+	ClickQueueSize     int           `yaml:"click_queue_size"`
+}
+
+// PreviewsConfig configures fetching the title and Open Graph metadata of
+// new links' destinations.
+type PreviewsConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Workers   int           `yaml:"workers"`
+	QueueSize int           `yaml:"queue_size"`
+	Timeout   time.Duration `yaml:"timeout"`
+	// MaxBytes caps how much of a page is read.
+	MaxBytes int `yaml:"max_bytes"`
+	// AllowPrivateNetworks lets previews be fetched from loopback and
+	// private addresses, which are otherwise refused. For development only.
+	AllowPrivateNetworks bool `yaml:"allow_private_networks"`
+}
+
+// QRConfig configures the QR codes served for short links.
+type QRConfig struct {
+	// MaxSize bounds the requested image size in pixels.
+	MaxSize int `yaml:"max_size"`
+	// CacheSize is how many rendered images are kept in memory, for CacheTTL.
+	CacheSize int           `yaml:"cache_size"`
+	CacheTTL  time.Duration `yaml:"cache_ttl"`
+	// LogoFile is a PNG, JPEG or GIF image embedded on request; without
+	// it, logos aren't offered.
+	LogoFile string `yaml:"logo_file"`
+}
+
+// BulkConfig configures bulk link creation and deletion.
+type BulkConfig struct {
+	// MaxRows bounds the rows of a bulk request.
+	MaxRows int `yaml:"max_rows"`
+	// SyncRows is the most rows processed while the client waits; larger
+	// requests run as background jobs.
+	SyncRows int `yaml:"sync_rows"`
+	// Workers is how many jobs run at once; others wait in the queue.
+	Workers int `yaml:"workers"`
+	// RateLimit limits the bulk requests and imports of each user.
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+}
+
+// MailConfig configures outgoing email.
+type MailConfig struct {
+	// Backend is one of smtp, file or log.
+	Backend      string `yaml:"backend"`
+	From         string `yaml:"from"`
+	SMTPHost     string `yaml:"smtp_host"`
+	SMTPPort     int    `yaml:"smtp_port"`
+	SMTPUsername string `yaml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password"`
+	// Dir is where the file backend writes messages.
+	Dir string `yaml:"dir"`
+}
+
+// Default returns the configuration used when nothing is overridden.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:                  ":8080",
+			StaticDir:             "./frontend",
+			DefaultRedirectStatus: http.StatusFound,
+			PublicURL:             "http://localhost:8080",
+		},
+		Database: DatabaseConfig{
+			Host:    "localhost",
+			Port:    5432,
+			User:    "postgres",
+			Name:    "postgres",
+			SSLMode: "disable",
+		},
+		Redis: RedisConfig{
+			Addr: "localhost:6379",
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"*"},
+		},
+		RateLimit: RateLimitConfig{
+			RequestsPerSecond: 1,
+			Burst:             3,
+		},
+		Auth: AuthConfig{
+			TokenTTL:        15 * time.Minute,
+			RefreshTokenTTL: 30 * 24 * time.Hour,
+
+			VerificationTokenTTL:  48 * time.Hour,
+			PasswordResetTokenTTL: time.Hour,
+			InvitationTTL:         7 * 24 * time.Hour,
+			EmailRequests:         5,
+			EmailWindow:           15 * time.Minute,
+			OIDC: OIDCConfig{
+				Scopes: []string{"openid", "email"},
+			},
+		},
+		Links: LinksConfig{
+			GuestTTL:          24 * time.Hour,
+			ShortCodeStrategy: "random",
+			ShortCodeLength:   8,
+			UnlockTTL:         time.Hour,
+			UnlockAttempts:    5,
+			UnlockWindow:      15 * time.Minute,
+		},
+		Cache: CacheConfig{
+			Backend:     "memory",
+			TTL:         5 * time.Minute,
+			NegativeTTL: 30 * time.Second,
+			Size:        10000,
+			UserTTL:     time.Minute,
+		},
+		Workers: WorkersConfig{
+			ReaperInterval:     time.Hour,
+			VisitFlushInterval: 10 * time.Second,
+			ClickQueueSize:     1024,
+		},
+		Mail: MailConfig{
+			Backend:  "log",
+			From:     "url-shortener <no-reply@localhost>",
+			SMTPPort: 587,
+			Dir:      "./mail",
+		},
+		Previews: PreviewsConfig{
+			Enabled:   true,
+			Workers:   4,
+			QueueSize: 256,
+			Timeout:   5 * time.Second,
+			MaxBytes:  512 << 10,
+		},
+		QR: QRConfig{
+			MaxSize:   2048,
+			CacheSize: 1024,
+			CacheTTL:  time.Hour,
+		},
+		Bulk: BulkConfig{
+			MaxRows:  10000,
+			SyncRows: 100,
+			Workers:  2,
+			RateLimit: RateLimitConfig{
+				RequestsPerSecond: 0.1,
+				Burst:             5,
+			},
+		},
+	}
+}
+
+// Load builds the configuration from, in increasing order of precedence,
+// the defaults, a YAML file, environment variables and command-line flags.
+// The file is named by the -config flag or the CONFIG_FILE variable.
+func Load(args []string) (Config, error) {
+	cfg := Default()
+	bindings := cfg.bindings()
+
+	fs := flag.NewFlagSet("url-shortener", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML configuration file")
+	flagValues := make(map[string]string)
+	for _, b := range bindings {
+		name := b.flag
+		fs.Func(name, b.usage+" (env "+b.env+")", func(v string) error {
+			flagValues[name] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return cfg, fmt.Errorf("error reading config file: %v", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("error parsing config file %s: %v", *configFile, err)
+		}
+	}
+
+	for _, b := range bindings {
+		if v, ok := os.LookupEnv(b.env); ok && v != "" {
+			if err := b.set(v); err != nil {
+				return cfg, fmt.Errorf("invalid %s: %v", b.env, err)
+			}
+		}
+	}
+	for _, b := range bindings {
+		if v, ok := flagValues[b.flag]; ok {
+			if err := b.set(v); err != nil {
+				return cfg, fmt.Errorf("invalid -%s: %v", b.flag, err)
+			}
+		}
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// Validate checks that the configuration is usable.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr is required")
+	check(utils.IsValidRedirectStatus(c.Server.DefaultRedirectStatus), "server.default_redirect_status must be 301, 302, 307 or 308")
+	check(c.Database.Host != "", "database.host is required")
+	check(c.Database.Port > 0, "database.port must be positive")
+	check(c.Redis.Addr != "", "redis.addr is required")
+	check(c.RateLimit.RequestsPerSecond > 0, "rate_limit.requests_per_second must be positive")
+	check(c.RateLimit.Burst > 0, "rate_limit.burst must be positive")
+	errs = append(errs, c.Auth.validateKeys()...)
+	check(c.Auth.TokenTTL > 0, "auth.token_ttl must be positive")
+	check(c.Auth.RefreshTokenTTL > c.Auth.TokenTTL, "auth.refresh_token_ttl must be longer than auth.token_ttl")
+	check(c.Auth.VerificationTokenTTL > 0, "auth.verification_token_ttl must be positive")
+	check(c.Auth.PasswordResetTokenTTL > 0, "auth.password_reset_token_ttl must be positive")
+	check(c.Auth.InvitationTTL > 0, "auth.invitation_ttl must be positive")
+	check(c.Auth.EmailRequests > 0, "auth.email_requests must be positive")
+	check(c.Auth.EmailWindow > 0, "auth.email_window must be positive")
+	if c.Auth.OIDC.Enabled() {
+		check(isHTTPURL(c.Auth.OIDC.Issuer), "auth.oidc.issuer must be an http or https URL")
+		check(c.Auth.OIDC.ClientID != "", "auth.oidc.client_id is required with auth.oidc.issuer")
+		check(c.Auth.OIDC.RedirectURL == "" || isHTTPURL(c.Auth.OIDC.RedirectURL), "auth.oidc.redirect_url must be an http or https URL")
+	}
+	check(isHTTPURL(c.Server.PublicURL), "server.public_url must be an http or https URL")
+	if _, err := utils.ParseTrustedProxies(c.Server.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("server.trusted_proxies: %w", err))
+	}
+	check(oneOf(c.Mail.Backend, "smtp", "file", "log"), "mail.backend must be smtp, file or log")
+	check(c.Mail.From != "", "mail.from is required")
+	check(c.Mail.Backend != "smtp" || c.Mail.SMTPHost != "", "mail.smtp_host is required for the smtp backend")
+	check(c.Mail.Backend != "file" || c.Mail.Dir != "", "mail.dir is required for the file backend")
+	check(c.Links.GuestTTL > 0, "links.guest_ttl must be positive")
+	check(oneOf(c.Links.ShortCodeStrategy, "random", "sequence", "obfuscated", "words"),
+		"links.short_code_strategy must be random, sequence, obfuscated or words")
+	check(c.Links.ShortCodeLength >= 4, "links.short_code_length must be at least 4")
+	check(c.Links.UnlockTTL > 0, "links.unlock_ttl must be positive")
+	check(c.Links.UnlockAttempts > 0, "links.unlock_attempts must be positive")
+	check(c.Links.UnlockWindow > 0, "links.unlock_window must be positive")
+	check(oneOf(c.Cache.Backend, "memory", "redis", "none"), "cache.backend must be memory, redis or none")
+	check(c.Cache.Backend == "none" || c.Cache.TTL > 0, "cache.ttl must be positive")
+	check(c.Cache.Backend != "memory" || c.Cache.Size > 0, "cache.size must be positive")
+	check(c.Workers.ReaperInterval > 0, "workers.reaper_interval must be positive")
+	check(c.Workers.VisitFlushInterval > 0, "workers.visit_flush_interval must be positive")
+	check(c.Workers.ClickQueueSize > 0, "workers.click_queue_size must be positive")
+	if c.Previews.Enabled {
+		check(c.Previews.Workers > 0, "previews.workers must be positive")
+		check(c.Previews.QueueSize > 0, "previews.queue_size must be positive")
+		check(c.Previews.Timeout > 0, "previews.timeout must be positive")
+		check(c.Previews.MaxBytes > 0, "previews.max_bytes must be positive")
+	}
+	check(c.QR.MaxSize > 0, "qr.max_size must be positive")
+	check(c.QR.CacheSize > 0, "qr.cache_size must be positive")
+	check(c.QR.CacheTTL > 0, "qr.cache_ttl must be positive")
+	check(c.Bulk.MaxRows > 0, "bulk.max_rows must be positive")
+	check(c.Bulk.SyncRows >= 0, "bulk.sync_rows must not be negative")
+	check(c.Bulk.Workers > 0, "bulk.workers must be positive")
+	check(c.Bulk.RateLimit.RequestsPerSecond > 0, "bulk.rate_limit.requests_per_second must be positive")
+	check(c.Bulk.RateLimit.Burst > 0, "bulk.rate_limit.burst must be positive")
+
+	return errors.Join(errs...)
+}
+
+func (a AuthConfig) validateKeys() []error {
+	var errs []error
+	seen := make(map[string]bool)
+	for _, k := range a.Keys() {
+		switch {
+		case k.ID == "":
+			errs = append(errs, errors.New("auth.signing_keys: every key needs an id"))
+		case seen[k.ID]:
+			errs = append(errs, fmt.Errorf("auth.signing_keys: duplicate key id %q", k.ID))
+		case (k.Secret == "") == (k.PrivateKeyFile == ""):
+			errs = append(errs, fmt.Errorf("auth.signing_keys: key %q needs exactly one of secret and private_key_file", k.ID))
+		case k.Secret != "" && len(k.Secret) < 32:
+			errs = append(errs, fmt.Errorf("auth.signing_keys: secret of key %q must be at least 32 characters", k.ID))
+		}
+		seen[k.ID] = true
+	}
+	if a.ActiveKeyID != "" && !seen[a.ActiveKeyID] {
+		errs = append(errs, fmt.Errorf("auth.active_key_id: no signing key with id %q", a.ActiveKeyID))
+	}
+	return errs
+}
+
+func isHTTPURL(v string) bool {
+	return strings.HasPrefix(v, "http://") || strings.HasPrefix(v, "https://")
+}
+
+func oneOf(v string, allowed ...string) bool {
+	for _, a := range allowed {
+		if v == a {
+			return true
+		}
+	}
+	return false
+}
+
+// binding connects a setting to its environment variable and flag.
+type binding struct {
+	env, flag, usage string
+	set              func(string) error
+}
+
+func (c *Config) bindings() []binding {
+	return []binding{
+		stringVar("LISTEN_ADDR", "addr", "HTTP listen address", &c.Server.Addr),
+		stringVar("STATIC_DIR", "static-dir", "directory served at /", &c.Server.StaticDir),
+		intVar("DEFAULT_REDIRECT_STATUS", "default-redirect-status", "redirect status for links without their own", &c.Server.DefaultRedirectStatus),
+		stringVar("PUBLIC_URL", "public-url", "URL users reach the app at, used in emails", &c.Server.PublicURL),
+		listVar("TRUSTED_PROXIES", "trusted-proxies", "comma-separated reverse proxy addresses and CIDR ranges", &c.Server.TrustedProxies),
+
+		stringVar("DB_HOST", "db-host", "PostgreSQL host", &c.Database.Host),
+		intVar("DB_PORT", "db-port", "PostgreSQL port", &c.Database.Port),
+		stringVar("DB_USER", "db-user", "PostgreSQL user", &c.Database.User),
+		stringVar("DB_PASSWORD", "db-password", "PostgreSQL password", &c.Database.Password),
+		stringVar("DB_NAME", "db-name", "PostgreSQL database", &c.Database.Name),
+		stringVar("DB_SSLMODE", "db-sslmode", "PostgreSQL sslmode", &c.Database.SSLMode),
+
+		stringVar("REDIS_ADDR", "redis-addr", "Redis address", &c.Redis.Addr),
+		stringVar("REDIS_PASSWORD", "redis-password", "Redis password", &c.Redis.Password),
+		intVar("REDIS_DB", "redis-db", "Redis database index", &c.Redis.DB),
+
+		listVar("CORS_ALLOWED_ORIGINS", "cors-allowed-origins", "comma-separated allowed origins", &c.CORS.AllowedOrigins),
+		boolVar("CORS_DEBUG", "cors-debug", "log CORS decisions", &c.CORS.Debug),
+
+		floatVar("RATE_LIMIT_RPS", "rate-limit-rps", "create requests per second", &c.RateLimit.RequestsPerSecond),
+		intVar("RATE_LIMIT_BURST", "rate-limit-burst", "create request burst", &c.RateLimit.Burst),
+
+		stringVar("JWT_SECRET", "jwt-secret", "HS256 secret signing tokens", &c.Auth.JWTSecret),
+		stringVar("JWT_KEY_FILE", "jwt-key-file", "PEM RSA or Ed25519 private key signing tokens", &c.Auth.JWTKeyFile),
+		stringVar("JWT_ACTIVE_KEY_ID", "jwt-active-key-id", "ID of the signing key used for new tokens", &c.Auth.ActiveKeyID),
+		durationVar("TOKEN_TTL", "token-ttl", "access token lifetime", &c.Auth.TokenTTL),
+		durationVar("REFRESH_TOKEN_TTL", "refresh-token-ttl", "refresh token lifetime", &c.Auth.RefreshTokenTTL),
+		durationVar("VERIFICATION_TOKEN_TTL", "verification-token-ttl", "email verification link lifetime", &c.Auth.VerificationTokenTTL),
+		durationVar("PASSWORD_RESET_TOKEN_TTL", "password-reset-token-ttl", "password reset link lifetime", &c.Auth.PasswordResetTokenTTL),
+		durationVar("INVITATION_TTL", "invitation-ttl", "workspace invitation lifetime", &c.Auth.InvitationTTL),
+		intVar("EMAIL_REQUESTS", "email-requests", "verification and password reset emails allowed per client and window", &c.Auth.EmailRequests),
+		durationVar("EMAIL_WINDOW", "email-window", "window of email-requests", &c.Auth.EmailWindow),
+		stringVar("OIDC_ISSUER", "oidc-issuer", "OpenID Connect issuer URL, enables single sign-on", &c.Auth.OIDC.Issuer),
+		stringVar("OIDC_CLIENT_ID", "oidc-client-id", "OpenID Connect client ID", &c.Auth.OIDC.ClientID),
+		stringVar("OIDC_CLIENT_SECRET", "oidc-client-secret", "OpenID Connect client secret", &c.Auth.OIDC.ClientSecret),
+		stringVar("OIDC_REDIRECT_URL", "oidc-redirect-url", "OpenID Connect callback URL", &c.Auth.OIDC.RedirectURL),
+		listVar("OIDC_SCOPES", "oidc-scopes", "comma-separated OpenID Connect scopes", &c.Auth.OIDC.Scopes),
+
+		durationVar("GUEST_LINK_TTL", "guest-link-ttl", "lifetime of guest links", &c.Links.GuestTTL),
+		stringVar("SHORTCODE_STRATEGY", "shortcode-strategy", "random, sequence, obfuscated or words", &c.Links.ShortCodeStrategy),
+		intVar("SHORTCODE_LENGTH", "shortcode-length", "length of random short codes", &c.Links.ShortCodeLength),
+		stringVar("SHORTCODE_SALT", "shortcode-salt", "salt for obfuscated short codes", &c.Links.ShortCodeSalt),
+		durationVar("LINK_UNLOCK_TTL", "link-unlock-ttl", "how long a password-protected link stays unlocked", &c.Links.UnlockTTL),
+		intVar("LINK_UNLOCK_ATTEMPTS", "link-unlock-attempts", "wrong link passwords allowed per client and window", &c.Links.UnlockAttempts),
+		durationVar("LINK_UNLOCK_WINDOW", "link-unlock-window", "window of link-unlock-attempts", &c.Links.UnlockWindow),
+
+		stringVar("LINK_CACHE", "link-cache", "memory, redis or none", &c.Cache.Backend),
+		durationVar("LINK_CACHE_TTL", "link-cache-ttl", "lifetime of cached links", &c.Cache.TTL),
+		durationVar("LINK_CACHE_NEGATIVE_TTL", "link-cache-negative-ttl", "lifetime of cached misses", &c.Cache.NegativeTTL),
+		intVar("LINK_CACHE_SIZE", "link-cache-size", "entries kept by the memory cache", &c.Cache.Size),
+		durationVar("USER_CACHE_TTL", "user-cache-ttl", "lifetime of cached users, 0 to disable", &c.Cache.UserTTL),
+
+		durationVar("REAPER_INTERVAL", "reaper-interval", "how often expired links are purged", &c.Workers.ReaperInterval),
+		boolVar("REAPER_ARCHIVE", "reaper-archive", "archive expired links instead of deleting them", &c.Workers.ReaperArchive),
+		durationVar("VISIT_FLUSH_INTERVAL", "visit-flush-interval", "how often buffered visits are written", &c.Workers.VisitFlushInterval),
+		intVar("CLICK_QUEUE_SIZE", "click-queue-size", "clicks queued for the analytics writer", &c.Workers.ClickQueueSize),
+
+		stringVar("MAIL_BACKEND", "mail-backend", "smtp, file or log", &c.Mail.Backend),
+		stringVar("MAIL_FROM", "mail-from", "sender address of outgoing mail", &c.Mail.From),
+		stringVar("SMTP_HOST", "smtp-host", "SMTP server host", &c.Mail.SMTPHost),
+		intVar("SMTP_PORT", "smtp-port", "SMTP server port", &c.Mail.SMTPPort),
+		stringVar("SMTP_USERNAME", "smtp-username", "SMTP username", &c.Mail.SMTPUsername),
+		stringVar("SMTP_PASSWORD", "smtp-password", "SMTP password", &c.Mail.SMTPPassword),
+		stringVar("MAIL_DIR", "mail-dir", "directory the file mail backend writes to", &c.Mail.Dir),
+
+		boolVar("PREVIEWS_ENABLED", "previews-enabled", "fetch titles and Open Graph metadata of new links", &c.Previews.Enabled),
+		intVar("PREVIEW_WORKERS", "preview-workers", "concurrent preview fetches", &c.Previews.Workers),
+		intVar("PREVIEW_QUEUE_SIZE", "preview-queue-size", "links queued for preview fetching", &c.Previews.QueueSize),
+		durationVar("PREVIEW_TIMEOUT", "preview-timeout", "time limit of a preview fetch", &c.Previews.Timeout),
+		intVar("PREVIEW_MAX_BYTES", "preview-max-bytes", "bytes of a page read for its preview", &c.Previews.MaxBytes),
+		boolVar("PREVIEW_ALLOW_PRIVATE_NETWORKS", "preview-allow-private-networks", "fetch previews from private addresses (development only)", &c.Previews.AllowPrivateNetworks),
+
+		intVar("QR_MAX_SIZE", "qr-max-size", "largest QR code served, in pixels", &c.QR.MaxSize),
+		intVar("QR_CACHE_SIZE", "qr-cache-size", "rendered QR codes kept in memory", &c.QR.CacheSize),
+		durationVar("QR_CACHE_TTL", "qr-cache-ttl", "lifetime of cached QR codes", &c.QR.CacheTTL),
+		stringVar("QR_LOGO_FILE", "qr-logo-file", "image embedded in QR codes on request", &c.QR.LogoFile),
+
+		intVar("BULK_MAX_ROWS", "bulk-max-rows", "most rows of a bulk request", &c.Bulk.MaxRows),
+		intVar("BULK_SYNC_ROWS", "bulk-sync-rows", "most rows of a bulk request processed without a job", &c.Bulk.SyncRows),
+		intVar("BULK_WORKERS", "bulk-workers", "bulk jobs run at once", &c.Bulk.Workers),
+		floatVar("BULK_RATE_LIMIT_RPS", "bulk-rate-limit-rps", "bulk requests per second and user", &c.Bulk.RateLimit.RequestsPerSecond),
+		intVar("BULK_RATE_LIMIT_BURST", "bulk-rate-limit-burst", "bulk request burst per user", &c.Bulk.RateLimit.Burst),
+	}
+}
+
+func stringVar(env, name, usage string, p *string) binding {
+	return binding{env, name, usage, func(v string) error {
+		*p = v
+		return nil
+	}}
+}
+
+func intVar(env, name, usage string, p *int) binding {
+	return binding{env, name, usage, func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err == nil {
+			*p = n
+		}
+		return err
+	}}
+}
+
+func floatVar(env, name, usage string, p *float64) binding {
+	return binding{env, name, usage, func(v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err == nil {
+			*p = f
+		}
+		return err
+	}}
+}
+
+func boolVar(env, name, usage string, p *bool) binding {
+	return binding{env, name, usage, func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err == nil {
+			*p = b
+		}
+		return err
+	}}
+}
+
+func durationVar(env, name, usage string, p *time.Duration) binding {
+	return binding{env, name, usage, func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			*p = d
+		}
+		return err
+	}}
+}
+
+func listVar(env, name, usage string, p *[]string) binding {
+	return binding{env, name, usage, func(v string) error {
+		var items []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*p = items
+		return nil
+	}}
+}
@@ -0,0 +1,102 @@
+// config/config_test.go
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("default config is invalid: %v", err)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(`
+server:
+  addr: ":9000"
+database:
+  host: file-db
+  port: 6543
+links:
+  guest_ttl: 1h
+cache:
+  backend: redis
+`), 0o600)
+
+	t.Setenv("DB_HOST", "env-db")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://a.example, https://b.example")
+
+	cfg, err := Load([]string{"-config", path, "-db-host", "flag-db", "-rate-limit-burst", "10"})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.Server.Addr != ":9000" || cfg.Database.Port != 6543 || cfg.Links.GuestTTL != time.Hour || cfg.Cache.Backend != "redis" {
+		t.Errorf("file values not applied: %+v", cfg)
+	}
+	if cfg.Database.Host != "flag-db" {
+		t.Errorf("flag did not override env and file: got %q", cfg.Database.Host)
+	}
+	if len(cfg.CORS.AllowedOrigins) != 2 || cfg.CORS.AllowedOrigins[1] != "https://b.example" {
+		t.Errorf("env list not applied: %v", cfg.CORS.AllowedOrigins)
+	}
+	if cfg.RateLimit.Burst != 10 || cfg.RateLimit.RequestsPerSecond != 1 {
+		t.Errorf("unexpected rate limit: %+v", cfg.RateLimit)
+	}
+}
+
+func TestLoadRejectsInvalidConfig(t *testing.T) {
+	t.Setenv("DEFAULT_REDIRECT_STATUS", "303")
+	t.Setenv("LINK_CACHE", "memcached")
+
+	_, err := Load(nil)
+	if err == nil {
+		t.Fatal("Load accepted an invalid config")
+	}
+	for _, want := range []string{"default_redirect_status", "cache.backend"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+
+	t.Setenv("REDIS_DB", "one")
+	if _, err := Load(nil); err == nil || !strings.Contains(err.Error(), "REDIS_DB") {
+		t.Errorf("unparsable env var not reported: %v", err)
+	}
+}
+
+func TestValidateSigningKeys(t *testing.T) {
+	cfg := Default()
+	cfg.Auth.JWTSecret = "a-default-secret-that-is-32-bytes"
+	cfg.Auth.SigningKeys = []SigningKeyConfig{
+		{ID: "next", PrivateKeyFile: "next.pem"},
+		{ID: "next", Secret: "another-secret-that-is-32-bytes-long"},
+		{ID: "short", Secret: "too-short"},
+		{ID: "both", Secret: "yet-another-secret-of-32-bytes-long", PrivateKeyFile: "both.pem"},
+	}
+	cfg.Auth.ActiveKeyID = "missing"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate accepted invalid signing keys")
+	}
+	for _, want := range []string{`duplicate key id "next"`, `key "short" must be at least 32`, `key "both" needs exactly one`, `no signing key with id "missing"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+
+	cfg.Auth.SigningKeys = cfg.Auth.SigningKeys[:1]
+	cfg.Auth.ActiveKeyID = ""
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("valid signing keys rejected: %v", err)
+	}
+	if got := cfg.Auth.ActiveKey(); got != DefaultKeyID {
+		t.Errorf("ActiveKey() = %q, want %q", got, DefaultKeyID)
+	}
+}
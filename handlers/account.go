@@ -0,0 +1,306 @@
+// handlers/account.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+	"url-shortener/auth"
+	"url-shortener/mailer"
+	"url-shortener/models"
+	"url-shortener/storage"
+	"url-shortener/utils"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// accountTokenRequest is the payload accepted by VerifyEmailHandler.
+type accountTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// resetPasswordRequest is the payload accepted by ResetPasswordHandler.
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// changePasswordRequest is the payload accepted by ChangePasswordHandler.
+type changePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// VerifyEmailHandler confirms a user's email address with the token from
+// their verification email.
+func (h *Handler) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var req accountTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	now := time.Now()
+	token, ok := h.useAccountToken(w, req.Token, models.TokenPurposeVerifyEmail, now)
+	if !ok {
+		return
+	}
+	if err := h.users.MarkEmailVerified(token.UserID, now); err != nil {
+		log.Printf("Error verifying email: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResendVerificationHandler emails the signed-in user a new verification
+// link, invalidating earlier ones.
+func (h *Handler) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok || !h.allowEmailRequest(w, r, models.TokenPurposeVerifyEmail) {
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		writeError(w, http.StatusConflict, "already_verified", "email address is already verified")
+		return
+	}
+	if err := h.accounts.DeleteAccountTokens(user.ID, models.TokenPurposeVerifyEmail); err != nil {
+		log.Printf("Error deleting verification tokens: %v", err)
+	}
+	if err := h.sendVerificationEmail(r.Context(), user); err != nil {
+		log.Printf("Error sending verification email: %v", err)
+		http.Error(w, "Failed to send email", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// emailTimeout bounds sending the emails sent after the response.
+const emailTimeout = 30 * time.Second
+
+// ForgotPasswordHandler emails a password reset link if the address
+// belongs to a user. It always responds 202, without waiting for the
+// email, so it can't be used to find out which addresses are registered.
+func (h *Handler) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "email is required")
+		return
+	}
+	if !h.allowEmailRequest(w, r, models.TokenPurposeResetPassword) {
+		return
+	}
+
+	user, err := h.users.GetUserByEmail(req.Email)
+	switch {
+	case err == nil:
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), emailTimeout)
+			defer cancel()
+			if err := h.accounts.DeleteAccountTokens(user.ID, models.TokenPurposeResetPassword); err != nil {
+				log.Printf("Error deleting password reset tokens: %v", err)
+			}
+			if err := h.sendPasswordResetEmail(ctx, user); err != nil {
+				log.Printf("Error sending password reset email: %v", err)
+			}
+		}()
+	case !errors.Is(err, storage.ErrUserNotFound):
+		log.Printf("Error retrieving user by email: %v", err)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// allowEmailRequest counts a request from the client for an email of the
+// given purpose, responding 429 instead once the client made emailLimit of
+// them in emailWindow.
+func (h *Handler) allowEmailRequest(w http.ResponseWriter, r *http.Request, purpose string) bool {
+	key := h.trustedProxies.ClientIP(r) + " " + purpose
+	now := time.Now()
+	if wait := h.emailRequests.retryAfter(key, now); wait > 0 {
+		setRetryAfter(w, wait)
+		writeError(w, http.StatusTooManyRequests, "too_many_requests", "too many emails requested; try again later")
+		return false
+	}
+	// Every request counts, as wrong passwords do for unlockAttempts
+	h.emailRequests.fail(key, now)
+	return true
+}
+
+// ResetPasswordHandler sets a new password with the token from a password
+// reset email and signs the user out everywhere.
+func (h *Handler) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "token and password are required")
+		return
+	}
+	// Check the password first so a rejected one doesn't use up the token
+	if err := utils.ValidatePassword(req.Password); err != nil {
+		writeError(w, http.StatusBadRequest, "weak_password", err.Error())
+		return
+	}
+
+	now := time.Now()
+	token, ok := h.useAccountToken(w, req.Token, models.TokenPurposeResetPassword, now)
+	if !ok {
+		return
+	}
+	if !h.setPassword(w, token.UserID, req.Password) {
+		return
+	}
+	if err := h.accounts.DeleteAccountTokens(token.UserID, models.TokenPurposeResetPassword); err != nil {
+		log.Printf("Error deleting password reset tokens: %v", err)
+	}
+	// Following the emailed link proves the user owns the address
+	if err := h.users.MarkEmailVerified(token.UserID, now); err != nil {
+		log.Printf("Error verifying email: %v", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ChangePasswordHandler changes the signed-in user's password. Every
+// session, including the current one, is revoked and a new session is
+// started for the caller.
+func (h *Handler) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req changePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	// The signed-in user may come from the user cache, which leaves out
+	// password hashes
+	stored, err := h.users.GetUserByEmail(user.Email)
+	if err != nil {
+		log.Printf("Error retrieving user by email: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte(req.CurrentPassword)) != nil {
+		writeError(w, http.StatusForbidden, "invalid_password", "current password is incorrect")
+		return
+	}
+	if err := utils.ValidatePassword(req.NewPassword); err != nil {
+		writeError(w, http.StatusBadRequest, "weak_password", err.Error())
+		return
+	}
+	if !h.setPassword(w, user.ID, req.NewPassword) {
+		return
+	}
+
+	err = h.mailer.Send(r.Context(), mailer.Message{
+		To:      user.Email,
+		Subject: "Your password was changed",
+		Body:    "The password of your account was just changed and all other devices were signed out.\n\nIf this wasn't you, reset your password at " + h.publicURL + " right away.\n",
+	})
+	if err != nil {
+		log.Printf("Error sending password change email: %v", err)
+	}
+	h.startSession(w, user, http.StatusOK)
+}
+
+// useAccountToken redeems an emailed token, responding 400 if it is unknown,
+// used, expired or meant for another purpose.
+func (h *Handler) useAccountToken(w http.ResponseWriter, secret, purpose string, now time.Time) (models.AccountToken, bool) {
+	token, err := h.accounts.UseAccountToken(auth.HashToken(secret), purpose, now)
+	if errors.Is(err, storage.ErrAccountTokenInvalid) {
+		writeError(w, http.StatusBadRequest, "invalid_token", err.Error())
+		return token, false
+	}
+	if err != nil {
+		log.Printf("Error using account token: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return token, false
+	}
+	return token, true
+}
+
+// setPassword stores a new password for the user and revokes all of their
+// sessions.
+func (h *Handler) setPassword(w http.ResponseWriter, userID int, password string) bool {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return false
+	}
+	if err := h.users.UpdatePassword(userID, string(hashedPassword)); err != nil {
+		log.Printf("Error updating password: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	if err := h.sessions.RevokeUserSessions(userID, time.Now()); err != nil {
+		log.Printf("Error revoking sessions: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) sendVerificationEmail(ctx context.Context, user models.User) error {
+	link, err := h.issueAccountToken(user, models.TokenPurposeVerifyEmail, "verify_token", h.verifyTTL)
+	if err != nil {
+		return err
+	}
+	return h.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body:    fmt.Sprintf("Confirm your email address by opening this link:\n\n%s\n\nThe link expires in %s.\n", link, formatTTL(h.verifyTTL)),
+	})
+}
+
+func (h *Handler) sendPasswordResetEmail(ctx context.Context, user models.User) error {
+	link, err := h.issueAccountToken(user, models.TokenPurposeResetPassword, "reset_token", h.resetTTL)
+	if err != nil {
+		return err
+	}
+	return h.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Choose a new password by opening this link:\n\n%s\n\nThe link expires in %s and works once. "+
+			"If you didn't ask to reset your password, you can ignore this email.\n", link, formatTTL(h.resetTTL)),
+	})
+}
+
+// issueAccountToken stores a new token for user and returns the frontend
+// link carrying it in the param query parameter.
+func (h *Handler) issueAccountToken(user models.User, purpose, param string, ttl time.Duration) (string, error) {
+	secret := randomToken(32)
+	err := h.accounts.SaveAccountToken(models.AccountToken{
+		Hash:      auth.HashToken(secret),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return h.publicURL + "/?" + url.Values{param: {secret}}.Encode(), nil
+}
+
+// formatTTL renders a token lifetime for an email, e.g. "2 days".
+func formatTTL(d time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case d == day:
+		return "1 day"
+	case d%day == 0:
+		return fmt.Sprintf("%d days", d/day)
+	case d == time.Hour:
+		return "1 hour"
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	default:
+		return fmt.Sprintf("%d minutes", d/time.Minute)
+	}
+}
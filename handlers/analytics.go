@@ -0,0 +1,133 @@
+// handlers/analytics.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	"url-shortener/analytics"
+	"url-shortener/models"
+	"url-shortener/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// topValuesLimit is how many entries each top-N list in the analytics
+// response holds.
+const topValuesLimit = 10
+
+// defaultWindows is how far back the series reaches when no since parameter
+// is given.
+var defaultWindows = map[string]time.Duration{
+	storage.BucketHour: 48 * time.Hour,
+	storage.BucketDay:  30 * 24 * time.Hour,
+	storage.BucketWeek: 26 * 7 * 24 * time.Hour,
+}
+
+// bucketLengths approximates each bucket size, to bound the series length.
+var bucketLengths = map[string]time.Duration{
+	storage.BucketHour: time.Hour,
+	storage.BucketDay:  24 * time.Hour,
+	storage.BucketWeek: 7 * 24 * time.Hour,
+}
+
+// maxSeriesBuckets caps the number of entries in a returned series.
+const maxSeriesBuckets = 2000
+
+// analyticsResponse is the body returned by GetURLAnalyticsHandler.
+type analyticsResponse struct {
+	VisitCount int `json:"visitCount"`
+	// Link is set for registered users' links.
+	Link         *models.URLMapping   `json:"link,omitempty"`
+	Bucket       string               `json:"bucket"`
+	Since        time.Time            `json:"since"`
+	Series       []models.ClickBucket `json:"series"`
+	TopReferrers []models.ClickCount  `json:"topReferrers"`
+	TopBrowsers  []models.ClickCount  `json:"topBrowsers"`
+	TopCountries []models.ClickCount  `json:"topCountries"`
+}
+
+// GetURLAnalyticsHandler handles requests for getting URL analytics. The
+// optional bucket (hour, day or week) and since (RFC 3339) query parameters
+// control the time series. Analytics of registered users' links are only
+// visible to their owner, or to the members of their workspace.
+func (h *Handler) GetURLAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+
+	var visitCount int
+	var link *models.URLMapping
+	urlMapping, err := h.links.GetURLMappingByShortCode(shortCode)
+	switch {
+	case err == nil:
+		user, ok := requireUser(w, r)
+		if !ok {
+			return
+		}
+		if !h.authorizeURLMapping(w, user, urlMapping, models.RoleViewer) {
+			return
+		}
+		// The mapping may come from the link cache, so read the count fresh
+		visitCount, err = h.links.GetURLVisitCount(urlMapping.UserID, shortCode)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		urlMapping.VisitCount = visitCount
+		link = &urlMapping
+	case errors.Is(err, storage.ErrURLNotFound):
+		visitCount, err = h.guests.GetVisitCount(shortCode)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	bucket := r.URL.Query().Get("bucket")
+	if bucket == "" {
+		bucket = storage.BucketDay
+	}
+	window, ok := defaultWindows[bucket]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_bucket", "bucket must be hour, day or week")
+		return
+	}
+	now := time.Now().UTC()
+	since := now.Add(-window)
+	if s := r.URL.Query().Get("since"); s != "" {
+		since, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_since", "since must be an RFC 3339 timestamp")
+			return
+		}
+	}
+	if now.Sub(since)/bucketLengths[bucket] > maxSeriesBuckets {
+		writeError(w, http.StatusBadRequest, "invalid_since", "since is too far back for the requested bucket")
+		return
+	}
+
+	resp := analyticsResponse{VisitCount: visitCount, Link: link, Bucket: bucket, Since: since}
+	series, err := h.clicks.ClickSeries(shortCode, bucket, since)
+	if err == nil {
+		resp.Series = analytics.FillSeries(series, bucket, since, now)
+		resp.TopReferrers, err = h.clicks.TopClickValues(shortCode, storage.DimensionReferrer, since, topValuesLimit)
+	}
+	if err == nil {
+		resp.TopBrowsers, err = h.clicks.TopClickValues(shortCode, storage.DimensionBrowser, since, topValuesLimit)
+	}
+	if err == nil {
+		resp.TopCountries, err = h.clicks.TopClickValues(shortCode, storage.DimensionCountry, since, topValuesLimit)
+	}
+	if err != nil {
+		log.Printf("Error aggregating clicks: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
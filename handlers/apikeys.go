@@ -0,0 +1,132 @@
+// handlers/apikeys.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"url-shortener/auth"
+	"url-shortener/models"
+	"url-shortener/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// apiKeyPrefix starts every API key so leaked keys are easy to recognise.
+const apiKeyPrefix = "usk_"
+
+var errAPIKeyNotFound = errors.New("API key not found")
+
+// createAPIKeyRequest is the payload accepted by CreateAPIKeyHandler.
+type createAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// createAPIKeyResponse carries the only copy of the new key's secret.
+type createAPIKeyResponse struct {
+	models.APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKeyHandler issues a new API key for the signed-in user. The key
+// itself is only returned by this call. The route must not accept API keys,
+// so a leaked key can't be used to mint more keys.
+func (h *Handler) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req createAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 255 {
+		writeError(w, http.StatusBadRequest, "invalid_request", "name must be between 1 and 255 characters")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_scope", "at least one scope is required")
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !auth.ValidScope(scope) {
+			writeError(w, http.StatusBadRequest, "invalid_scope", "unknown scope "+scope+"; valid scopes are "+strings.Join(auth.Scopes, ", "))
+			return
+		}
+	}
+
+	secret := apiKeyPrefix + randomToken(32)
+	key, err := h.apiKeys.CreateAPIKey(models.APIKey{
+		UserID:    user.ID,
+		Name:      req.Name,
+		Prefix:    secret[:len(apiKeyPrefix)+8],
+		Hash:      auth.HashToken(secret),
+		Scopes:    req.Scopes,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("Error creating API key: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createAPIKeyResponse{APIKey: key, Key: secret})
+}
+
+// ListAPIKeysHandler lists the signed-in user's API keys, including revoked
+// ones, without their secrets.
+func (h *Handler) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := h.apiKeys.ListAPIKeys(user.ID)
+	if err != nil {
+		log.Printf("Error listing API keys: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if keys == nil {
+		keys = []models.APIKey{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// RevokeAPIKeyHandler revokes one of the signed-in user's API keys.
+func (h *Handler) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, errAPIKeyNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	err = h.apiKeys.RevokeAPIKey(user.ID, id, time.Now())
+	if errors.Is(err, storage.ErrAPIKeyNotFound) {
+		http.Error(w, errAPIKeyNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking API key: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
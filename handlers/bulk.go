@@ -0,0 +1,581 @@
+// handlers/bulk.go
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"url-shortener/models"
+	"url-shortener/storage"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxBulkBodyBytes bounds the body of a bulk request.
+	maxBulkBodyBytes = 10 << 20
+	// bulkProgressRows is how many rows a job processes between progress
+	// updates.
+	bulkProgressRows = 100
+)
+
+// bulkRow is a row of a bulk request, numbered from 1, or why it couldn't be
+// read. Imported links also keep their creation time and visits, and say
+// whether they were password-protected.
+type bulkRow struct {
+	index         int
+	link          createURLRequest
+	shortCode     string
+	createdAt     *time.Time
+	visits        int
+	lastVisitedAt *time.Time
+	protected     bool
+	err           error
+}
+
+// bulkResponse is the response to a bulk request processed synchronously.
+type bulkResponse struct {
+	Total     int                 `json:"total"`
+	Succeeded int                 `json:"succeeded"`
+	Failed    int                 `json:"failed"`
+	Results   []models.BulkResult `json:"results"`
+}
+
+// BulkCreateHandler creates a link for each row of a JSON array of create
+// requests or of a CSV file, uploaded as text/csv or as the file field of a
+// form. CSV columns are named like the JSON fields; tags are separated by
+// commas. Rows fail or succeed on their own, as CreateShortURLHandler would
+// respond to them; see runBulk for how they are processed.
+func (h *Handler) BulkCreateHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	rows, ok := h.readBulkRows(w, r, map[string]bulkParser{formatJSON: parseCreateJSON, formatCSV: parseCreateCSV})
+	if !ok {
+		return
+	}
+
+	roles := make(map[int]error)
+	h.runBulk(w, r, user, models.BulkCreate, rows, func(row bulkRow) models.BulkResult {
+		return h.bulkCreateRow(user, row, roles)
+	})
+}
+
+// bulkCreateRow creates the link of a row for user. roles caches the
+// outcome of workspace role checks, so they're made once per workspace.
+func (h *Handler) bulkCreateRow(user models.User, row bulkRow, roles map[int]error) models.BulkResult {
+	result := models.BulkResult{Row: row.index, OriginalURL: row.link.OriginalURL}
+	urlMapping, isNew, err := models.URLMapping{}, false, row.err
+	if err == nil && row.link.WorkspaceID != 0 {
+		roleErr, checked := roles[row.link.WorkspaceID]
+		if !checked {
+			_, roleErr = h.workspaceRole(user, row.link.WorkspaceID, models.RoleEditor)
+			roles[row.link.WorkspaceID] = roleErr
+		}
+		err = roleErr
+	}
+	now := time.Now()
+	if err == nil {
+		urlMapping, err = row.link.newURLMapping(now)
+	}
+	if err == nil {
+		if row.createdAt != nil && row.createdAt.Before(now) {
+			urlMapping.CreatedAt, urlMapping.UpdatedAt = *row.createdAt, *row.createdAt
+		}
+		err = h.checkAlias(row.link.Alias)
+	}
+	if err == nil {
+		urlMapping, isNew, err = h.createUserLink(user, row.link, urlMapping)
+	}
+	if err != nil {
+		result.Error = bulkError(err)
+		return result
+	}
+	if isNew && (row.visits > 0 || row.lastVisitedAt != nil) {
+		if err := h.links.SetURLVisits(urlMapping.ShortCode, row.visits, row.lastVisitedAt); err != nil {
+			log.Printf("Error restoring visit count of %s: %v", urlMapping.ShortCode, err)
+		}
+	}
+	result.ShortCode, result.OriginalURL, result.IsNew = urlMapping.ShortCode, urlMapping.OriginalURL, isNew
+	return result
+}
+
+// BulkDeleteHandler deletes the links named by a JSON array of short codes,
+// or by the shortCode column of a CSV file. The caller must be allowed to
+// delete each of them, as with DeleteURLHandler.
+func (h *Handler) BulkDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	rows, ok := h.readBulkRows(w, r, map[string]bulkParser{formatJSON: parseDeleteJSON, formatCSV: parseDeleteCSV})
+	if !ok {
+		return
+	}
+
+	h.runBulk(w, r, user, models.BulkDelete, rows, func(row bulkRow) models.BulkResult {
+		result := models.BulkResult{Row: row.index, ShortCode: row.shortCode}
+		err := row.err
+		var urlMapping models.URLMapping
+		if err == nil {
+			urlMapping, err = h.links.GetURLMappingByShortCode(row.shortCode)
+		}
+		if err == nil {
+			err = h.urlMappingAccess(user, urlMapping, models.RoleEditor)
+		}
+		if err == nil {
+			err = h.links.DeleteURLMapping(urlMapping.UserID, row.shortCode)
+		}
+		if errors.Is(err, storage.ErrURLNotFound) {
+			err = errShortURLNotFound
+		}
+		if err != nil {
+			result.Error = bulkError(err)
+		}
+		return result
+	})
+}
+
+// GetBulkJobHandler reports the progress of one of the caller's bulk jobs,
+// and its results once done.
+func (h *Handler) GetBulkJobHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := h.jobs.GetBulkJob(mux.Vars(r)["id"])
+	if errors.Is(err, storage.ErrJobNotFound) || err == nil && job.UserID != user.ID {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// runBulk processes rows with process. Up to the configured number of rows
+// are processed while the client waits, unless the async query parameter is
+// set; larger requests become a job, answered with 202 Accepted and its
+// location.
+func (h *Handler) runBulk(w http.ResponseWriter, r *http.Request, user models.User, kind string, rows []bulkRow, process func(bulkRow) models.BulkResult) {
+	async := len(rows) > h.bulk.SyncRows
+	if v := r.URL.Query().Get("async"); v != "" {
+		forced, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_async", "async must be true or false")
+			return
+		}
+		async = async || forced
+	}
+
+	if !async {
+		response := bulkResponse{Total: len(rows), Results: make([]models.BulkResult, 0, len(rows))}
+		for _, row := range rows {
+			result := process(row)
+			if result.Error != nil {
+				response.Failed++
+			} else {
+				response.Succeeded++
+			}
+			response.Results = append(response.Results, result)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	job := models.BulkJob{
+		ID:        randomToken(16),
+		UserID:    user.ID,
+		Kind:      kind,
+		Status:    models.JobQueued,
+		Total:     len(rows),
+		CreatedAt: time.Now(),
+	}
+	job.UpdatedAt = job.CreatedAt
+	if err := h.jobs.CreateBulkJob(job); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	go h.runBulkJob(job, rows, process)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/bulk/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// runBulkJob processes the rows of job once one of the job slots is free,
+// recording its progress in the JobStore. The job is updated at least every
+// storage.BulkJobHeartbeat, while it waits too, so that storage.JobReaper
+// can tell it from those of stopped instances.
+func (h *Handler) runBulkJob(job models.BulkJob, rows []bulkRow, process func(bulkRow) models.BulkResult) {
+	lastUpdate := time.Now()
+	update := func() {
+		if err := h.jobs.UpdateBulkJob(job); err != nil {
+			log.Printf("Error updating bulk job %s: %v", job.ID, err)
+		}
+		lastUpdate = time.Now()
+	}
+
+	heartbeat := time.NewTicker(storage.BulkJobHeartbeat)
+	for queued := true; queued; {
+		select {
+		case h.bulkSlots <- struct{}{}:
+			queued = false
+		case <-heartbeat.C:
+			update()
+		}
+	}
+	heartbeat.Stop()
+	defer func() { <-h.bulkSlots }()
+
+	job.Status = models.JobRunning
+	update()
+
+	results := make([]models.BulkResult, 0, len(rows))
+	for _, row := range rows {
+		result := process(row)
+		if result.Error != nil {
+			job.Failed++
+		} else {
+			job.Succeeded++
+		}
+		results = append(results, result)
+		job.Processed++
+		if job.Processed < job.Total &&
+			(job.Processed%bulkProgressRows == 0 || time.Since(lastUpdate) >= storage.BulkJobHeartbeat) {
+			update()
+		}
+	}
+
+	finishedAt := time.Now()
+	job.Status, job.Results, job.FinishedAt = models.JobDone, results, &finishedAt
+	update()
+}
+
+// bulkError turns the error of a row into its result's error.
+func bulkError(err error) *models.BulkError {
+	var reqErr *requestError
+	if !errors.As(err, &reqErr) {
+		log.Printf("Error processing bulk row: %v", err)
+		return &models.BulkError{Code: "internal_error", Message: "Internal Server Error"}
+	}
+	code := reqErr.code
+	if code == "" {
+		// Plain text errors are named after their status, such as not_found
+		code = strings.ReplaceAll(strings.ToLower(http.StatusText(reqErr.status)), " ", "_")
+	}
+	return &models.BulkError{Code: code, Message: reqErr.message}
+}
+
+// Formats of bulk request bodies.
+const (
+	formatJSON      = "json"
+	formatCSV       = "csv"
+	formatNDJSON    = "ndjson"
+	formatBookmarks = "html"
+)
+
+// bulkMediaTypes and bulkExtensions map the content types and file name
+// extensions of bulk request bodies to their format.
+var (
+	bulkMediaTypes = map[string]string{
+		"application/json":     formatJSON,
+		"text/csv":             formatCSV,
+		"application/x-ndjson": formatNDJSON,
+		"application/ndjson":   formatNDJSON,
+		"text/html":            formatBookmarks,
+	}
+	bulkExtensions = map[string]string{
+		".json":   formatJSON,
+		".csv":    formatCSV,
+		".ndjson": formatNDJSON,
+		".jsonl":  formatNDJSON,
+		".html":   formatBookmarks,
+		".htm":    formatBookmarks,
+	}
+)
+
+// bulkParser reads the rows of a bulk request body.
+type bulkParser func(body []byte) ([]bulkRow, error)
+
+// readBulkRows reads the rows of a bulk request with the parser for its
+// format, or writes an error and returns false. The format is named by the
+// format query parameter, or else by the content type: a JSON body by
+// default, or a file uploaded as the file field of a form, in the format of
+// its extension and otherwise CSV.
+func (h *Handler) readBulkRows(w http.ResponseWriter, r *http.Request, parsers map[string]bulkParser) ([]bulkRow, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBulkBodyBytes)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var body []byte
+	var err error
+	format := bulkMediaTypes[mediaType]
+	switch mediaType {
+	case "":
+		format = formatJSON
+		body, err = io.ReadAll(r.Body)
+	case "multipart/form-data":
+		var file multipart.File
+		var header *multipart.FileHeader
+		if file, header, err = r.FormFile("file"); err == nil {
+			body, err = io.ReadAll(file)
+			file.Close()
+			format = bulkExtensions[strings.ToLower(path.Ext(header.Filename))]
+			if format == "" {
+				format = formatCSV
+			}
+		} else if errors.Is(err, http.ErrMissingFile) {
+			writeError(w, http.StatusBadRequest, "invalid_body", "the form has no file field")
+			return nil, false
+		}
+	default:
+		body, err = io.ReadAll(r.Body)
+	}
+	if v := r.URL.Query().Get("format"); v != "" {
+		format = strings.ToLower(v)
+	}
+	parse, ok := parsers[format]
+	if !ok {
+		formats := make([]string, 0, len(parsers))
+		for f := range parsers {
+			formats = append(formats, f)
+		}
+		sort.Strings(formats)
+		writeError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "the body must be one of: "+strings.Join(formats, ", "))
+		return nil, false
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", fmt.Sprintf("the body must be at most %d bytes", maxBulkBodyBytes))
+		return nil, false
+	} else if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+
+	rows, err := parse(body)
+	if err != nil {
+		writeRequestError(w, err)
+		return nil, false
+	}
+	switch {
+	case len(rows) == 0:
+		writeError(w, http.StatusBadRequest, "no_rows", "the request has no rows")
+		return nil, false
+	case len(rows) > h.bulk.MaxRows:
+		writeError(w, http.StatusBadRequest, "too_many_rows", fmt.Sprintf("a bulk request can have at most %d rows", h.bulk.MaxRows))
+		return nil, false
+	}
+	return rows, true
+}
+
+// parseJSONArray splits body into the elements of a JSON array.
+func parseJSONArray(body []byte) ([]json.RawMessage, error) {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(body, &elements); err != nil {
+		return nil, badRequest("invalid_body", "the body must be a JSON array")
+	}
+	return elements, nil
+}
+
+func parseCreateJSON(body []byte) ([]bulkRow, error) {
+	elements, err := parseJSONArray(body)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]bulkRow, len(elements))
+	for i, element := range elements {
+		rows[i].index = i + 1
+		if err := json.Unmarshal(element, &rows[i].link); err != nil {
+			rows[i].err = badRequest("invalid_row", err.Error())
+		}
+	}
+	return rows, nil
+}
+
+func parseDeleteJSON(body []byte) ([]bulkRow, error) {
+	elements, err := parseJSONArray(body)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]bulkRow, len(elements))
+	for i, element := range elements {
+		rows[i].index = i + 1
+		if err := json.Unmarshal(element, &rows[i].shortCode); err != nil {
+			rows[i].err = badRequest("invalid_row", "short codes must be strings")
+		}
+	}
+	return rows, nil
+}
+
+// csvCreateColumns sets the field of a row named by a CSV column, by its
+// lowercased name.
+var csvCreateColumns = map[string]func(row *bulkRow, v string) error{
+	"originalurl":  func(row *bulkRow, v string) error { row.link.OriginalURL = v; return nil },
+	"alias":        func(row *bulkRow, v string) error { row.link.Alias = v; return nil },
+	"expiresat":    csvTime(func(row *bulkRow, t *time.Time) { row.link.ExpiresAt = t }, "invalid_expiration", "expiresAt"),
+	"maxvisits":    csvInt(func(row *bulkRow) *int { return &row.link.MaxVisits }, "invalid_expiration", "maxVisits"),
+	"redirecttype": csvInt(func(row *bulkRow) *int { return &row.link.RedirectType }, "invalid_redirect_type", "redirectType"),
+	"workspaceid":  csvInt(func(row *bulkRow) *int { return &row.link.WorkspaceID }, "invalid_workspace", "workspaceId"),
+	"title":        func(row *bulkRow, v string) error { row.link.Title = v; return nil },
+	"description":  func(row *bulkRow, v string) error { row.link.Description = v; return nil },
+	"tags": func(row *bulkRow, v string) error {
+		for _, tag := range strings.Split(v, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				row.link.Tags = append(row.link.Tags, tag)
+			}
+		}
+		return nil
+	},
+	"ogtitle":       func(row *bulkRow, v string) error { row.link.OGTitle = v; return nil },
+	"ogdescription": func(row *bulkRow, v string) error { row.link.OGDescription = v; return nil },
+	"ogimage":       func(row *bulkRow, v string) error { row.link.OGImage = v; return nil },
+	"password":      func(row *bulkRow, v string) error { row.link.Password = v; return nil },
+}
+
+func csvInt(field func(*bulkRow) *int, code, name string) func(*bulkRow, string) error {
+	return func(row *bulkRow, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return badRequest(code, name+" must be a number")
+		}
+		*field(row) = n
+		return nil
+	}
+}
+
+func csvTime(set func(*bulkRow, *time.Time), code, name string) func(*bulkRow, string) error {
+	return func(row *bulkRow, v string) error {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return badRequest(code, name+" must be an RFC 3339 time")
+		}
+		set(row, &t)
+		return nil
+	}
+}
+
+func parseCreateCSV(body []byte) ([]bulkRow, error) {
+	return parseCSVRows(body, csvCreateColumns)
+}
+
+// parseCSVRows reads the rows of a CSV with the given columns, of which
+// originalUrl is required.
+func parseCSVRows(body []byte, columns map[string]func(*bulkRow, string) error) ([]bulkRow, error) {
+	header, records, err := readCSV(body)
+	if err != nil {
+		return nil, err
+	}
+	hasURL := false
+	for _, name := range header {
+		if _, ok := columns[name]; !ok {
+			return nil, badRequest("invalid_csv", "unknown column "+name)
+		}
+		hasURL = hasURL || name == "originalurl"
+	}
+	if !hasURL {
+		return nil, badRequest("invalid_csv", "the CSV has no originalUrl column")
+	}
+
+	rows := make([]bulkRow, len(records))
+	for i, record := range records {
+		rows[i] = bulkRow{index: i + 1, err: record.err}
+		for j, v := range record.fields {
+			if v = strings.TrimSpace(v); v != "" && rows[i].err == nil {
+				rows[i].err = columns[header[j]](&rows[i], v)
+			}
+		}
+	}
+	return rows, nil
+}
+
+// parseDeleteCSV reads the shortCode column of a CSV, ignoring the others
+// so an exported file can be sent back.
+func parseDeleteCSV(body []byte) ([]bulkRow, error) {
+	header, records, err := readCSV(body)
+	if err != nil {
+		return nil, err
+	}
+	column := -1
+	for i, name := range header {
+		if name == "shortcode" {
+			column = i
+		}
+	}
+	if column < 0 {
+		return nil, badRequest("invalid_csv", "the CSV has no shortCode column")
+	}
+
+	rows := make([]bulkRow, len(records))
+	for i, record := range records {
+		rows[i] = bulkRow{index: i + 1, err: record.err}
+		if column < len(record.fields) {
+			rows[i].shortCode = strings.TrimSpace(record.fields[column])
+		}
+	}
+	return rows, nil
+}
+
+// csvRecord is a data row of a CSV, or why it has the wrong number of
+// fields.
+type csvRecord struct {
+	fields []string
+	err    error
+}
+
+// readCSV reads a CSV with a header row, returning the lowercased column
+// names and the data rows. Blank lines are skipped.
+func readCSV(body []byte) ([]string, []csvRecord, error) {
+	// Spreadsheets often start their exports with a byte order mark
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(body, []byte("\xef\xbb\xbf"))))
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, badRequest("no_rows", "the request has no rows")
+	} else if err != nil {
+		return nil, nil, badRequest("invalid_csv", err.Error())
+	}
+	seen := make(map[string]bool)
+	for i, name := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(name))
+		if seen[header[i]] {
+			return nil, nil, badRequest("invalid_csv", "duplicate column "+name)
+		}
+		seen[header[i]] = true
+	}
+
+	var records []csvRecord
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			return header, records, nil
+		}
+		if errors.Is(err, csv.ErrFieldCount) {
+			records = append(records, csvRecord{err: badRequest("invalid_row", fmt.Sprintf("the row has %d fields, the header %d", len(fields), len(header)))})
+			continue
+		} else if err != nil {
+			return nil, nil, badRequest("invalid_csv", err.Error())
+		}
+		records = append(records, csvRecord{fields: fields})
+	}
+}
@@ -0,0 +1,54 @@
+// handlers/errors.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// apiError is the structured error body returned by the JSON endpoints.
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError responds with status and a JSON body of the form
+// {"error": {"code": ..., "message": ...}}.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]apiError{"error": {Code: code, Message: message}})
+}
+
+// requestError is an error caused by the request, returned by the checks
+// shared between endpoints. Without a code it is answered in plain text.
+type requestError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+func badRequest(code, message string) error {
+	return &requestError{status: http.StatusBadRequest, code: code, message: message}
+}
+
+var errShortURLNotFound = &requestError{status: http.StatusNotFound, message: "Short URL not found"}
+
+// writeRequestError responds with err, as a 500 unless it is a
+// *requestError.
+func writeRequestError(w http.ResponseWriter, err error) {
+	var reqErr *requestError
+	switch {
+	case !errors.As(err, &reqErr):
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	case reqErr.code == "":
+		http.Error(w, reqErr.message, reqErr.status)
+	default:
+		writeError(w, reqErr.status, reqErr.code, reqErr.message)
+	}
+}
@@ -0,0 +1,216 @@
+// handlers/export.go
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"url-shortener/models"
+	"url-shortener/storage"
+
+	"golang.org/x/net/html"
+)
+
+// exportPageSize is how many links an export reads from the store at a time.
+const exportPageSize = 500
+
+// exportColumns are the columns of CSV exports, which imports read back.
+var exportColumns = []string{
+	"shortCode", "originalUrl", "title", "description", "tags", "visitCount", "createdAt", "updatedAt",
+	"lastVisitedAt", "expiresAt", "maxVisits", "redirectType", "ogTitle", "ogDescription", "ogImage", "protected",
+}
+
+// linkWriter writes links in one of the export formats.
+type linkWriter interface {
+	WriteLink(link models.URLMapping) error
+	// Close writes whatever follows the links.
+	Close() error
+}
+
+// exportFormats are the export formats by name.
+var exportFormats = map[string]struct {
+	contentType string
+	filename    string
+	newWriter   func(io.Writer) (linkWriter, error)
+}{
+	formatCSV:       {"text/csv; charset=utf-8", "links.csv", newCSVLinkWriter},
+	formatNDJSON:    {"application/x-ndjson", "links.ndjson", newNDJSONLinkWriter},
+	formatBookmarks: {"text/html; charset=utf-8", "bookmarks.html", newBookmarksWriter},
+}
+
+// ExportURLsHandler streams all of the caller's personal links, or those of
+// the workspace named by the workspaceId query parameter, oldest first. The
+// format parameter is csv (the default), ndjson or html, a Netscape
+// bookmarks file that browsers and other shorteners import.
+func (h *Handler) ExportURLsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	workspaceID, ok := h.queryWorkspace(w, r, user, models.RoleViewer)
+	if !ok {
+		return
+	}
+	format, ok := exportFormats[strings.ToLower(queryDefault(r.URL.Query().Get("format"), formatCSV))]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_format", "format must be csv, ndjson or html")
+		return
+	}
+
+	q := storage.URLQuery{UserID: user.ID, WorkspaceID: workspaceID, Sort: storage.SortCreated, Ascending: true, Limit: exportPageSize}
+	page, err := h.links.ListURLMappings(q)
+	if err != nil {
+		log.Printf("Error exporting URL mappings: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+format.filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	links, err := format.newWriter(w)
+	for err == nil {
+		for _, link := range page.Items {
+			if err = links.WriteLink(link); err != nil {
+				break
+			}
+		}
+		if err != nil || page.NextCursor == "" {
+			break
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		q.Cursor = page.NextCursor
+		page, err = h.links.ListURLMappings(q)
+	}
+	if err == nil {
+		err = links.Close()
+	}
+	if err != nil {
+		// The status is sent, so the client only sees a truncated file
+		log.Printf("Error exporting URL mappings: %v", err)
+	}
+}
+
+// queryWorkspace returns the workspace named by the workspaceId query
+// parameter, after checking that user has at least role in it, or 0 if
+// there is none. It writes an error and returns false if the check fails.
+func (h *Handler) queryWorkspace(w http.ResponseWriter, r *http.Request, user models.User, role string) (int, bool) {
+	v := r.URL.Query().Get("workspaceId")
+	if v == "" {
+		return 0, true
+	}
+	id, err := strconv.Atoi(v)
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid_workspace", "workspaceId must be a workspace ID")
+		return 0, false
+	}
+	if _, ok := h.requireWorkspaceRole(w, user, id, role); !ok {
+		return 0, false
+	}
+	return id, true
+}
+
+// exportTime formats an optional time for CSV exports.
+func exportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+type csvLinkWriter struct {
+	w *csv.Writer
+}
+
+func newCSVLinkWriter(w io.Writer) (linkWriter, error) {
+	cw := csv.NewWriter(w)
+	return csvLinkWriter{cw}, cw.Write(exportColumns)
+}
+
+func (c csvLinkWriter) WriteLink(link models.URLMapping) error {
+	optionalInt := func(n int) string {
+		if n == 0 {
+			return ""
+		}
+		return strconv.Itoa(n)
+	}
+	return c.w.Write([]string{
+		link.ShortCode, link.OriginalURL, link.Title, link.Description, strings.Join(link.Tags, ","),
+		strconv.Itoa(link.VisitCount), exportTime(&link.CreatedAt), exportTime(&link.UpdatedAt),
+		exportTime(link.LastVisitedAt), exportTime(link.ExpiresAt), optionalInt(link.MaxVisits),
+		optionalInt(link.RedirectType), link.OGTitle, link.OGDescription, link.OGImage, strconv.FormatBool(link.Protected),
+	})
+}
+
+func (c csvLinkWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonLinkWriter struct {
+	enc *json.Encoder
+}
+
+func newNDJSONLinkWriter(w io.Writer) (linkWriter, error) {
+	return ndjsonLinkWriter{json.NewEncoder(w)}, nil
+}
+
+func (n ndjsonLinkWriter) WriteLink(link models.URLMapping) error {
+	return n.enc.Encode(link)
+}
+
+func (n ndjsonLinkWriter) Close() error {
+	return nil
+}
+
+// bookmarksWriter writes a Netscape bookmarks file. Each link is a bookmark
+// of its destination, with its short code and visit count in SHORTCODE and
+// VISIT_COUNT attributes, and PROTECTED if it has a password.
+type bookmarksWriter struct {
+	w io.Writer
+}
+
+func newBookmarksWriter(w io.Writer) (linkWriter, error) {
+	_, err := io.WriteString(w, `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+`)
+	return bookmarksWriter{w}, err
+}
+
+func (b bookmarksWriter) WriteLink(link models.URLMapping) error {
+	title := link.Title
+	if title == "" && link.Preview != nil {
+		title = firstNonEmpty(link.Preview.OGTitle, link.Preview.Title)
+	}
+	optional := ""
+	if link.LastVisitedAt != nil {
+		optional += fmt.Sprintf(` LAST_VISIT="%d"`, link.LastVisitedAt.Unix())
+	}
+	if link.Protected {
+		optional += ` PROTECTED="true"`
+	}
+	_, err := fmt.Fprintf(b.w, `    <DT><A HREF="%s" ADD_DATE="%d" LAST_MODIFIED="%d" TAGS="%s" SHORTCODE="%s" VISIT_COUNT="%d"%s>%s</A>`+"\n",
+		html.EscapeString(link.OriginalURL), link.CreatedAt.Unix(), link.UpdatedAt.Unix(),
+		html.EscapeString(strings.Join(link.Tags, ",")), html.EscapeString(link.ShortCode), link.VisitCount,
+		optional, html.EscapeString(firstNonEmpty(title, link.OriginalURL)))
+	if err == nil && link.Description != "" {
+		_, err = fmt.Fprintf(b.w, "    <DD>%s\n", html.EscapeString(link.Description))
+	}
+	return err
+}
+
+func (b bookmarksWriter) Close() error {
+	_, err := io.WriteString(b.w, "</DL><p>\n")
+	return err
+}
@@ -2,223 +2,670 @@
 package handlers
 
 import (
-
 	"encoding/json"
 	"errors"
+	"image"
+	"log"
 	"net/http"
-	"time"
 	"strings"
+	"time"
+	"url-shortener/analytics"
+	"url-shortener/auth"
+	"url-shortener/config"
+	"url-shortener/mailer"
 	"url-shortener/models"
+	"url-shortener/oidc"
+	"url-shortener/preview"
 	"url-shortener/storage"
 	"url-shortener/utils"
+
 	"github.com/gorilla/mux"
 	"golang.org/x/crypto/bcrypt"
-	"github.com/dgrijalva/jwt-go"
-	"log"
-
 )
 
-var redisClient *storage.RedisClient
-var jwtKey = []byte("+iQmsWxcpcHN+YPHUojt9iVgBtsrhPm59cR9q1+F4Lk=")
+// maxCodeAttempts bounds how many generated short codes are tried before
+// giving up on collisions.
+const maxCodeAttempts = 5
+
+var errCodeSpaceExhausted = errors.New("could not generate a unique short code")
+
+// Handler serves the HTTP API on top of the injected storage backends.
+type Handler struct {
+	users    storage.UserStore
+	links    storage.LinkStore
+	guests   storage.GuestStore
+	clicks   storage.ClickStore
+	visits   storage.VisitBuffer
+	recorder analytics.Recorder
+	codes    utils.CodeGenerator
+	// redirectStatus is used for links without their own RedirectType.
+	redirectStatus int
+	guestTTL       time.Duration
+	// trustedProxies identify clients behind reverse proxies.
+	trustedProxies utils.TrustedProxies
+	sessions       storage.SessionStore
+	apiKeys        storage.APIKeyStore
+	accounts       storage.AccountTokenStore
+	identities     storage.IdentityStore
+	workspaces     storage.WorkspaceStore
+	inviteTTL      time.Duration
+	previews       preview.Queue
+	qrConfig       config.QRConfig
+	qrLogo         image.Image
+	qrCodes        storage.Cache
+	unlockTTL      time.Duration
+	unlockAttempts *attemptLimiter
+	unlockLimit    int
+	unlockWindow   time.Duration
+	emailRequests  *attemptLimiter
+	emailLimit     int
+	emailWindow    time.Duration
+	jobs           storage.JobStore
+	bulk           config.BulkConfig
+	// bulkSlots holds a token for each running bulk job.
+	bulkSlots chan struct{}
+	// sso is nil unless single sign-on is configured.
+	sso        *oidc.Provider
+	mailer     mailer.Mailer
+	publicURL  string
+	verifyTTL  time.Duration
+	resetTTL   time.Duration
+	keys       *auth.KeySet
+	authn      *auth.Authenticator
+	tokenTTL   time.Duration
+	refreshTTL time.Duration
+}
+
+// Option configures optional Handler dependencies.
+type Option func(*Handler)
+
+// WithCodeGenerator sets the strategy used to generate short codes.
+func WithCodeGenerator(g utils.CodeGenerator) Option {
+	return func(h *Handler) {
+		h.codes = g
+	}
+}
+
+// WithClickRecorder sets how redirects are logged to the click store. By
+// default each click is written synchronously.
+func WithClickRecorder(rec analytics.Recorder) Option {
+	return func(h *Handler) {
+		h.recorder = rec
+	}
+}
+
+// WithConfig applies the server configuration. Signing keys are loaded
+// separately, see WithSigningKeys.
+func WithConfig(cfg config.Config) Option {
+	return func(h *Handler) {
+		h.redirectStatus = cfg.Server.DefaultRedirectStatus
+		h.guestTTL = cfg.Links.GuestTTL
+		h.tokenTTL = cfg.Auth.TokenTTL
+		h.refreshTTL = cfg.Auth.RefreshTokenTTL
+		h.publicURL = strings.TrimSuffix(cfg.Server.PublicURL, "/")
+		// Checked by cfg.Validate
+		h.trustedProxies, _ = utils.ParseTrustedProxies(cfg.Server.TrustedProxies)
+		h.verifyTTL = cfg.Auth.VerificationTokenTTL
+		h.resetTTL = cfg.Auth.PasswordResetTokenTTL
+		h.inviteTTL = cfg.Auth.InvitationTTL
+		h.emailLimit = cfg.Auth.EmailRequests
+		h.emailWindow = cfg.Auth.EmailWindow
+		h.qrConfig = cfg.QR
+		h.unlockTTL = cfg.Links.UnlockTTL
+		h.unlockLimit = cfg.Links.UnlockAttempts
+		h.unlockWindow = cfg.Links.UnlockWindow
+		h.bulk = cfg.Bulk
+	}
+}
+
+// WithMailer sets how account emails are sent. By default they are logged.
+func WithMailer(m mailer.Mailer) Option {
+	return func(h *Handler) {
+		h.mailer = m
+	}
+}
+
+// WithOIDC enables single sign-on through the given provider.
+func WithOIDC(p *oidc.Provider) Option {
+	return func(h *Handler) {
+		h.sso = p
+	}
+}
+
+// WithPreviewQueue fetches previews of new and edited links' destinations
+// through q. By default no previews are fetched.
+func WithPreviewQueue(q preview.Queue) Option {
+	return func(h *Handler) {
+		h.previews = q
+	}
+}
+
+// WithQRLogo sets the logo QR codes embed on request. By default logos
+// aren't offered.
+func WithQRLogo(logo image.Image) Option {
+	return func(h *Handler) {
+		h.qrLogo = logo
+	}
+}
+
+// WithSigningKeys sets the keys tokens are signed and verified with. By
+// default a random key is used, so tokens don't survive a restart.
+func WithSigningKeys(keys *auth.KeySet) Option {
+	return func(h *Handler) {
+		h.keys = keys
+	}
+}
+
+// NewHandler creates a Handler on top of the given stores.
+func NewHandler(stores storage.Stores, opts ...Option) *Handler {
+	defaults := config.Default()
+	h := &Handler{
+		users:          stores.Users,
+		links:          stores.Links,
+		guests:         stores.Guests,
+		clicks:         stores.Clicks,
+		visits:         stores.Visits,
+		recorder:       analytics.SyncRecorder{Store: stores.Clicks},
+		codes:          utils.RandomCodeGenerator{Length: 8},
+		redirectStatus: defaults.Server.DefaultRedirectStatus,
+		guestTTL:       defaults.Links.GuestTTL,
+		keys:           auth.NewKeySet(auth.RandomKey(config.DefaultKeyID)),
+		sessions:       stores.Sessions,
+		apiKeys:        stores.APIKeys,
+		accounts:       stores.Accounts,
+		identities:     stores.Identities,
+		workspaces:     stores.Workspaces,
+		inviteTTL:      defaults.Auth.InvitationTTL,
+		mailer:         &mailer.LogMailer{},
+		publicURL:      defaults.Server.PublicURL,
+		verifyTTL:      defaults.Auth.VerificationTokenTTL,
+		resetTTL:       defaults.Auth.PasswordResetTokenTTL,
+		emailLimit:     defaults.Auth.EmailRequests,
+		emailWindow:    defaults.Auth.EmailWindow,
+		tokenTTL:       defaults.Auth.TokenTTL,
+		refreshTTL:     defaults.Auth.RefreshTokenTTL,
+		qrConfig:       defaults.QR,
+		unlockTTL:      defaults.Links.UnlockTTL,
+		unlockLimit:    defaults.Links.UnlockAttempts,
+		unlockWindow:   defaults.Links.UnlockWindow,
+		jobs:           stores.Jobs,
+		bulk:           defaults.Bulk,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.qrCodes = storage.NewLRUCache(h.qrConfig.CacheSize)
+	h.unlockAttempts = newAttemptLimiter(h.unlockLimit, h.unlockWindow)
+	h.emailRequests = newAttemptLimiter(h.emailLimit, h.emailWindow)
+	h.bulkSlots = make(chan struct{}, h.bulk.Workers)
+	h.authn = auth.NewAuthenticator(h.keys, stores)
+	return h
+}
 
-type Claims struct {
-	Email string `json:"email"`
-	jwt.StandardClaims
+// Authenticator returns the Authenticator for the credentials h issues. The
+// auth middleware in front of h's routes must use it.
+func (h *Handler) Authenticator() *auth.Authenticator {
+	return h.authn
 }
 
-func init() {
-	// Initialize the Redis client
-	redisClient = storage.NewRedisClient()
+// GetUserURLsHandler lists a page of the caller's personal links; see
+// listURLMappings for the query parameters.
+func (h *Handler) GetUserURLsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	h.listURLMappings(w, r, storage.URLQuery{UserID: user.ID})
 }
 
-func GetUserURLsHandler(w http.ResponseWriter, r *http.Request) {
-    email, err := getEmailFromToken(r)
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusUnauthorized)
-        return
-    }
-
-    user, err := storage.GetUserByEmail(email)
-    if err != nil {
-        log.Printf("Error retrieving user by email: %v", err)
-        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-        return
-    }
-
-    urlMappings, err := storage.GetUserURLMappings(user.ID)
-    if err != nil {
-        log.Printf("Error retrieving URL mappings: %v", err)
-        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-        return
-    }
-
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(urlMappings)
+// requireUser returns the user the auth middleware resolved for r, or
+// responds 401 if the request is anonymous.
+func requireUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, auth.ErrNoCredentials.Error(), http.StatusUnauthorized)
+		return models.User{}, false
+	}
+	return principal.User, true
 }
 
+// JWKSHandler publishes the public signing keys so other services can
+// verify our tokens.
+func (h *Handler) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(h.keys.JWKS())
+}
 
-func getEmailFromToken(r *http.Request) (string, error) {
-    tokenString := r.Header.Get("Authorization")
-    if tokenString == "" {
-        return "", errors.New("authorization header is missing")
-    }
-    tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+// createURLRequest is the payload accepted by CreateShortURLHandler.
+type createURLRequest struct {
+	OriginalURL  string     `json:"originalUrl"`
+	Alias        string     `json:"alias"`
+	ExpiresAt    *time.Time `json:"expiresAt"`
+	MaxVisits    int        `json:"maxVisits"`
+	RedirectType int        `json:"redirectType"`
+	// WorkspaceID creates the link in a workspace the caller edits.
+	WorkspaceID int      `json:"workspaceId"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	// OGTitle, OGDescription and OGImage customize the card shown when the
+	// link is shared on social media.
+	OGTitle       string `json:"ogTitle"`
+	OGDescription string `json:"ogDescription"`
+	OGImage       string `json:"ogImage"`
+	// Password, if set, must be entered before visitors are redirected.
+	Password string `json:"password"`
+}
 
-    claims := &Claims{}
+// hasOptions reports whether the request sets any of the options reserved
+// for registered users' links.
+func (req createURLRequest) hasOptions() bool {
+	return req.ExpiresAt != nil || req.MaxVisits != 0 || req.RedirectType != 0 || req.WorkspaceID != 0 ||
+		req.Title != "" || req.Description != "" || len(req.Tags) > 0 ||
+		req.OGTitle != "" || req.OGDescription != "" || req.OGImage != "" || req.Password != ""
+}
 
-    token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-        return jwtKey, nil
-    })
+// newURLMapping validates the request and returns the link it describes,
+// without an owner or short code.
+func (req createURLRequest) newURLMapping(now time.Time) (models.URLMapping, error) {
+	sanitizedURL, err := utils.SanitizeURL(req.OriginalURL)
+	if err != nil {
+		return models.URLMapping{}, badRequest("invalid_url", "Invalid URL")
+	}
+	tags, err := validateLinkMetadata(req.Title, req.Description, req.Tags)
+	if err != nil {
+		return models.URLMapping{}, err
+	}
+	urlMapping := models.URLMapping{
+		OriginalURL:   sanitizedURL,
+		ExpiresAt:     req.ExpiresAt,
+		MaxVisits:     req.MaxVisits,
+		RedirectType:  req.RedirectType,
+		Title:         strings.TrimSpace(req.Title),
+		Description:   strings.TrimSpace(req.Description),
+		Tags:          tags,
+		OGTitle:       req.OGTitle,
+		OGDescription: req.OGDescription,
+		OGImage:       req.OGImage,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := validateSocialCard(&urlMapping); err != nil {
+		return urlMapping, err
+	}
 
-    if err != nil || !token.Valid {
-        return "", errors.New("invalid token")
-    }
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		return urlMapping, badRequest("invalid_expiration", "expiresAt must be in the future")
+	}
+	if req.MaxVisits < 0 {
+		return urlMapping, badRequest("invalid_expiration", "maxVisits must not be negative")
+	}
+	if req.RedirectType != 0 && !utils.IsValidRedirectStatus(req.RedirectType) {
+		return urlMapping, badRequest("invalid_redirect_type", "redirectType must be 301, 302, 307 or 308")
+	}
+	if req.Alias != "" {
+		if err := utils.ValidateAlias(req.Alias); err != nil {
+			return urlMapping, badRequest("invalid_alias", err.Error())
+		}
+	}
 
-    return claims.Email, nil
+	if req.Password != "" {
+		if urlMapping.PasswordHash, err = hashLinkPassword(req.Password); err != nil {
+			return urlMapping, err
+		}
+		urlMapping.Protected = true
+	}
+	return urlMapping, nil
 }
 
 // CreateShortURLHandler handles requests for creating short URLs.
-func CreateShortURLHandler(w http.ResponseWriter, r *http.Request) {
-    var urlMapping models.URLMapping
-    if err := json.NewDecoder(r.Body).Decode(&urlMapping); err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
-        return
-    }
-
-    sanitizedURL, err := utils.SanitizeURL(urlMapping.OriginalURL)
-    if err != nil {
-        http.Error(w, "Invalid URL", http.StatusBadRequest)
-        return
-    }
-    urlMapping.OriginalURL = sanitizedURL
-
-    email, err := getEmailFromToken(r)
-    isNew := false
-
-    if err == nil && email != "" {
-        user, err := storage.GetUserByEmail(email)
-        if err != nil {
-            http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-            return
-        }
-
-        existingMapping, err := storage.GetURLMappingByOriginalURL(user.ID, urlMapping.OriginalURL)
-        if err == nil {
-            urlMapping.ShortCode = existingMapping.ShortCode
-        } else {
-            urlMapping.ShortCode = utils.GenerateRandomString(8)
-            urlMapping.UserID = user.ID
-            if err := storage.SaveURLMapping(urlMapping); err != nil {
-                http.Error(w, err.Error(), http.StatusInternalServerError)
-                return
-            }
-            isNew = true
-        }
-    } else {
-        // For guests, check if the URL already exists in Redis
-        existingShortCode, err := redisClient.GetShortCodeByURL(urlMapping.OriginalURL)
-        if err != nil && !errors.Is(err, storage.ErrURLNotFound) {
-            http.Error(w, err.Error(), http.StatusInternalServerError)
-            return
-        }
-
-        if existingShortCode == "" {
-            // Generate a short code for the URL
-            urlMapping.ShortCode = utils.GenerateRandomString(8)
-            // Store the URL mapping in Redis with a 24-hour expiration
-            if err := redisClient.StoreURLMapping(urlMapping.ShortCode, urlMapping.OriginalURL, 24*time.Hour); err != nil {
-                http.Error(w, err.Error(), http.StatusInternalServerError)
-                return
-            }
-            isNew = true
-        } else {
-            // Use the existing short code
-            urlMapping.ShortCode = existingShortCode
-        }
-    }
-    // Respond with the short URL and the isNew flag
-    w.Header().Set("Content-Type", "application/json")
-    response := struct {
-        OriginalURL string `json:"originalUrl"`
-        ShortCode   string `json:"shortCode"`
-        IsNew       bool   `json:"isNew"`
-        VisitCount  int    `json:"visitCount"`
-    }{
-        OriginalURL: urlMapping.OriginalURL,
-        ShortCode:   urlMapping.ShortCode,
-        IsNew:       isNew,
-        VisitCount:  0, // Initialize the visit count to 0 for new URLs
-    }
-    json.NewEncoder(w).Encode(response)
+func (h *Handler) CreateShortURLHandler(w http.ResponseWriter, r *http.Request) {
+	var req createURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	urlMapping, err := req.newURLMapping(time.Now())
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if err := h.checkAlias(req.Alias); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	principal, isUser := auth.FromContext(r.Context())
+	isNew := false
+
+	if !isUser && req.hasOptions() {
+		writeError(w, http.StatusBadRequest, "unsupported_option", "expiresAt, maxVisits, redirectType, workspaceId, title, description, tags, ogTitle, ogDescription, ogImage and password are only available to registered users")
+		return
+	}
+
+	if isUser {
+		if req.WorkspaceID != 0 {
+			if _, ok := h.requireWorkspaceRole(w, principal.User, req.WorkspaceID, models.RoleEditor); !ok {
+				return
+			}
+		}
+		urlMapping, isNew, err = h.createUserLink(principal.User, req, urlMapping)
+		if err != nil {
+			writeRequestError(w, err)
+			return
+		}
+	} else {
+		// For guests, check if the URL already exists in Redis
+		existingShortCode := ""
+		if req.Alias == "" {
+			existingShortCode, err = h.guests.GetShortCodeByURL(urlMapping.OriginalURL)
+			if err != nil && !errors.Is(err, storage.ErrURLNotFound) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if existingShortCode == "" {
+			// Store the URL mapping in Redis with the guest expiration under
+			// the alias or a generated short code
+			err := h.assignShortCode(req.Alias, func(code string) error {
+				urlMapping.ShortCode = code
+				return h.guests.StoreURLMapping(code, urlMapping.OriginalURL, h.guestTTL)
+			})
+			if err != nil {
+				writeRequestError(w, saveError(req.Alias, err))
+				return
+			}
+			// Guest links expire without their clicks being dropped, so the
+			// code may have some from an earlier link
+			if err := h.clicks.DeleteClicks(urlMapping.ShortCode); err != nil {
+				log.Printf("Error deleting stale clicks on %s: %v", urlMapping.ShortCode, err)
+			}
+			isNew = true
+		} else {
+			// Use the existing short code
+			urlMapping.ShortCode = existingShortCode
+		}
+	}
+	// Respond with the short URL and the isNew flag
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		OriginalURL  string     `json:"originalUrl"`
+		ShortCode    string     `json:"shortCode"`
+		IsNew        bool       `json:"isNew"`
+		VisitCount   int        `json:"visitCount"`
+		ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+		MaxVisits    int        `json:"maxVisits,omitempty"`
+		RedirectType int        `json:"redirectType,omitempty"`
+		WorkspaceID  int        `json:"workspaceId,omitempty"`
+		Title        string     `json:"title,omitempty"`
+		Description  string     `json:"description,omitempty"`
+		Tags         []string   `json:"tags,omitempty"`
+		// New links get their preview in the background
+		Preview       *models.LinkPreview `json:"preview,omitempty"`
+		OGTitle       string              `json:"ogTitle,omitempty"`
+		OGDescription string              `json:"ogDescription,omitempty"`
+		OGImage       string              `json:"ogImage,omitempty"`
+		Protected     bool                `json:"protected,omitempty"`
+		// Guest links have no timestamps
+		CreatedAt *time.Time `json:"createdAt,omitempty"`
+		UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+	}{
+		OriginalURL:   urlMapping.OriginalURL,
+		ShortCode:     urlMapping.ShortCode,
+		IsNew:         isNew,
+		VisitCount:    0, // Initialize the visit count to 0 for new URLs
+		ExpiresAt:     urlMapping.ExpiresAt,
+		MaxVisits:     urlMapping.MaxVisits,
+		RedirectType:  urlMapping.RedirectType,
+		WorkspaceID:   urlMapping.WorkspaceID,
+		Title:         urlMapping.Title,
+		Description:   urlMapping.Description,
+		Tags:          urlMapping.Tags,
+		Preview:       urlMapping.Preview,
+		OGTitle:       urlMapping.OGTitle,
+		OGDescription: urlMapping.OGDescription,
+		OGImage:       urlMapping.OGImage,
+		Protected:     urlMapping.Protected,
+	}
+	if isUser {
+		response.CreatedAt, response.UpdatedAt = &urlMapping.CreatedAt, &urlMapping.UpdatedAt
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// checkAlias checks that a requested alias isn't taken yet.
+func (h *Handler) checkAlias(alias string) error {
+	if alias == "" {
+		return nil
+	}
+	inUse, err := h.shortCodeInUse(alias)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return &requestError{status: http.StatusConflict, code: "alias_taken", message: "alias " + alias + " is already in use"}
+	}
+	return nil
+}
+
+// createUserLink stores urlMapping, built from req, as a link of user,
+// unless user already shortened the URL in the same place; the existing
+// link is returned then, or a conflict if req asks for a new alias or
+// options. It reports whether the link is new. Callers check that user may
+// create links in req's workspace.
+func (h *Handler) createUserLink(user models.User, req createURLRequest, urlMapping models.URLMapping) (models.URLMapping, bool, error) {
+	var existingMapping models.URLMapping
+	var err error
+	if req.WorkspaceID != 0 {
+		existingMapping, err = h.links.GetWorkspaceURLMappingByOriginalURL(req.WorkspaceID, urlMapping.OriginalURL)
+	} else {
+		existingMapping, err = h.links.GetURLMappingByOriginalURL(user.ID, urlMapping.OriginalURL)
+	}
+	if err == nil {
+		if req.Alias != "" || req.hasOptions() {
+			return existingMapping, false, &requestError{status: http.StatusConflict, code: "url_already_shortened",
+				message: "URL is already shortened as " + existingMapping.ShortCode}
+		}
+		return existingMapping, false, nil
+	}
+
+	urlMapping.UserID = user.ID
+	urlMapping.WorkspaceID = req.WorkspaceID
+	err = h.assignShortCode(req.Alias, func(code string) error {
+		urlMapping.ShortCode = code
+		return h.links.SaveURLMapping(urlMapping)
+	})
+	if err != nil {
+		return urlMapping, false, saveError(req.Alias, err)
+	}
+	h.enqueuePreview(urlMapping)
+	return urlMapping, true, nil
+}
+
+// enqueuePreview schedules fetching the preview of urlMapping's destination,
+// if previews are enabled.
+func (h *Handler) enqueuePreview(urlMapping models.URLMapping) {
+	if h.previews != nil {
+		h.previews.Enqueue(urlMapping.ShortCode, urlMapping.OriginalURL)
+	}
+}
+
+// assignShortCode calls save with alias, or with generated short codes until
+// one is stored without colliding with an existing link in either backend.
+func (h *Handler) assignShortCode(alias string, save func(code string) error) error {
+	if alias != "" {
+		return save(alias)
+	}
+	for attempt := 0; attempt < maxCodeAttempts; attempt++ {
+		code, err := h.codes.Generate()
+		if err != nil {
+			return err
+		}
+		if utils.IsReservedAlias(code) {
+			continue
+		}
+		inUse, err := h.shortCodeInUse(code)
+		if err != nil {
+			return err
+		}
+		if inUse {
+			continue
+		}
+		if err := save(code); !errors.Is(err, storage.ErrShortCodeTaken) {
+			return err
+		}
+	}
+	return errCodeSpaceExhausted
+}
+
+// saveError maps an assignShortCode error to the error reported to the
+// client.
+func saveError(alias string, err error) error {
+	switch {
+	case errors.Is(err, storage.ErrShortCodeTaken):
+		return &requestError{status: http.StatusConflict, code: "alias_taken", message: "alias " + alias + " is already in use"}
+	case errors.Is(err, errCodeSpaceExhausted):
+		log.Printf("Error generating short code: %v", err)
+		return &requestError{status: http.StatusServiceUnavailable, code: "short_code_unavailable", message: err.Error()}
+	default:
+		return err
+	}
+}
+
+// shortCodeInUse reports whether code is already taken by a registered
+// user's link or by a guest link.
+func (h *Handler) shortCodeInUse(code string) (bool, error) {
+	if _, err := h.links.GetURLMappingByShortCode(code); err == nil {
+		return true, nil
+	} else if !errors.Is(err, storage.ErrURLNotFound) {
+		return false, err
+	}
+	if _, err := h.guests.RetrieveOriginalURL(code); err == nil {
+		return true, nil
+	} else if !errors.Is(err, storage.ErrURLNotFound) {
+		return false, err
+	}
+	return false, nil
 }
 
 // RedirectShortURLHandler handles requests for redirecting to the original URL.
-func RedirectShortURLHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) RedirectShortURLHandler(w http.ResponseWriter, r *http.Request) {
 	shortCode := mux.Vars(r)["shortCode"]
 
 	// Attempt to retrieve the original URL from PostgreSQL first
-	urlMapping, err := storage.GetURLMappingByShortCode(shortCode)
+	urlMapping, err := h.links.GetURLMappingByShortCode(shortCode)
 	if err == nil {
+		// Unfurlers get a page rather than the redirect, so caches must
+		// tell them apart
+		w.Header().Add("Vary", "User-Agent")
+		if urlMapping.Expired(time.Now()) {
+			http.Error(w, "Short URL has expired", http.StatusGone)
+			return
+		}
+		if urlMapping.Protected {
+			w.Header().Set("Cache-Control", "no-store")
+			if !h.unlocked(r, urlMapping) {
+				serveUnlockForm(w, http.StatusOK, "")
+				return
+			}
+		}
+		if analytics.IsUnfurler(r.UserAgent()) {
+			// Previews aren't visits, so they are neither counted nor
+			// logged as clicks
+			h.serveSocialCard(w, urlMapping)
+			return
+		}
+		if err := h.countVisit(urlMapping); errors.Is(err, storage.ErrVisitLimitReached) {
+			http.Error(w, "Short URL has expired", http.StatusGone)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.recorder.Record(analytics.NewClick(r, shortCode, h.trustedProxies.ClientIP(r), time.Now()))
 		// Redirect to the original URL
-		http.Redirect(w, r, urlMapping.OriginalURL, http.StatusFound)
+		http.Redirect(w, r, urlMapping.OriginalURL, h.redirectStatusFor(urlMapping))
 		return
 	}
 
 	// If the URL is not found in PostgreSQL, check Redis (for guests)
-	originalURL, err := redisClient.RetrieveOriginalURL(shortCode)
+	originalURL, err := h.guests.RetrieveOriginalURL(shortCode)
 	if err != nil {
 		http.Error(w, "Short URL not found", http.StatusNotFound)
 		return
 	}
 
 	// Increment the visit count in Redis
-	if err := redisClient.IncrementVisitCount(shortCode); err != nil {
+	if err := h.guests.IncrementVisitCount(shortCode); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.recorder.Record(analytics.NewClick(r, shortCode, h.trustedProxies.ClientIP(r), time.Now()))
 
 	// Redirect to the original URL
-	http.Redirect(w, r, originalURL, http.StatusFound)
+	http.Redirect(w, r, originalURL, h.redirectStatus)
 }
 
-// GetURLAnalyticsHandler handles requests for getting URL analytics.
-func GetURLAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
-	shortCode := mux.Vars(r)["shortCode"]
-	count, err := redisClient.GetVisitCount(shortCode)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// redirectStatusFor returns the HTTP status used to redirect urlMapping.
+// Browsers cache permanent (301/308) redirects, so later visits from the
+// same browser may not reach the server and won't be counted.
+//
+// Protected links are never redirected permanently, since browsers would
+// then skip the password once the link is unlocked.
+func (h *Handler) redirectStatusFor(urlMapping models.URLMapping) int {
+	status := h.redirectStatus
+	if urlMapping.RedirectType != 0 {
+		status = urlMapping.RedirectType
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]int{"visitCount": count})
+	if urlMapping.Protected {
+		switch status {
+		case http.StatusMovedPermanently:
+			return http.StatusFound
+		case http.StatusPermanentRedirect:
+			return http.StatusTemporaryRedirect
+		}
+	}
+	return status
 }
 
-func DeleteURLHandler(w http.ResponseWriter, r *http.Request) {
-    shortCode := mux.Vars(r)["shortCode"]
-    email, err := getEmailFromToken(r)
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusUnauthorized)
-        return
-    }
-
-    user, err := storage.GetUserByEmail(email)
-    if err != nil {
-        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-        return
-    }
-
-    err = storage.DeleteURLMapping(user.ID, shortCode)
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
-        return
-    }
-
-    w.WriteHeader(http.StatusOK)
+// countVisit records a redirect of a registered user's link. Links with a
+// visit limit are counted immediately so the limit is enforced exactly;
+// all others are buffered and flushed to the LinkStore in batches.
+func (h *Handler) countVisit(urlMapping models.URLMapping) error {
+	if urlMapping.MaxVisits > 0 {
+		return h.links.IncrementURLVisitCount(urlMapping.UserID, urlMapping.ShortCode)
+	}
+	return h.visits.BufferVisits(urlMapping.ShortCode, 1)
 }
 
+func (h *Handler) DeleteURLHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	urlMapping, ok := h.accessibleURLMapping(w, user, shortCode, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	err := h.links.DeleteURLMapping(urlMapping.UserID, shortCode)
+	if errors.Is(err, storage.ErrURLNotFound) {
+		http.Error(w, "Short URL not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
+	w.WriteHeader(http.StatusOK)
+}
 
-func SignUpHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) SignUpHandler(w http.ResponseWriter, r *http.Request) {
 	var user models.User
 	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
@@ -226,41 +673,46 @@ func SignUpHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := utils.ValidateEmail(user.Email); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_email", err.Error())
+		return
+	}
+	if err := utils.ValidatePassword(user.Password); err != nil {
+		writeError(w, http.StatusBadRequest, "weak_password", err.Error())
+		return
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
 		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 		return
 	}
 	user.Password = string(hashedPassword)
+	user.EmailVerifiedAt = nil
 
-	err = storage.SaveUser(user)
+	err = h.users.SaveUser(user)
+	if errors.Is(err, storage.ErrEmailTaken) {
+		writeError(w, http.StatusConflict, "email_taken", "an account with this email already exists")
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to save user", http.StatusInternalServerError)
 		return
 	}
 
-	// Create the JWT token for the newly registered user
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := &Claims{
-		Email: user.Email,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	// Start a session for the newly registered user
+	user, err = h.users.GetUserByEmail(user.Email)
 	if err != nil {
 		http.Error(w, "Failed to create token", http.StatusInternalServerError)
 		return
 	}
-
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"token": tokenString})
+	if err := h.sendVerificationEmail(r.Context(), user); err != nil {
+		log.Printf("Error sending verification email: %v", err)
+	}
+	h.startSession(w, user, http.StatusCreated)
 }
 
-
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	var credentials struct {
 		Email    string
 		Password string
@@ -271,92 +723,39 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := storage.GetUserByEmail(credentials.Email)
+	user, err := h.users.GetUserByEmail(credentials.Email)
 	if err != nil || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(credentials.Password)) != nil {
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := &Claims{
-		Email: credentials.Email,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
-	}
+	h.startSession(w, user, http.StatusOK)
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
-	if err != nil {
-		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+func (h *Handler) GetURLVisitCountHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the user resolved from the token or API key
+	user, ok := requireUser(w, r)
+	if !ok {
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{"token": tokenString})
-}
-
+	// Get the short code from the URL path
+	vars := mux.Vars(r)
+	shortCode := vars["shortCode"]
 
-func AuthenticatedVisitCountHandler(w http.ResponseWriter, r *http.Request) {
-    // Get the email from the token
-    email, err := getEmailFromToken(r)
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusUnauthorized)
-        return
-    }
-
-    // Get the user by email
-    user, err := storage.GetUserByEmail(email)
-    if err != nil {
-        log.Printf("Error retrieving user by email: %v", err)
-        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-        return
-    }
-
-    // Get the short code from the URL path
-    vars := mux.Vars(r)
-    shortCode := vars["shortCode"]
-
-    // Increment the visit count in the database
-    err = storage.IncrementURLVisitCount(user.ID, shortCode)
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
-        return
-    }
-
-    // Return a success message
-    w.WriteHeader(http.StatusOK)
-    w.Write([]byte("Visit count incremented successfully"))
+	urlMapping, ok := h.accessibleURLMapping(w, user, shortCode, models.RoleViewer)
+	if !ok {
+		return
+	}
 
-}
+	// Get the visit count from the database
+	count, err := h.links.GetURLVisitCount(urlMapping.UserID, shortCode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-func GetURLVisitCountHandler(w http.ResponseWriter, r *http.Request) {
-    // Get the email from the token
-    email, err := getEmailFromToken(r)
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusUnauthorized)
-        return
-    }
-
-    // Get the user by email
-    user, err := storage.GetUserByEmail(email)
-    if err != nil {
-        log.Printf("Error retrieving user by email: %v", err)
-        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-        return
-    }
-
-    // Get the short code from the URL path
-    vars := mux.Vars(r)
-    shortCode := vars["shortCode"]
-
-    // Get the visit count from the database
-    count, err := storage.GetURLVisitCount(user.ID, shortCode)
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
-        return
-    }
-
-    // Return the visit count as JSON
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]int{"visitCount": count})
+	// Return the visit count as JSON
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"visitCount": count})
 }
@@ -1,32 +1,87 @@
 // handlers/handlers_test.go
-package handlers
+package handlers_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"url-shortener/api"
+	"url-shortener/config"
+	"url-shortener/handlers"
+	"url-shortener/mailer"
+	"url-shortener/models"
+	"url-shortener/oidc"
+	"url-shortener/oidc/oidctest"
 	"url-shortener/storage"
 
 	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
 )
 
-func TestMain(m *testing.M) {
+// newTestRouter builds the API router, as served, on an in-memory store.
+func newTestRouter(opts ...handlers.Option) (*mux.Router, *storage.MemoryStore) {
+	return newConfigRouter(config.Default(), opts...)
+}
 
-	redisClient = storage.NewRedisClient()
-	exitVal := m.Run()
-	os.Exit(exitVal)
+// newConfigRouter is newTestRouter with cfg in place of the defaults. The
+// rate limits are raised, so tests making many requests aren't throttled.
+func newConfigRouter(cfg config.Config, opts ...handlers.Option) (*mux.Router, *storage.MemoryStore) {
+	cfg.RateLimit.Burst = 1000
+	cfg.Bulk.RateLimit.Burst = 1000
+	store := storage.NewMemoryStore()
+	return api.NewRouter(cfg, store.Stores(), opts...), store
 }
 
-func TestCreateAndRedirectShortURL(t *testing.T) {
+// doJSON sends a JSON request through router and returns the recorder.
+func doJSON(router http.Handler, method, path, token string, payload interface{}) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	if payload != nil {
+		json.NewEncoder(&body).Encode(payload)
+	}
+	req, _ := http.NewRequest(method, path, &body)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
 
-	router := mux.NewRouter()
-	router.HandleFunc("/create", CreateShortURLHandler).Methods("POST")
-	router.HandleFunc("/{shortCode}", RedirectShortURLHandler).Methods("GET")
+// signUp registers a user and returns its access token.
+func signUp(t *testing.T, router http.Handler, email string) string {
+	t.Helper()
+	return signUpTokens(t, router, email).Token
+}
+
+// signUpTokens registers a user and returns its token pair.
+func signUpTokens(t *testing.T, router http.Handler, email string) handlers.TokenResponse {
+	t.Helper()
+	rr := doJSON(router, "POST", "/signup", "", map[string]string{"email": email, "password": "s3cret-passw0rd"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("SignUpHandler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	var result handlers.TokenResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("could not unmarshal response from signup: %v", err)
+	}
+	return result
+}
+
+func TestCreateAndRedirectShortURL(t *testing.T) {
+	router, store := newTestRouter()
 
 	// Test creating a short URL.
 	payload := map[string]string{
@@ -44,21 +99,21 @@ func TestCreateAndRedirectShortURL(t *testing.T) {
 		t.Errorf("CreateShortURLHandler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 
-	var createResult map[string]string
+	var createResult map[string]interface{}
 	err := json.Unmarshal(createRR.Body.Bytes(), &createResult)
 	if err != nil {
 		t.Fatalf("could not unmarshal response from create: %v", err)
 	}
 
-	shortCode, ok := createResult["shortCode"]
+	shortCode, ok := createResult["shortCode"].(string)
 	if !ok {
 		t.Fatalf("CreateShortURLHandler response does not contain 'shortCode'")
 	}
 
 	// Store the short URL
-	err = redisClient.StoreURLMapping(shortCode, payload["originalUrl"], 24*time.Hour)
+	err = store.StoreURLMapping(shortCode, payload["originalUrl"], 24*time.Hour)
 	if err != nil {
-		t.Fatalf("could not store URL mapping in Redis: %v", err)
+		t.Fatalf("could not store URL mapping: %v", err)
 	}
 
 	redirectReq, _ := http.NewRequest("GET", "/"+shortCode, nil)
@@ -84,3 +139,1452 @@ func TestCreateAndRedirectShortURL(t *testing.T) {
 		t.Errorf("RedirectShortURLHandler returned wrong Location header: got %v want %v", location[0], payload["originalUrl"])
 	}
 }
+
+func TestUserURLLifecycle(t *testing.T) {
+	router, _ := newTestRouter()
+	token := signUp(t, router, "alice@example.com")
+
+	createRR := doJSON(router, "POST", "/create", token, map[string]string{"originalUrl": "https://example.com/docs"})
+	if createRR.Code != http.StatusOK {
+		t.Fatalf("CreateShortURLHandler returned wrong status code: got %v want %v", createRR.Code, http.StatusOK)
+	}
+	var created struct {
+		ShortCode string `json:"shortCode"`
+		IsNew     bool   `json:"isNew"`
+	}
+	json.Unmarshal(createRR.Body.Bytes(), &created)
+	if !created.IsNew || created.ShortCode == "" {
+		t.Fatalf("CreateShortURLHandler returned unexpected response: %s", createRR.Body.String())
+	}
+
+	// Shortening the same URL again reuses the existing code.
+	againRR := doJSON(router, "POST", "/create", token, map[string]string{"originalUrl": "https://example.com/docs"})
+	var again struct {
+		ShortCode string `json:"shortCode"`
+		IsNew     bool   `json:"isNew"`
+	}
+	json.Unmarshal(againRR.Body.Bytes(), &again)
+	if again.IsNew || again.ShortCode != created.ShortCode {
+		t.Errorf("CreateShortURLHandler did not reuse short code: got %+v want %v", again, created.ShortCode)
+	}
+
+	listRR := doJSON(router, "GET", "/user/urls", token, nil)
+	var list storage.URLPage
+	json.Unmarshal(listRR.Body.Bytes(), &list)
+	if len(list.Items) != 1 || list.Total != 1 {
+		t.Fatalf("GetUserURLsHandler returned %d URLs of %d, want 1", len(list.Items), list.Total)
+	}
+
+	deleteRR := doJSON(router, "DELETE", "/delete/"+created.ShortCode, token, nil)
+	if deleteRR.Code != http.StatusOK {
+		t.Errorf("DeleteURLHandler returned wrong status code: got %v want %v", deleteRR.Code, http.StatusOK)
+	}
+
+	redirectRR := doJSON(router, "GET", "/"+created.ShortCode, "", nil)
+	if redirectRR.Code != http.StatusNotFound {
+		t.Errorf("RedirectShortURLHandler returned wrong status code after delete: got %v want %v", redirectRR.Code, http.StatusNotFound)
+	}
+}
+
+func TestCreateWithAlias(t *testing.T) {
+	router, _ := newTestRouter()
+
+	rr := doJSON(router, "POST", "/create", "", map[string]string{"originalUrl": "https://example.com/sale", "alias": "spring-sale"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("CreateShortURLHandler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	redirectRR := doJSON(router, "GET", "/spring-sale", "", nil)
+	if loc := redirectRR.Header().Get("Location"); loc != "https://example.com/sale" {
+		t.Errorf("RedirectShortURLHandler returned wrong Location header: got %v", loc)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		alias  string
+		status int
+		code   string
+	}{
+		{"reserved", "", "signup", http.StatusBadRequest, "invalid_alias"},
+		{"reserved case-insensitive", "", "Analytics", http.StatusBadRequest, "invalid_alias"},
+		{"too short", "", "ab", http.StatusBadRequest, "invalid_alias"},
+		{"bad charset", "", "spring sale!", http.StatusBadRequest, "invalid_alias"},
+		{"guest collision", "", "spring-sale", http.StatusConflict, "alias_taken"},
+		{"user collides with guest key", signUp(t, router, "bob@example.com"), "spring-sale", http.StatusConflict, "alias_taken"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doJSON(router, "POST", "/create", tt.token, map[string]string{"originalUrl": "https://example.com/other", "alias": tt.alias})
+			if rr.Code != tt.status {
+				t.Fatalf("got status %v want %v: %s", rr.Code, tt.status, rr.Body.String())
+			}
+			var body struct {
+				Error handlers.APIError `json:"error"`
+			}
+			json.Unmarshal(rr.Body.Bytes(), &body)
+			if body.Error.Code != tt.code {
+				t.Errorf("got error code %q want %q", body.Error.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestLinkExpiration(t *testing.T) {
+	router, store := newTestRouter()
+	token := signUp(t, router, "carol@example.com")
+
+	rr := doJSON(router, "POST", "/create", "", map[string]interface{}{"originalUrl": "https://example.com/guest", "maxVisits": 1})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("guest create with maxVisits: got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr = doJSON(router, "POST", "/create", token, map[string]interface{}{"originalUrl": "https://example.com/past", "expiresAt": time.Now().Add(-time.Minute)})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("create with past expiresAt: got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr = doJSON(router, "POST", "/create", token, map[string]interface{}{"originalUrl": "https://example.com/once", "alias": "once", "maxVisits": 1})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create with maxVisits: got status %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	if rr := doJSON(router, "GET", "/once", "", nil); rr.Code != http.StatusFound {
+		t.Errorf("redirect before limit: got status %v want %v", rr.Code, http.StatusFound)
+	}
+
+	// The first redirect used up the only allowed visit.
+	if rr := doJSON(router, "GET", "/once", "", nil); rr.Code != http.StatusGone {
+		t.Errorf("redirect past limit: got status %v want %v", rr.Code, http.StatusGone)
+	}
+
+	if n, _ := store.PurgeExpiredURLMappings(time.Now(), true); n != 1 {
+		t.Errorf("PurgeExpiredURLMappings purged %d mappings, want 1", n)
+	}
+	if rr := doJSON(router, "GET", "/once", "", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("redirect after purge: got status %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+// fixedCodes is a CodeGenerator that replays a fixed list of codes.
+type fixedCodes struct {
+	codes []string
+}
+
+func (g *fixedCodes) Generate() (string, error) {
+	code := g.codes[0]
+	if len(g.codes) > 1 {
+		g.codes = g.codes[1:]
+	}
+	return code, nil
+}
+
+func TestGeneratedCodeCollisionRetry(t *testing.T) {
+	gen := &fixedCodes{codes: []string{"taken1", "login", "taken2", "fresh1"}}
+	router, store := newTestRouter(handlers.WithCodeGenerator(gen))
+	store.StoreURLMapping("taken1", "https://example.com/a", time.Hour)
+	token := signUp(t, router, "dave@example.com")
+	user, _ := store.GetUserByEmail("dave@example.com")
+	store.SaveURLMapping(models.URLMapping{UserID: user.ID, ShortCode: "taken2", OriginalURL: "https://example.com/b"})
+
+	rr := doJSON(router, "POST", "/create", token, map[string]string{"originalUrl": "https://example.com/c"})
+	var created struct {
+		ShortCode string `json:"shortCode"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if created.ShortCode != "fresh1" {
+		t.Errorf("CreateShortURLHandler picked short code %q, want %q", created.ShortCode, "fresh1")
+	}
+
+	// Only colliding codes remain, so a guest create gives up.
+	rr = doJSON(router, "POST", "/create", "", map[string]string{"originalUrl": "https://example.com/d"})
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("CreateShortURLHandler returned wrong status code: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestURLAnalytics(t *testing.T) {
+	router, store := newTestRouter()
+	store.StoreURLMapping("guest01", "https://example.com/guest", time.Hour)
+
+	visit := func(ua, referrer, lang string) {
+		req, _ := http.NewRequest("GET", "/guest01", nil)
+		req.Header.Set("User-Agent", ua)
+		req.Header.Set("Referer", referrer)
+		req.Header.Set("Accept-Language", lang)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	visit("Mozilla/5.0 (X11; Linux x86_64; rv:124.0) Gecko/20100101 Firefox/124.0", "https://www.twitter.com/post/1", "en-US,en;q=0.9")
+	visit("Mozilla/5.0 (X11; Linux x86_64; rv:124.0) Gecko/20100101 Firefox/124.0", "https://twitter.com/post/2", "de-DE")
+	visit("Mozilla/5.0 (Macintosh) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/605.1.15", "", "fr-FR")
+
+	rr := doJSON(router, "GET", "/analytics/guest01?bucket=hour", "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GetURLAnalyticsHandler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var resp handlers.AnalyticsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("could not unmarshal analytics response: %v", err)
+	}
+	if resp.VisitCount != 3 {
+		t.Errorf("got visitCount %d want 3", resp.VisitCount)
+	}
+	if n := len(resp.Series); n < 48 || resp.Series[n-1].Count != 3 {
+		t.Errorf("unexpected hourly series: %+v", resp.Series)
+	}
+	if len(resp.TopReferrers) == 0 || resp.TopReferrers[0] != (models.ClickCount{Value: "twitter.com", Count: 2}) {
+		t.Errorf("unexpected top referrers: %+v", resp.TopReferrers)
+	}
+	if len(resp.TopBrowsers) != 2 || resp.TopBrowsers[0] != (models.ClickCount{Value: "Firefox", Count: 2}) {
+		t.Errorf("unexpected top browsers: %+v", resp.TopBrowsers)
+	}
+	if len(resp.TopCountries) != 3 {
+		t.Errorf("unexpected top countries: %+v", resp.TopCountries)
+	}
+
+	if rr := doJSON(router, "GET", "/analytics/guest01?bucket=month", "", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid bucket: got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestRegisteredURLAnalyticsAreOwnerOnly(t *testing.T) {
+	router, _ := newTestRouter()
+	owner := signUp(t, router, "erin@example.com")
+	other := signUp(t, router, "frank@example.com")
+	doJSON(router, "POST", "/create", owner, map[string]string{"originalUrl": "https://example.com/private", "alias": "private"})
+
+	if rr := doJSON(router, "GET", "/analytics/private", "", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous: got status %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := doJSON(router, "GET", "/analytics/private", other, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("other user: got status %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := doJSON(router, "GET", "/analytics/private", owner, nil); rr.Code != http.StatusOK {
+		t.Errorf("owner: got status %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestReusedShortCodesStartWithoutClicks(t *testing.T) {
+	router, store := newTestRouter()
+	owner := signUp(t, router, "ivy@example.com")
+	other := signUp(t, router, "jack@example.com")
+	clicks := func(token, shortCode string) int {
+		var resp handlers.AnalyticsResponse
+		json.Unmarshal(doJSON(router, "GET", "/analytics/"+shortCode, token, nil).Body.Bytes(), &resp)
+		n := len(resp.TopBrowsers)
+		for _, b := range resp.Series {
+			n += b.Count
+		}
+		return n
+	}
+
+	doJSON(router, "POST", "/create", owner, map[string]string{"originalUrl": "https://example.com/promo", "alias": "promo"})
+	doJSON(router, "GET", "/promo", "", nil)
+	if clicks(owner, "promo") == 0 {
+		t.Fatal("visit not recorded")
+	}
+	doJSON(router, "DELETE", "/delete/promo", owner, nil)
+	doJSON(router, "POST", "/create", other, map[string]string{"originalUrl": "https://example.com/other", "alias": "promo"})
+	if n := clicks(other, "promo"); n != 0 {
+		t.Errorf("deleted link's clicks shown to the code's new owner: %d", n)
+	}
+
+	// Purged links, and guest links, which expire on their own
+	past := time.Now().Add(-time.Minute)
+	store.SaveURLMapping(models.URLMapping{UserID: 1, ShortCode: "flash", OriginalURL: "https://example.com/flash", ExpiresAt: &past})
+	store.RecordClick(models.Click{ShortCode: "flash", ClickedAt: time.Now(), Browser: "Firefox"})
+	store.PurgeExpiredURLMappings(time.Now(), true)
+	store.RecordClick(models.Click{ShortCode: "reused", ClickedAt: time.Now(), Browser: "Firefox"})
+	doJSON(router, "POST", "/create", "", map[string]string{"originalUrl": "https://example.com/reused", "alias": "reused"})
+	for _, code := range []string{"flash", "reused"} {
+		if series, _ := store.ClickSeries(code, storage.BucketDay, past.Add(-time.Hour)); len(series) != 0 {
+			t.Errorf("clicks on %s kept: %v", code, series)
+		}
+	}
+}
+
+func TestRedirectBuffersVisitCounts(t *testing.T) {
+	router, store := newTestRouter()
+	token := signUp(t, router, "grace@example.com")
+	doJSON(router, "POST", "/create", token, map[string]string{"originalUrl": "https://example.com/counted", "alias": "counted"})
+
+	for i := 0; i < 3; i++ {
+		if rr := doJSON(router, "GET", "/counted", "", nil); rr.Code != http.StatusFound {
+			t.Fatalf("RedirectShortURLHandler returned wrong status code: got %v want %v", rr.Code, http.StatusFound)
+		}
+	}
+
+	user, _ := store.GetUserByEmail("grace@example.com")
+	if n, _ := store.GetURLVisitCount(user.ID, "counted"); n != 0 {
+		t.Errorf("visit count before flush: got %d want 0", n)
+	}
+	flusher := &storage.VisitFlusher{Buffer: store, Links: store}
+	flusher.Flush()
+	if n, _ := store.GetURLVisitCount(user.ID, "counted"); n != 3 {
+		t.Errorf("visit count after flush: got %d want 3", n)
+	}
+}
+
+func TestUpdateURLDestination(t *testing.T) {
+	router, store := newTestRouter()
+	token := signUp(t, router, "heidi@example.com")
+	other := signUp(t, router, "ivan@example.com")
+	doJSON(router, "POST", "/create", token, map[string]string{"originalUrl": "https://exmaple.com/launch", "alias": "launch"})
+	doJSON(router, "POST", "/create", token, map[string]string{"originalUrl": "https://example.com/other"})
+	doJSON(router, "GET", "/launch", "", nil)
+	(&storage.VisitFlusher{Buffer: store, Links: store}).Flush()
+
+	if rr := doJSON(router, "PATCH", "/urls/launch", other, map[string]string{"originalUrl": "https://evil.example"}); rr.Code != http.StatusNotFound {
+		t.Errorf("other user's edit: got status %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := doJSON(router, "PATCH", "/urls/launch", token, map[string]string{"originalUrl": "javascript:alert(1)"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid URL: got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := doJSON(router, "PATCH", "/urls/launch", token, map[string]string{"originalUrl": "https://example.com/other"}); rr.Code != http.StatusConflict {
+		t.Errorf("duplicate URL: got status %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	rr := doJSON(router, "PATCH", "/urls/launch", token, map[string]string{"originalUrl": "https://example.com/launch"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("UpdateURLHandler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if loc := doJSON(router, "GET", "/launch", "", nil).Header().Get("Location"); loc != "https://example.com/launch" {
+		t.Errorf("redirect after edit: got Location %q", loc)
+	}
+	user, _ := store.GetUserByEmail("heidi@example.com")
+	if n, _ := store.GetURLVisitCount(user.ID, "launch"); n != 1 {
+		t.Errorf("visit count after edit: got %d want 1", n)
+	}
+
+	var revisions []models.URLRevision
+	json.Unmarshal(doJSON(router, "GET", "/urls/launch/revisions", token, nil).Body.Bytes(), &revisions)
+	if len(revisions) != 1 || revisions[0].OriginalURL != "https://exmaple.com/launch" {
+		t.Errorf("unexpected revisions: %+v", revisions)
+	}
+}
+
+func TestListUserURLs(t *testing.T) {
+	router, store := newTestRouter()
+	token := signUp(t, router, "heidi@example.com")
+	for _, link := range []map[string]interface{}{
+		{"originalUrl": "https://example.com/a", "alias": "alpha"},
+		{"originalUrl": "https://example.com/b", "alias": "bravo"},
+		{"originalUrl": "https://docs.example.org/c", "alias": "charlie"},
+		{"originalUrl": "https://example.com/d", "alias": "delta", "maxVisits": 1},
+		{"originalUrl": "https://Example.com/e", "alias": "echo"},
+	} {
+		if rr := doJSON(router, "POST", "/create", token, link); rr.Code != http.StatusOK {
+			t.Fatalf("CreateShortURLHandler returned %v for %v", rr.Code, link)
+		}
+	}
+	doJSON(router, "GET", "/delta", "", nil)
+	doJSON(router, "GET", "/bravo", "", nil)
+	doJSON(router, "GET", "/bravo", "", nil)
+	(&storage.VisitFlusher{Buffer: store, Links: store}).Flush()
+
+	list := func(query string) storage.URLPage {
+		t.Helper()
+		rr := doJSON(router, "GET", "/user/urls?"+query, token, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GetUserURLsHandler?%s returned %v: %s", query, rr.Code, rr.Body.String())
+		}
+		var page storage.URLPage
+		json.Unmarshal(rr.Body.Bytes(), &page)
+		return page
+	}
+	codes := func(page storage.URLPage) string {
+		var codes []string
+		for _, l := range page.Items {
+			codes = append(codes, l.ShortCode)
+		}
+		return strings.Join(codes, ",")
+	}
+
+	// Newest first, two at a time.
+	var pages []string
+	page := list("limit=2")
+	for {
+		if page.Total != 5 {
+			t.Errorf("page total: got %d want 5", page.Total)
+		}
+		pages = append(pages, codes(page))
+		if page.NextCursor == "" {
+			break
+		}
+		page = list("limit=2&cursor=" + url.QueryEscape(page.NextCursor))
+	}
+	if got := strings.Join(pages, "|"); got != "echo,delta|charlie,bravo|alpha" {
+		t.Errorf("pages: got %q", got)
+	}
+
+	for query, want := range map[string]string{
+		"sort=visits&limit=2":     "bravo,delta",
+		"sort=created&order=asc":  "alpha,bravo,charlie,delta,echo",
+		"q=CHAR":                  "charlie",
+		"q=example.com/b":         "bravo",
+		"domain=example.com":      "echo,delta,bravo,alpha",
+		"expiry=expired":          "delta",
+		"expiry=active&q=example": "echo,charlie,bravo,alpha",
+	} {
+		if got := codes(list(query)); got != want {
+			t.Errorf("%s: got %q want %q", query, got, want)
+		}
+	}
+
+	cursor := list("limit=1").NextCursor
+	for _, query := range []string{"limit=0", "sort=name", "expiry=soon", "sort=visits&cursor=" + url.QueryEscape(cursor), "cursor=bogus"} {
+		if rr := doJSON(router, "GET", "/user/urls?"+query, token, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %v want %v", query, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestLinkMetadata(t *testing.T) {
+	router, store := newTestRouter()
+	token := signUp(t, router, "heidi@example.com")
+
+	if rr := doJSON(router, "POST", "/create", "", map[string]interface{}{"originalUrl": "https://example.com/g", "tags": []string{"x"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("guest link with tags: got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := doJSON(router, "POST", "/create", token, map[string]interface{}{"originalUrl": "https://example.com/g", "tags": []string{"two words"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid tag: got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr := doJSON(router, "POST", "/create", token, map[string]interface{}{
+		"originalUrl": "https://example.com/launch", "alias": "launch",
+		"title": "Launch post", "description": "Announcing the launch", "tags": []string{"Blog", "launch"},
+	})
+	var created models.URLMapping
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if rr.Code != http.StatusOK || created.Title != "Launch post" || strings.Join(created.Tags, ",") != "blog,launch" || created.CreatedAt.IsZero() {
+		t.Fatalf("CreateShortURLHandler returned %v %s", rr.Code, rr.Body.String())
+	}
+	doJSON(router, "POST", "/create", token, map[string]interface{}{"originalUrl": "https://example.com/other", "tags": []string{"misc"}})
+
+	var page storage.URLPage
+	json.Unmarshal(doJSON(router, "GET", "/user/urls?tag=BLOG", token, nil).Body.Bytes(), &page)
+	if page.Total != 1 || page.Items[0].ShortCode != "launch" || page.Items[0].Description != "Announcing the launch" {
+		t.Errorf("tag filter: got %+v", page)
+	}
+
+	rr = doJSON(router, "PATCH", "/urls/launch", token, map[string]interface{}{"tags": []string{"news"}})
+	var updated models.URLMapping
+	json.Unmarshal(rr.Body.Bytes(), &updated)
+	if rr.Code != http.StatusOK || strings.Join(updated.Tags, ",") != "news" || updated.Title != "Launch post" || !updated.UpdatedAt.After(created.UpdatedAt) {
+		t.Errorf("UpdateURLHandler returned %v %s", rr.Code, rr.Body.String())
+	}
+
+	doJSON(router, "GET", "/launch", "", nil)
+	(&storage.VisitFlusher{Buffer: store, Links: store}).Flush()
+	var analytics handlers.AnalyticsResponse
+	json.Unmarshal(doJSON(router, "GET", "/analytics/launch", token, nil).Body.Bytes(), &analytics)
+	if analytics.Link == nil || analytics.Link.LastVisitedAt == nil || analytics.Link.VisitCount != 1 || strings.Join(analytics.Link.Tags, ",") != "news" {
+		t.Errorf("analytics link: got %+v", analytics.Link)
+	}
+}
+
+// recordingQueue records the preview fetches it is asked for.
+type recordingQueue struct{ jobs []string }
+
+func (q *recordingQueue) Enqueue(shortCode, originalURL string) {
+	q.jobs = append(q.jobs, shortCode+" "+originalURL)
+}
+
+func TestLinkPreviews(t *testing.T) {
+	queue := &recordingQueue{}
+	router, store := newTestRouter(handlers.WithPreviewQueue(queue))
+	token := signUp(t, router, "ivan@example.com")
+
+	doJSON(router, "POST", "/create", "", map[string]string{"originalUrl": "https://example.com/guest"})
+	doJSON(router, "POST", "/create", token, map[string]string{"originalUrl": "https://example.com/a", "alias": "prev"})
+	doJSON(router, "POST", "/create", token, map[string]string{"originalUrl": "https://example.com/a"})
+	if strings.Join(queue.jobs, ",") != "prev https://example.com/a" {
+		t.Fatalf("queued previews after create: %q", queue.jobs)
+	}
+
+	store.SetURLPreview("prev", "https://example.com/a", models.LinkPreview{OGTitle: "Page A", FetchedAt: time.Now()})
+	var page storage.URLPage
+	json.Unmarshal(doJSON(router, "GET", "/user/urls", token, nil).Body.Bytes(), &page)
+	if page.Total != 1 || page.Items[0].Preview == nil || page.Items[0].Preview.OGTitle != "Page A" {
+		t.Errorf("listed preview: got %+v", page.Items)
+	}
+
+	doJSON(router, "PATCH", "/urls/prev", token, map[string]string{"title": "Renamed"})
+	rr := doJSON(router, "PATCH", "/urls/prev", token, map[string]string{"originalUrl": "https://example.com/b"})
+	var updated models.URLMapping
+	json.Unmarshal(rr.Body.Bytes(), &updated)
+	if rr.Code != http.StatusOK || updated.Preview != nil {
+		t.Errorf("UpdateURLHandler kept the old preview: %v %s", rr.Code, rr.Body.String())
+	}
+	if len(queue.jobs) != 2 || queue.jobs[1] != "prev https://example.com/b" {
+		t.Errorf("queued previews after edit: %q", queue.jobs)
+	}
+	// A fetch of the old destination that finishes late is discarded
+	if err := store.SetURLPreview("prev", "https://example.com/a", models.LinkPreview{OGTitle: "Page A"}); !errors.Is(err, storage.ErrURLNotFound) {
+		t.Errorf("stale preview: got err %v want ErrURLNotFound", err)
+	}
+}
+
+func TestSocialCards(t *testing.T) {
+	router, store := newTestRouter()
+	token := signUp(t, router, "kim@example.com")
+	const slack = "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"
+	visit := func(path, userAgent string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("User-Agent", userAgent)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := doJSON(router, "POST", "/create", token, map[string]string{"originalUrl": "https://example.com/x", "ogImage": "javascript:alert(1)"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid ogImage: got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	doJSON(router, "POST", "/create", token, map[string]string{
+		"originalUrl": "https://example.com/launch", "alias": "card",
+		"title": "Launch", "ogTitle": "We <launched>", "ogImage": "https://cdn.example.com/card.png",
+	})
+	store.SetURLPreview("card", "https://example.com/launch", models.LinkPreview{OGDescription: "From the page"})
+
+	rr := visit("/card", slack)
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/html") || rr.Header().Get("Vary") != "User-Agent" {
+		t.Fatalf("unfurler got %v %v", rr.Code, rr.Header())
+	}
+	for _, want := range []string{
+		`<meta property="og:title" content="We &lt;launched&gt;">`,
+		`<meta property="og:description" content="From the page">`,
+		`<meta property="og:image" content="https://cdn.example.com/card.png">`,
+		`<meta property="og:url" content="http://localhost:8080/card">`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("card page lacks %s:\n%s", want, body)
+		}
+	}
+
+	if rr := visit("/card", "Mozilla/5.0 Firefox/125.0"); rr.Code != http.StatusFound || rr.Header().Get("Location") != "https://example.com/launch" {
+		t.Errorf("browser got %v %v", rr.Code, rr.Header())
+	}
+
+	doJSON(router, "PATCH", "/urls/card", token, map[string]string{"ogTitle": ""})
+	if body := visit("/card", slack).Body.String(); !strings.Contains(body, `<meta property="og:title" content="Launch">`) {
+		t.Errorf("card page without ogTitle:\n%s", body)
+	}
+
+	// Only the browser visit is counted
+	(&storage.VisitFlusher{Buffer: store, Links: store}).Flush()
+	if m, _ := store.GetURLMappingByShortCode("card"); m.VisitCount != 1 {
+		t.Errorf("visit count = %d, want 1", m.VisitCount)
+	}
+}
+
+func TestQRCodes(t *testing.T) {
+	logo := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	router, store := newTestRouter(handlers.WithQRLogo(logo))
+	token := signUp(t, router, "lee@example.com")
+	doJSON(router, "POST", "/create", token, map[string]string{"originalUrl": "https://example.com/poster", "alias": "poster"})
+	store.StoreURLMapping("guest03", "https://example.com/guest", time.Hour)
+
+	rr := doJSON(router, "GET", "/poster/qr?size=300", "", nil)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/png" || rr.Header().Get("ETag") == "" {
+		t.Fatalf("QRCodeHandler returned %v %v", rr.Code, rr.Header())
+	}
+	img, err := png.Decode(rr.Body)
+	if err != nil || img.Bounds().Dx() != 300 {
+		t.Fatalf("QR code PNG: %v %v", img, err)
+	}
+
+	rr = doJSON(router, "GET", "/guest03/qr?format=svg&fg=%23336699&bg=eee&margin=0&level=q&logo=1", "", nil)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/svg+xml" || !strings.Contains(rr.Body.String(), `fill="#336699"`) {
+		t.Errorf("QRCodeHandler SVG returned %v %s", rr.Code, rr.Body.String())
+	}
+
+	// Repeated requests are served from the cache and revalidated by ETag
+	first := doJSON(router, "GET", "/poster/qr?size=300", "", nil)
+	req, _ := http.NewRequest("GET", "/poster/qr?size=300", nil)
+	req.Header.Set("If-None-Match", first.Header().Get("ETag"))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("conditional request: got status %v want %v", rr.Code, http.StatusNotModified)
+	}
+
+	tests := map[string]int{
+		"/missing/qr":               http.StatusNotFound,
+		"/poster/qr?size=5000":      http.StatusBadRequest,
+		"/poster/qr?size=10":        http.StatusBadRequest,
+		"/poster/qr?format=gif":     http.StatusBadRequest,
+		"/poster/qr?fg=red":         http.StatusBadRequest,
+		"/poster/qr?level=L&logo=1": http.StatusBadRequest,
+		"/poster/qr?margin=-1":      http.StatusBadRequest,
+	}
+	for path, want := range tests {
+		if rr := doJSON(router, "GET", path, "", nil); rr.Code != want {
+			t.Errorf("GET %s: got status %v want %v", path, rr.Code, want)
+		}
+	}
+}
+
+func TestPasswordProtectedLinks(t *testing.T) {
+	cfg := config.Default()
+	cfg.Links.UnlockAttempts = 2
+	router, _ := newConfigRouter(cfg)
+	token := signUp(t, router, "mia@example.com")
+	attempts := 0
+	post := func(password string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/docs", strings.NewReader(url.Values{"password": {password}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		// Without trusted proxies, claiming to be forwarded doesn't reset the limit
+		attempts++
+		req.Header.Set("X-Forwarded-For", "203.0.113."+strconv.Itoa(attempts))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	visit := func(cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/docs", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := doJSON(router, "POST", "/create", "", map[string]string{"originalUrl": "https://example.com/g", "password": "x"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("guest link with password: got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	rr := doJSON(router, "POST", "/create", token, map[string]interface{}{
+		"originalUrl": "https://intranet.example.com/docs", "alias": "docs", "password": "open sesame", "redirectType": 301,
+	})
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"protected":true`) || strings.Contains(rr.Body.String(), "open sesame") {
+		t.Fatalf("CreateShortURLHandler returned %v %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := visit(); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `type="password"`) || rr.Header().Get("Location") != "" {
+		t.Fatalf("locked link returned %v %v", rr.Code, rr.Header())
+	}
+	if rr := post("wrong"); rr.Code != http.StatusUnauthorized || len(rr.Result().Cookies()) != 0 {
+		t.Errorf("wrong password returned %v", rr.Code)
+	}
+
+	rr = post("open sesame")
+	cookies := rr.Result().Cookies()
+	if rr.Code != http.StatusSeeOther || len(cookies) != 1 || cookies[0].Path != "/docs" || !cookies[0].HttpOnly {
+		t.Fatalf("right password returned %v %v", rr.Code, cookies)
+	}
+	// Permanent redirects would be cached past the cookie's lifetime
+	if rr := visit(cookies[0]); rr.Code != http.StatusFound || rr.Header().Get("Location") != "https://intranet.example.com/docs" {
+		t.Errorf("unlocked link returned %v %v", rr.Code, rr.Header())
+	}
+
+	// Changing the password locks the link again
+	doJSON(router, "PATCH", "/urls/docs", token, map[string]string{"password": "new secret"})
+	if rr := visit(cookies[0]); rr.Code != http.StatusOK || rr.Header().Get("Location") != "" {
+		t.Errorf("cookie for the old password returned %v %v", rr.Code, rr.Header())
+	}
+
+	post("wrong")
+	post("wrong")
+	if rr := post("new secret"); rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("attempt after too many failures returned %v", rr.Code)
+	}
+
+	doJSON(router, "PATCH", "/urls/docs", token, map[string]string{"password": ""})
+	if rr := visit(); rr.Code != http.StatusMovedPermanently {
+		t.Errorf("link without password returned %v", rr.Code)
+	}
+}
+
+// doCSV posts a CSV body through router and returns the recorder.
+func doCSV(router http.Handler, path, token, body string) *httptest.ResponseRecorder {
+	return doUpload(router, path, token, "text/csv", body)
+}
+
+// doUpload posts body as contentType through router and returns the recorder.
+func doUpload(router http.Handler, path, token, contentType, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestBulkCreateAndDelete(t *testing.T) {
+	router, store := newTestRouter()
+	token := signUp(t, router, "nina@example.com")
+	other := signUp(t, router, "oscar@example.com")
+	doJSON(router, "POST", "/create", other, map[string]string{"originalUrl": "https://example.com/theirs", "alias": "theirs"})
+
+	if rr := doJSON(router, "POST", "/bulk/create", "", []interface{}{}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("guest bulk create: got status %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := doJSON(router, "POST", "/bulk/create", token, []interface{}{}); rr.Code != http.StatusBadRequest {
+		t.Errorf("empty bulk create: got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr := doJSON(router, "POST", "/bulk/create", token, []interface{}{
+		map[string]interface{}{"originalUrl": "https://example.com/a", "alias": "spring-a", "tags": []string{"spring"}},
+		map[string]interface{}{"originalUrl": "not a url"},
+		map[string]interface{}{"originalUrl": "https://example.com/b", "alias": "theirs"},
+		"not an object",
+		map[string]interface{}{"originalUrl": "https://example.com/a"},
+	})
+	var response handlers.BulkResponse
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if rr.Code != http.StatusOK || response.Total != 5 || response.Succeeded != 2 || response.Failed != 3 {
+		t.Fatalf("BulkCreateHandler returned %v %s", rr.Code, rr.Body.String())
+	}
+	wantErrors := []string{"", "invalid_url", "alias_taken", "invalid_row", ""}
+	for i, result := range response.Results {
+		code := ""
+		if result.Error != nil {
+			code = result.Error.Code
+		}
+		if result.Row != i+1 || code != wantErrors[i] {
+			t.Errorf("row %d: got %+v, want error %q", i+1, result, wantErrors[i])
+		}
+	}
+	// The last row repeats the first one's URL, so it gets the same link
+	if r := response.Results; !r[0].IsNew || r[4].IsNew || r[4].ShortCode != "spring-a" {
+		t.Errorf("duplicate URL in a batch: got %+v and %+v", r[0], r[4])
+	}
+
+	// CSV uploads, with a byte order mark and tags in one cell
+	rr = doCSV(router, "/bulk/create", token, "\xef\xbb\xbfOriginalURL,alias,tags,maxVisits\n"+
+		"https://example.com/c,spring-c,\"spring, sale\",\n"+
+		"https://example.com/d,,,many\n"+
+		"https://example.com/e,spring-e\n")
+	response = handlers.BulkResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if rr.Code != http.StatusOK || response.Succeeded != 1 || response.Results[1].Error.Code != "invalid_expiration" ||
+		response.Results[2].Error.Code != "invalid_row" {
+		t.Fatalf("CSV bulk create returned %v %s", rr.Code, rr.Body.String())
+	}
+	if link, _ := store.GetURLMappingByShortCode("spring-c"); len(link.Tags) != 2 {
+		t.Errorf("CSV tags: got %v", link.Tags)
+	}
+	if rr := doCSV(router, "/bulk/create", token, "originalUrl,colour\nhttps://example.com/f,red\n"); rr.Code != http.StatusBadRequest {
+		t.Errorf("CSV with an unknown column: got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr = doJSON(router, "POST", "/bulk/delete", token, []string{"spring-a", "theirs", "missing"})
+	response = handlers.BulkResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if rr.Code != http.StatusOK || response.Succeeded != 1 || response.Results[1].Error.Code != "not_found" ||
+		response.Results[2].Error.Code != "not_found" {
+		t.Fatalf("BulkDeleteHandler returned %v %s", rr.Code, rr.Body.String())
+	}
+	if _, err := store.GetURLMappingByShortCode("theirs"); err != nil {
+		t.Errorf("another user's link was deleted: %v", err)
+	}
+	rr = doCSV(router, "/bulk/delete", token, "shortCode,originalUrl\nspring-c,https://example.com/c\n")
+	if _, err := store.GetURLMappingByShortCode("spring-c"); rr.Code != http.StatusOK || !errors.Is(err, storage.ErrURLNotFound) {
+		t.Errorf("CSV bulk delete returned %v %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestBulkJobs(t *testing.T) {
+	cfg := config.Default()
+	cfg.Bulk.SyncRows = 2
+	router, store := newConfigRouter(cfg)
+	token := signUp(t, router, "pia@example.com")
+	other := signUp(t, router, "quinn@example.com")
+
+	rows := make([]map[string]string, 5)
+	for i := range rows {
+		rows[i] = map[string]string{"originalUrl": "https://example.com/" + strconv.Itoa(i)}
+	}
+	rr := doJSON(router, "POST", "/bulk/create", token, rows)
+	var job models.BulkJob
+	json.Unmarshal(rr.Body.Bytes(), &job)
+	if rr.Code != http.StatusAccepted || rr.Header().Get("Location") != "/bulk/jobs/"+job.ID || job.Total != 5 {
+		t.Fatalf("large bulk create returned %v %v %s", rr.Code, rr.Header(), rr.Body.String())
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status != models.JobDone {
+		if time.Now().After(deadline) {
+			t.Fatalf("job not done in time: %+v", job)
+		}
+		time.Sleep(10 * time.Millisecond)
+		rr = doJSON(router, "GET", "/bulk/jobs/"+job.ID, token, nil)
+		json.Unmarshal(rr.Body.Bytes(), &job)
+	}
+	if job.Processed != 5 || job.Succeeded != 5 || len(job.Results) != 5 || job.FinishedAt == nil {
+		t.Errorf("finished job: %+v", job)
+	}
+	if links, _ := store.GetUserURLMappings(1); len(links) != 5 {
+		t.Errorf("job created %d links, want 5", len(links))
+	}
+	if rr := doJSON(router, "GET", "/bulk/jobs/"+job.ID, other, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("another user's job: got status %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	// Small batches run as jobs on request
+	rr = doJSON(router, "POST", "/bulk/delete?async=true", token, []string{job.Results[0].ShortCode})
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("bulk delete with async: got status %v want %v", rr.Code, http.StatusAccepted)
+	}
+
+	// Jobs whose runners stopped updating them, with their server, are
+	// interrupted; finished ones are left alone
+	stale := models.BulkJob{ID: "stale", UserID: 1, Kind: models.BulkCreate, Status: models.JobRunning, Total: 5,
+		CreatedAt: time.Now().Add(-time.Hour)}
+	store.CreateBulkJob(stale)
+	if n, err := store.InterruptBulkJobs(time.Now().Add(-storage.BulkJobTimeout)); n != 1 || err != nil {
+		t.Errorf("InterruptBulkJobs() = %d, %v; want 1", n, err)
+	}
+	rr = doJSON(router, "GET", "/bulk/jobs/stale", token, nil)
+	json.Unmarshal(rr.Body.Bytes(), &job)
+	if job.Status != models.JobInterrupted || job.FinishedAt == nil {
+		t.Errorf("abandoned job: %+v", job)
+	}
+}
+
+func TestExportAndImport(t *testing.T) {
+	router, store := newTestRouter()
+	token := signUp(t, router, "rosa@example.com")
+	doJSON(router, "POST", "/create", token, map[string]interface{}{
+		"originalUrl": "https://example.com/docs?a=1&b=2", "alias": "docs", "title": "Docs & guides",
+		"description": "All of them", "tags": []string{"docs", "team"},
+	})
+	doJSON(router, "POST", "/create", token, map[string]string{"originalUrl": "https://example.com/blog", "alias": "blog"})
+	doJSON(router, "POST", "/create", token, map[string]string{"originalUrl": "https://example.com/vault", "alias": "vault", "password": "open sesame"})
+	lastVisit := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	store.SetURLVisits("docs", 7, &lastVisit)
+	docs, _ := store.GetURLMappingByShortCode("docs")
+
+	if rr := doJSON(router, "GET", "/export?format=xml", token, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("export as xml: got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	exports := make(map[string]string)
+	for _, format := range []string{"csv", "ndjson", "html"} {
+		rr := doJSON(router, "GET", "/export?format="+format, token, nil)
+		if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Disposition"), "attachment") ||
+			!strings.Contains(rr.Body.String(), "https://example.com/blog") {
+			t.Fatalf("%s export returned %v %v %s", format, rr.Code, rr.Header(), rr.Body.String())
+		}
+		exports[format] = rr.Body.String()
+	}
+	if lines := strings.Split(strings.TrimSpace(exports["csv"]), "\n"); len(lines) != 4 || !strings.HasPrefix(lines[1], "docs,") {
+		t.Errorf("CSV export, oldest first: %q", lines)
+	}
+
+	// Each format restores the links' short codes, metadata, creation
+	// times and visit counts on another server
+	for format, body := range exports {
+		router, store := newTestRouter()
+		token := signUp(t, router, "rosa@example.com")
+		other := signUp(t, router, "sam@example.com")
+		doJSON(router, "POST", "/create", other, map[string]string{"originalUrl": "https://example.com/other", "alias": "blog"})
+
+		rr := doUpload(router, "/import?format="+format, token, "text/plain", body)
+		var response handlers.BulkResponse
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		if rr.Code != http.StatusOK || response.Total != 3 || response.Succeeded != 1 ||
+			response.Results[1].Error == nil || response.Results[1].Error.Code != "alias_taken" ||
+			response.Results[2].Error == nil || response.Results[2].Error.Code != "password_required" {
+			t.Fatalf("%s import returned %v %s", format, rr.Code, rr.Body.String())
+		}
+		if _, err := store.GetURLMappingByShortCode("vault"); !errors.Is(err, storage.ErrURLNotFound) {
+			t.Errorf("%s import: protected link imported without its password", format)
+		}
+		link, err := store.GetURLMappingByShortCode("docs")
+		if err != nil || link.UserID != 1 || link.OriginalURL != docs.OriginalURL || link.Title != docs.Title ||
+			link.Description != docs.Description || len(link.Tags) != 2 || link.VisitCount != 7 ||
+			!link.CreatedAt.Truncate(time.Second).Equal(docs.CreatedAt.Truncate(time.Second)) ||
+			link.LastVisitedAt == nil || !link.LastVisitedAt.Equal(lastVisit) {
+			t.Errorf("%s import: got %+v, %v want %+v", format, link, err, docs)
+		}
+
+		// Importing again conflicts with the imported links
+		rr = doUpload(router, "/import?format="+format, token, "text/plain", body)
+		response = handlers.BulkResponse{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		if rr.Code != http.StatusOK || response.Succeeded != 0 {
+			t.Errorf("%s import again returned %v %s", format, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Protected links are imported with their passwords added to the export
+	restored, restoredStore := newTestRouter()
+	body := strings.Replace(exports["csv"], ",protected\n", ",protected,password\n", 1)
+	body = strings.Replace(body, ",true\n", ",true,open sesame\n", 1)
+	rr := doUpload(restored, "/import", signUp(t, restored, "rosa@example.com"), "text/csv", body)
+	if link, err := restoredStore.GetURLMappingByShortCode("vault"); rr.Code != http.StatusOK || err != nil || !link.Protected ||
+		bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte("open sesame")) != nil {
+		t.Errorf("CSV import with passwords returned %v %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := doUpload(router, "/import", "", "text/csv", exports["csv"]); rr.Code != http.StatusUnauthorized {
+		t.Errorf("guest import: got status %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := doUpload(router, "/import", token, "application/json", "[]"); rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("JSON import: got status %v want %v", rr.Code, http.StatusUnsupportedMediaType)
+	}
+}
+
+func TestRedirectTypes(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.DefaultRedirectStatus = http.StatusTemporaryRedirect
+	router, store := newConfigRouter(cfg)
+	token := signUp(t, router, "judy@example.com")
+	store.StoreURLMapping("guest02", "https://example.com/guest", time.Hour)
+
+	doJSON(router, "POST", "/create", token, map[string]interface{}{"originalUrl": "https://example.com/new-home", "alias": "moved", "redirectType": 308})
+	doJSON(router, "POST", "/create", token, map[string]interface{}{"originalUrl": "https://example.com/campaign", "alias": "campaign"})
+
+	tests := map[string]int{
+		"/moved":    http.StatusPermanentRedirect,
+		"/campaign": http.StatusTemporaryRedirect,
+		"/guest02":  http.StatusTemporaryRedirect,
+	}
+	for path, want := range tests {
+		if rr := doJSON(router, "GET", path, "", nil); rr.Code != want {
+			t.Errorf("GET %s: got status %v want %v", path, rr.Code, want)
+		}
+	}
+
+	if rr := doJSON(router, "POST", "/create", token, map[string]interface{}{"originalUrl": "https://example.com/x", "redirectType": 303}); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid redirectType: got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	doJSON(router, "PATCH", "/urls/moved", token, map[string]int{"redirectType": 301})
+	if rr := doJSON(router, "GET", "/moved", "", nil); rr.Code != http.StatusMovedPermanently {
+		t.Errorf("after edit: got status %v want %v", rr.Code, http.StatusMovedPermanently)
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	router, _ := newTestRouter()
+	first := signUpTokens(t, router, "carol@example.com")
+	if first.RefreshToken == "" || first.ExpiresIn != int((15*time.Minute)/time.Second) {
+		t.Fatalf("SignUpHandler returned unexpected tokens: %+v", first)
+	}
+
+	rr := doJSON(router, "POST", "/token/refresh", "", handlers.RefreshRequest{RefreshToken: first.RefreshToken})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("RefreshTokenHandler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var second handlers.TokenResponse
+	json.Unmarshal(rr.Body.Bytes(), &second)
+	if second.RefreshToken == first.RefreshToken {
+		t.Error("RefreshTokenHandler did not rotate the refresh token")
+	}
+	if rr := doJSON(router, "GET", "/user/urls", second.Token, nil); rr.Code != http.StatusOK {
+		t.Errorf("refreshed access token rejected: %v", rr.Code)
+	}
+
+	// Replaying the first refresh token revokes the whole session.
+	rr = doJSON(router, "POST", "/token/refresh", "", handlers.RefreshRequest{RefreshToken: first.RefreshToken})
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("reused refresh token returned wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := doJSON(router, "GET", "/user/urls", second.Token, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("access token still valid after refresh token reuse: %v", rr.Code)
+	}
+	rr = doJSON(router, "POST", "/token/refresh", "", handlers.RefreshRequest{RefreshToken: second.RefreshToken})
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("refresh token still valid after reuse was detected: %v", rr.Code)
+	}
+}
+
+func TestLogoutRevokesSession(t *testing.T) {
+	router, _ := newTestRouter()
+	tokens := signUpTokens(t, router, "dave@example.com")
+
+	// A second login is a separate session and survives the logout.
+	rr := doJSON(router, "POST", "/login", "", map[string]string{"email": "dave@example.com", "password": "s3cret-passw0rd"})
+	var other handlers.TokenResponse
+	json.Unmarshal(rr.Body.Bytes(), &other)
+
+	if rr := doJSON(router, "POST", "/logout", tokens.Token, nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("LogoutHandler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr := doJSON(router, "GET", "/user/urls", tokens.Token, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("access token still valid after logout: %v", rr.Code)
+	}
+	if rr := doJSON(router, "POST", "/token/refresh", "", handlers.RefreshRequest{RefreshToken: tokens.RefreshToken}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("refresh token still valid after logout: %v", rr.Code)
+	}
+	if rr := doJSON(router, "GET", "/user/urls", other.Token, nil); rr.Code != http.StatusOK {
+		t.Errorf("logout revoked an unrelated session: %v", rr.Code)
+	}
+
+	// Logging out with just the refresh token works too.
+	if rr := doJSON(router, "POST", "/logout", "", handlers.RefreshRequest{RefreshToken: other.RefreshToken}); rr.Code != http.StatusNoContent {
+		t.Fatalf("LogoutHandler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr := doJSON(router, "GET", "/user/urls", other.Token, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("access token still valid after logout by refresh token: %v", rr.Code)
+	}
+}
+
+// recordingMailer keeps sent messages for inspection.
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// waitSent waits for the nth message, for emails sent after the response.
+func (m *recordingMailer) waitSent(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m.mu.Lock()
+		sent := len(m.sent)
+		m.mu.Unlock()
+		if sent >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d emails sent, want %d", sent, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// lastToken returns the token in the query parameter param of the link in
+// the most recent message.
+func (m *recordingMailer) lastToken(t *testing.T, param string) string {
+	t.Helper()
+	if len(m.sent) == 0 {
+		t.Fatal("no email was sent")
+	}
+	body := m.sent[len(m.sent)-1].Body
+	i := strings.Index(body, "?"+param+"=")
+	if i < 0 {
+		t.Fatalf("email has no %s link: %q", param, body)
+	}
+	token := body[i+len(param)+2:]
+	return token[:strings.IndexAny(token, "\n ")]
+}
+
+func TestEmailVerification(t *testing.T) {
+	mail := &recordingMailer{}
+	router, store := newTestRouter(handlers.WithMailer(mail))
+	token := signUp(t, router, "erin@example.com")
+
+	first := mail.lastToken(t, "verify_token")
+	if rr := doJSON(router, "POST", "/verify-email/resend", token, nil); rr.Code != http.StatusAccepted {
+		t.Fatalf("ResendVerificationHandler returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+	}
+	second := mail.lastToken(t, "verify_token")
+
+	// Resending invalidates the earlier link.
+	if rr := doJSON(router, "POST", "/verify-email", "", handlers.AccountTokenRequest{Token: first}); rr.Code != http.StatusBadRequest {
+		t.Errorf("superseded verification token returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := doJSON(router, "POST", "/verify-email", "", handlers.AccountTokenRequest{Token: second}); rr.Code != http.StatusNoContent {
+		t.Fatalf("VerifyEmailHandler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	user, _ := store.GetUserByEmail("erin@example.com")
+	if user.EmailVerifiedAt == nil {
+		t.Error("email address was not marked verified")
+	}
+	if rr := doJSON(router, "POST", "/verify-email/resend", token, nil); rr.Code != http.StatusConflict {
+		t.Errorf("ResendVerificationHandler returned wrong status code for a verified user: got %v want %v", rr.Code, http.StatusConflict)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	mail := &recordingMailer{}
+	router, _ := newTestRouter(handlers.WithMailer(mail))
+	tokens := signUpTokens(t, router, "frank@example.com")
+	sent := len(mail.sent)
+
+	// Unknown addresses get the same response and no email.
+	if rr := doJSON(router, "POST", "/password/forgot", "", map[string]string{"email": "nobody@example.com"}); rr.Code != http.StatusAccepted {
+		t.Fatalf("ForgotPasswordHandler returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+	}
+	if len(mail.sent) != sent {
+		t.Fatal("ForgotPasswordHandler emailed an unknown address")
+	}
+	if rr := doJSON(router, "POST", "/password/forgot", "", map[string]string{"email": "frank@example.com"}); rr.Code != http.StatusAccepted {
+		t.Fatalf("ForgotPasswordHandler returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+	}
+	mail.waitSent(t, sent+1)
+	reset := mail.lastToken(t, "reset_token")
+
+	// A weak password is rejected without using up the token.
+	rr := doJSON(router, "POST", "/password/reset", "", handlers.ResetPasswordRequest{Token: reset, Password: "short"})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("ResetPasswordHandler accepted a weak password: %v", rr.Code)
+	}
+	rr = doJSON(router, "POST", "/password/reset", "", handlers.ResetPasswordRequest{Token: reset, Password: "n3w-passw0rd"})
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("ResetPasswordHandler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	rr = doJSON(router, "POST", "/password/reset", "", handlers.ResetPasswordRequest{Token: reset, Password: "an0ther-passw0rd"})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("reset token was accepted twice: %v", rr.Code)
+	}
+
+	if rr := doJSON(router, "GET", "/user/urls", tokens.Token, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("access token still valid after password reset: %v", rr.Code)
+	}
+	if rr := doJSON(router, "POST", "/login", "", map[string]string{"email": "frank@example.com", "password": "s3cret-passw0rd"}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("old password still accepted: %v", rr.Code)
+	}
+	if rr := doJSON(router, "POST", "/login", "", map[string]string{"email": "frank@example.com", "password": "n3w-passw0rd"}); rr.Code != http.StatusOK {
+		t.Errorf("new password rejected: %v", rr.Code)
+	}
+
+	// Each client may only ask for so many emails, whatever the address
+	forgot := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/password/forgot", strings.NewReader(`{"email":"nobody@example.com"}`))
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	for i := 0; i < config.Default().Auth.EmailRequests; i++ {
+		if rr := forgot("192.0.2.1:1234"); rr.Code != http.StatusAccepted {
+			t.Fatalf("request %d: got status %v want %v", i+1, rr.Code, http.StatusAccepted)
+		}
+	}
+	if rr := forgot("192.0.2.1:1234"); rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("request over the limit returned %v %v", rr.Code, rr.Header())
+	}
+	if rr := forgot("198.51.100.7:1234"); rr.Code != http.StatusAccepted {
+		t.Errorf("another client was limited too: %v", rr.Code)
+	}
+	if rr := doJSON(router, "POST", "/create", tokens.Token, map[string]string{"originalUrl": "https://example.com"}); rr.Code == http.StatusTooManyRequests {
+		t.Error("email requests used up link creation")
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	// Behind the user cache, which leaves out password hashes
+	mail := &recordingMailer{}
+	cfg := config.Default()
+	cfg.RateLimit.Burst = 1000
+	store := storage.NewMemoryStore()
+	stores := store.Stores()
+	stores.Users = storage.NewCachedUserStore(store, storage.NewLRUCache(10), time.Minute)
+	router := api.NewRouter(cfg, stores, handlers.WithMailer(mail))
+	tokens := signUpTokens(t, router, "grace@example.com")
+
+	rr := doJSON(router, "POST", "/password/change", tokens.Token, handlers.ChangePasswordRequest{CurrentPassword: "wrong", NewPassword: "n3w-passw0rd"})
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("ChangePasswordHandler accepted a wrong current password: %v", rr.Code)
+	}
+	rr = doJSON(router, "POST", "/password/change", tokens.Token, handlers.ChangePasswordRequest{CurrentPassword: "s3cret-passw0rd", NewPassword: "n3w-passw0rd"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("ChangePasswordHandler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var fresh handlers.TokenResponse
+	json.Unmarshal(rr.Body.Bytes(), &fresh)
+
+	if rr := doJSON(router, "GET", "/user/urls", tokens.Token, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("old access token still valid after password change: %v", rr.Code)
+	}
+	if rr := doJSON(router, "GET", "/user/urls", fresh.Token, nil); rr.Code != http.StatusOK {
+		t.Errorf("new access token rejected: %v", rr.Code)
+	}
+	if last := mail.sent[len(mail.sent)-1]; last.Subject != "Your password was changed" {
+		t.Errorf("no password change notification sent, last subject %q", last.Subject)
+	}
+}
+
+// newOIDCRouter wires a test router to a mock identity provider.
+func newOIDCRouter(t *testing.T) (*mux.Router, *storage.MemoryStore, *oidctest.Provider) {
+	t.Helper()
+	srv, idp := oidctest.NewServer("shortener")
+	t.Cleanup(srv.Close)
+	provider, err := oidc.Discover(context.Background(), config.OIDCConfig{
+		Issuer:      idp.Issuer,
+		ClientID:    "shortener",
+		RedirectURL: "http://localhost:8080/auth/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+	})
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	router, store := newTestRouter(handlers.WithOIDC(provider))
+	return router, store, idp
+}
+
+// oidcLogin runs the browser side of single sign-on and returns the
+// fragment parameters the callback redirects to the frontend with.
+func oidcLogin(t *testing.T, router http.Handler) url.Values {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/auth/oidc/login", nil))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("OIDCLoginHandler returned wrong status code: got %v want %v", rr.Code, http.StatusFound)
+	}
+	cookies := rr.Result().Cookies()
+
+	// The mock provider approves the request and redirects back at once
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", callback.RequestURI(), nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("OIDCCallbackHandler returned wrong status code: got %v want %v", rr.Code, http.StatusFound)
+	}
+	frontend, _ := url.Parse(rr.Header().Get("Location"))
+	params, _ := url.ParseQuery(frontend.Fragment)
+	return params
+}
+
+// oidcTokens completes single sign-on and returns the session tokens.
+func oidcTokens(t *testing.T, router http.Handler) handlers.TokenResponse {
+	t.Helper()
+	params := oidcLogin(t, router)
+	if params.Get("login_token") == "" {
+		t.Fatalf("single sign-on failed: %v", params)
+	}
+	rr := doJSON(router, "POST", "/auth/oidc/token", "", handlers.AccountTokenRequest{Token: params.Get("login_token")})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("OIDCTokenHandler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var tokens handlers.TokenResponse
+	json.Unmarshal(rr.Body.Bytes(), &tokens)
+
+	// The login token works once
+	rr = doJSON(router, "POST", "/auth/oidc/token", "", handlers.AccountTokenRequest{Token: params.Get("login_token")})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("login token was accepted twice: %v", rr.Code)
+	}
+	return tokens
+}
+
+func TestOIDCLoginCreatesAndLinksUser(t *testing.T) {
+	router, store, idp := newOIDCRouter(t)
+
+	tokens := oidcTokens(t, router)
+	if rr := doJSON(router, "GET", "/user/urls", tokens.Token, nil); rr.Code != http.StatusOK {
+		t.Errorf("single sign-on access token rejected: %v", rr.Code)
+	}
+	user, err := store.GetUserByEmail("alice@example.com")
+	if err != nil || user.EmailVerifiedAt == nil {
+		t.Fatalf("single sign-on did not create a verified user: %+v, %v", user, err)
+	}
+	if rr := doJSON(router, "POST", "/login", "", map[string]string{"email": "alice@example.com", "password": ""}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("passwordless user could log in with an empty password: %v", rr.Code)
+	}
+
+	// Later logins find the user by subject, even after an address change.
+	idp.Email = "alice@new.example.com"
+	oidcTokens(t, router)
+	if _, err := store.GetUserByEmail("alice@new.example.com"); !errors.Is(err, storage.ErrUserNotFound) {
+		t.Errorf("a second user was created for the same subject: %v", err)
+	}
+}
+
+func TestOIDCLoginLinksExistingUser(t *testing.T) {
+	router, store, idp := newOIDCRouter(t)
+	idp.Subject, idp.Email = "bob-sub", "bob@example.com"
+	password := signUpTokens(t, router, "bob@example.com")
+
+	oidcTokens(t, router)
+	identity, err := store.GetIdentity(idp.Issuer, "bob-sub")
+	if err != nil {
+		t.Fatalf("identity was not linked: %v", err)
+	}
+	user, _ := store.GetUserByEmail("bob@example.com")
+	if identity.UserID != user.ID {
+		t.Errorf("identity linked to user %d, want %d", identity.UserID, user.ID)
+	}
+	// The password was set on an unverified address, so it is dropped
+	if rr := doJSON(router, "GET", "/user/urls", password.Token, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("session of unverified account survived linking: %v", rr.Code)
+	}
+}
+
+func TestOIDCLoginFailures(t *testing.T) {
+	router, store, idp := newOIDCRouter(t)
+
+	idp.EmailVerified = false
+	if params := oidcLogin(t, router); params.Get("oidc_error") != "email_not_verified" {
+		t.Errorf("unverified email: got %v", params)
+	}
+	if _, err := store.GetUserByEmail("alice@example.com"); !errors.Is(err, storage.ErrUserNotFound) {
+		t.Errorf("user created from an unverified email: %v", err)
+	}
+
+	// A callback without the login cookie is a forged or replayed request.
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/auth/oidc/callback?code=x&state=y", nil))
+	frontend, _ := url.Parse(rr.Header().Get("Location"))
+	if params, _ := url.ParseQuery(frontend.Fragment); params.Get("oidc_error") != "invalid_state" {
+		t.Errorf("callback without state cookie: got %q", rr.Header().Get("Location"))
+	}
+
+	router, _ = newTestRouter()
+	if rr := doJSON(router, "GET", "/auth/oidc/login", "", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("OIDCLoginHandler without a provider returned %v, want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+// createWorkspace creates a workspace owned by token's user and returns it.
+func createWorkspace(t *testing.T, router http.Handler, token, name string) models.Workspace {
+	t.Helper()
+	rr := doJSON(router, "POST", "/workspaces", token, handlers.CreateWorkspaceRequest{Name: name})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("CreateWorkspaceHandler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	var workspace models.Workspace
+	json.Unmarshal(rr.Body.Bytes(), &workspace)
+	return workspace
+}
+
+// joinWorkspace invites email to workspace with role and accepts the
+// invitation as token's user.
+func joinWorkspace(t *testing.T, router http.Handler, mail *recordingMailer, ownerToken, token, email string, workspace models.Workspace, role string) {
+	t.Helper()
+	path := "/workspaces/" + strconv.Itoa(workspace.ID) + "/invitations"
+	if rr := doJSON(router, "POST", path, ownerToken, handlers.CreateInvitationRequest{Email: email, Role: role}); rr.Code != http.StatusCreated {
+		t.Fatalf("CreateInvitationHandler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	rr := doJSON(router, "POST", "/invitations/accept", token, handlers.AccountTokenRequest{Token: mail.lastToken(t, "invite_token")})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("AcceptInvitationHandler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestWorkspaceInvitations(t *testing.T) {
+	mail := &recordingMailer{}
+	router, _ := newTestRouter(handlers.WithMailer(mail))
+	owner := signUp(t, router, "erin@example.com")
+	invitee := signUp(t, router, "frank@example.com")
+	other := signUp(t, router, "grace@example.com")
+	workspace := createWorkspace(t, router, owner, "Marketing")
+	path := "/workspaces/" + strconv.Itoa(workspace.ID)
+
+	if rr := doJSON(router, "POST", path+"/invitations", owner, handlers.CreateInvitationRequest{Email: "frank@example.com", Role: "editor"}); rr.Code != http.StatusCreated {
+		t.Fatalf("CreateInvitationHandler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	token := mail.lastToken(t, "invite_token")
+
+	// Only the invited address can accept, and only once.
+	if rr := doJSON(router, "POST", "/invitations/accept", other, handlers.AccountTokenRequest{Token: token}); rr.Code != http.StatusForbidden {
+		t.Errorf("accepting another user's invitation: got status %v want %v", rr.Code, http.StatusForbidden)
+	}
+	rr := doJSON(router, "POST", "/invitations/accept", invitee, handlers.AccountTokenRequest{Token: token})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("AcceptInvitationHandler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var joined models.Workspace
+	json.Unmarshal(rr.Body.Bytes(), &joined)
+	if joined.ID != workspace.ID || joined.Role != models.RoleEditor {
+		t.Errorf("accepted invitation returned %+v", joined)
+	}
+	if rr := doJSON(router, "POST", "/invitations/accept", invitee, handlers.AccountTokenRequest{Token: token}); rr.Code != http.StatusBadRequest {
+		t.Errorf("reused invitation: got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := doJSON(router, "POST", path+"/invitations", owner, handlers.CreateInvitationRequest{Email: "frank@example.com", Role: "viewer"}); rr.Code != http.StatusConflict {
+		t.Errorf("inviting a member: got status %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	// Editors can't invite, and non-members can't see the workspace.
+	if rr := doJSON(router, "POST", path+"/invitations", invitee, handlers.CreateInvitationRequest{Email: "grace@example.com", Role: "viewer"}); rr.Code != http.StatusForbidden {
+		t.Errorf("editor inviting: got status %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if rr := doJSON(router, "GET", path+"/members", other, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("non-member listing members: got status %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestWorkspaceLinkRoles(t *testing.T) {
+	mail := &recordingMailer{}
+	router, _ := newTestRouter(handlers.WithMailer(mail))
+	owner := signUp(t, router, "erin@example.com")
+	editor := signUp(t, router, "frank@example.com")
+	viewer := signUp(t, router, "grace@example.com")
+	outsider := signUp(t, router, "heidi@example.com")
+	workspace := createWorkspace(t, router, owner, "Marketing")
+	joinWorkspace(t, router, mail, owner, editor, "frank@example.com", workspace, models.RoleEditor)
+	joinWorkspace(t, router, mail, owner, viewer, "grace@example.com", workspace, models.RoleViewer)
+
+	create := map[string]interface{}{"originalUrl": "https://example.com/launch", "alias": "launch", "workspaceId": workspace.ID}
+	if rr := doJSON(router, "POST", "/create", viewer, create); rr.Code != http.StatusForbidden {
+		t.Errorf("viewer creating a workspace link: got status %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if rr := doJSON(router, "POST", "/create", outsider, create); rr.Code != http.StatusNotFound {
+		t.Errorf("outsider creating a workspace link: got status %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := doJSON(router, "POST", "/create", editor, create); rr.Code != http.StatusOK {
+		t.Fatalf("editor creating a workspace link: got status %v want %v", rr.Code, http.StatusOK)
+	}
+
+	rr := doJSON(router, "GET", "/workspaces/"+strconv.Itoa(workspace.ID)+"/urls", viewer, nil)
+	var links storage.URLPage
+	json.Unmarshal(rr.Body.Bytes(), &links)
+	if rr.Code != http.StatusOK || len(links.Items) != 1 || links.Items[0].ShortCode != "launch" {
+		t.Errorf("GetWorkspaceURLsHandler returned %v %s", rr.Code, rr.Body.String())
+	}
+	// Workspace links aren't personal links of their creator.
+	rr = doJSON(router, "GET", "/user/urls", editor, nil)
+	if strings.Contains(rr.Body.String(), "launch") {
+		t.Errorf("workspace link listed as a personal link: %s", rr.Body.String())
+	}
+
+	if rr := doJSON(router, "GET", "/analytics/launch", viewer, nil); rr.Code != http.StatusOK {
+		t.Errorf("viewer reading analytics: got status %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := doJSON(router, "GET", "/analytics/launch", outsider, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("outsider reading analytics: got status %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := doJSON(router, "DELETE", "/delete/launch", viewer, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("viewer deleting: got status %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if rr := doJSON(router, "PATCH", "/urls/launch", owner, map[string]string{"originalUrl": "https://example.com/launch-v2"}); rr.Code != http.StatusOK {
+		t.Errorf("owner editing another member's link: got status %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := doJSON(router, "DELETE", "/delete/launch", editor, nil); rr.Code != http.StatusOK {
+		t.Errorf("editor deleting: got status %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestWorkspaceKeepsAnOwner(t *testing.T) {
+	mail := &recordingMailer{}
+	router, store := newTestRouter(handlers.WithMailer(mail))
+	owner := signUp(t, router, "erin@example.com")
+	admin := signUp(t, router, "frank@example.com")
+	workspace := createWorkspace(t, router, owner, "Marketing")
+	joinWorkspace(t, router, mail, owner, admin, "frank@example.com", workspace, models.RoleAdmin)
+	ownerUser, _ := store.GetUserByEmail("erin@example.com")
+	adminUser, _ := store.GetUserByEmail("frank@example.com")
+	members := "/workspaces/" + strconv.Itoa(workspace.ID) + "/members/"
+
+	if rr := doJSON(router, "PATCH", members+strconv.Itoa(ownerUser.ID), owner, handlers.UpdateMemberRequest{Role: "viewer"}); rr.Code != http.StatusConflict {
+		t.Errorf("demoting the last owner: got status %v want %v", rr.Code, http.StatusConflict)
+	}
+	if rr := doJSON(router, "DELETE", members+strconv.Itoa(ownerUser.ID), owner, nil); rr.Code != http.StatusConflict {
+		t.Errorf("last owner leaving: got status %v want %v", rr.Code, http.StatusConflict)
+	}
+	if rr := doJSON(router, "PATCH", members+strconv.Itoa(adminUser.ID), admin, handlers.UpdateMemberRequest{Role: "owner"}); rr.Code != http.StatusForbidden {
+		t.Errorf("admin promoting to owner: got status %v want %v", rr.Code, http.StatusForbidden)
+	}
+
+	// Once there is another owner, the first can step down.
+	if rr := doJSON(router, "PATCH", members+strconv.Itoa(adminUser.ID), owner, handlers.UpdateMemberRequest{Role: "owner"}); rr.Code != http.StatusOK {
+		t.Fatalf("UpdateMemberHandler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := doJSON(router, "DELETE", members+strconv.Itoa(ownerUser.ID), owner, nil); rr.Code != http.StatusNoContent {
+		t.Errorf("RemoveMemberHandler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+}
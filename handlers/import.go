@@ -0,0 +1,222 @@
+// handlers/import.go
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"url-shortener/models"
+
+	"golang.org/x/net/html"
+)
+
+// ImportURLsHandler imports links exported by ExportURLsHandler, or by
+// another shortener or a browser in the same formats: CSV, NDJSON or a
+// Netscape bookmarks file, detected as for bulk creates. Links keep their
+// short codes as aliases, creation times, visit counts and last visit times. Rows fail on
+// their own, with alias_taken if the code is in use, url_already_shortened
+// if the URL is, or password_required for protected links, since exports
+// don't include password hashes, unless a password is given for them. Links are imported into the
+// workspace named by the workspaceId query parameter, if set, and large
+// imports run as jobs; see runBulk.
+func (h *Handler) ImportURLsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	workspaceID, ok := h.queryWorkspace(w, r, user, models.RoleEditor)
+	if !ok {
+		return
+	}
+	rows, ok := h.readBulkRows(w, r, map[string]bulkParser{
+		formatCSV:       parseImportCSV,
+		formatNDJSON:    parseImportNDJSON,
+		formatBookmarks: parseBookmarks,
+	})
+	if !ok {
+		return
+	}
+
+	for i := range rows {
+		// Workspaces in the file are those of the exporting server
+		rows[i].link.WorkspaceID = workspaceID
+		if rows[i].link.Alias == "" {
+			rows[i].link.Alias = rows[i].shortCode
+		}
+		// Rather than importing protected links as public ones
+		if rows[i].err == nil && rows[i].protected && rows[i].link.Password == "" {
+			rows[i].err = badRequest("password_required", "link is password-protected, and exports leave out passwords; add a password column or field")
+		}
+	}
+	roles := map[int]error{workspaceID: nil}
+	h.runBulk(w, r, user, models.BulkImport, rows, func(row bulkRow) models.BulkResult {
+		return h.bulkCreateRow(user, row, roles)
+	})
+}
+
+// csvImportColumns extends csvCreateColumns with the columns of CSV exports.
+// updatedAt is ignored, since imported links are new.
+var csvImportColumns = func() map[string]func(*bulkRow, string) error {
+	columns := map[string]func(*bulkRow, string) error{
+		"shortcode":     func(row *bulkRow, v string) error { row.shortCode = v; return nil },
+		"visitcount":    csvInt(func(row *bulkRow) *int { return &row.visits }, "invalid_row", "visitCount"),
+		"createdat":     csvTime(func(row *bulkRow, t *time.Time) { row.createdAt = t }, "invalid_row", "createdAt"),
+		"lastvisitedat": csvTime(func(row *bulkRow, t *time.Time) { row.lastVisitedAt = t }, "invalid_row", "lastVisitedAt"),
+		"protected": func(row *bulkRow, v string) error {
+			protected, err := strconv.ParseBool(v)
+			if err != nil {
+				return badRequest("invalid_row", "protected must be true or false")
+			}
+			row.protected = protected
+			return nil
+		},
+	}
+	columns["updatedat"] = func(*bulkRow, string) error { return nil }
+	for name, set := range csvCreateColumns {
+		columns[name] = set
+	}
+	return columns
+}()
+
+func parseImportCSV(body []byte) ([]bulkRow, error) {
+	return parseCSVRows(body, csvImportColumns)
+}
+
+// importRecord is a line of an NDJSON export. The fields of
+// models.URLMapping that aren't create options, other than these, are
+// ignored.
+type importRecord struct {
+	createURLRequest
+	ShortCode     string     `json:"shortCode"`
+	VisitCount    int        `json:"visitCount"`
+	CreatedAt     *time.Time `json:"createdAt"`
+	LastVisitedAt *time.Time `json:"lastVisitedAt"`
+	Protected     bool       `json:"protected"`
+}
+
+// parseImportNDJSON reads one link per non-blank line.
+func parseImportNDJSON(body []byte) ([]bulkRow, error) {
+	var rows []bulkRow
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		row := bulkRow{index: len(rows) + 1}
+		var record importRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			row.err = badRequest("invalid_row", err.Error())
+		} else {
+			row.link, row.shortCode, row.visits = record.createURLRequest, record.ShortCode, record.VisitCount
+			row.lastVisitedAt, row.protected = record.LastVisitedAt, record.Protected
+			if record.CreatedAt != nil && !record.CreatedAt.IsZero() {
+				row.createdAt = record.CreatedAt
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseBookmarks reads the links of a Netscape bookmarks file, as exported
+// by browsers and other services: the A elements, with their text as the
+// title, TAGS, ADD_DATE, LAST_VISIT and, as written by ExportURLsHandler,
+// SHORTCODE, VISIT_COUNT and PROTECTED, and the text of the DD following them as the description.
+// Folders are flattened.
+func parseBookmarks(body []byte) ([]bulkRow, error) {
+	var rows []bulkRow
+	var text strings.Builder
+	// inLink and inDescription say what the text read belongs to
+	inLink, inDescription := false, false
+	endDescription := func() {
+		if inDescription {
+			rows[len(rows)-1].link.Description = strings.TrimSpace(text.String())
+			inDescription = false
+		}
+	}
+
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				return nil, badRequest("invalid_body", z.Err().Error())
+			}
+			endDescription()
+			return rows, nil
+		case html.TextToken:
+			if inLink || inDescription {
+				text.Write(z.Text())
+			}
+		case html.StartTagToken:
+			name, hasAttr := z.TagName()
+			endDescription()
+			switch string(name) {
+			case "a":
+				row := bulkRow{index: len(rows) + 1}
+				for hasAttr {
+					var key, val []byte
+					key, val, hasAttr = z.TagAttr()
+					setBookmarkAttr(&row, string(key), strings.TrimSpace(string(val)))
+				}
+				rows = append(rows, row)
+				inLink = true
+				text.Reset()
+			case "dd":
+				if len(rows) > 0 && rows[len(rows)-1].link.Description == "" {
+					inDescription = true
+					text.Reset()
+				}
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if string(name) == "a" && inLink {
+				link := &rows[len(rows)-1].link
+				// Untitled bookmarks are often named after their URL
+				if title := strings.TrimSpace(text.String()); title != link.OriginalURL {
+					link.Title = title
+				}
+				inLink = false
+			} else {
+				endDescription()
+			}
+		}
+	}
+}
+
+func setBookmarkAttr(row *bulkRow, key, val string) {
+	switch key {
+	case "href":
+		row.link.OriginalURL = val
+	case "shortcode":
+		row.shortCode = val
+	case "visit_count":
+		row.visits, _ = strconv.Atoi(val)
+	case "protected":
+		row.protected = val != "" && val != "false"
+	case "tags":
+		for _, tag := range strings.Split(val, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				row.link.Tags = append(row.link.Tags, tag)
+			}
+		}
+	case "add_date":
+		row.createdAt = bookmarkTime(val)
+	case "last_visit":
+		row.lastVisitedAt = bookmarkTime(val)
+	}
+}
+
+// bookmarkTime parses a bookmark date, in seconds since the epoch.
+// Unparseable dates are left out.
+func bookmarkTime(val string) *time.Time {
+	secs, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || secs <= 0 {
+		return nil
+	}
+	t := time.Unix(secs, 0)
+	return &t
+}
@@ -0,0 +1,290 @@
+// handlers/links.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"url-shortener/models"
+	"url-shortener/storage"
+	"url-shortener/utils"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// defaultPageSize and maxPageSize bound the limit parameter of link
+	// listings.
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// updateURLRequest is the payload accepted by UpdateURLHandler. Omitted
+// fields are left unchanged.
+type updateURLRequest struct {
+	OriginalURL *string `json:"originalUrl"`
+	// RedirectType 0 reverts to the server default.
+	RedirectType *int      `json:"redirectType"`
+	Title        *string   `json:"title"`
+	Description  *string   `json:"description"`
+	Tags         *[]string `json:"tags"`
+	// Empty Open Graph fields fall back to the title, description and
+	// fetched preview.
+	OGTitle       *string `json:"ogTitle"`
+	OGDescription *string `json:"ogDescription"`
+	OGImage       *string `json:"ogImage"`
+	// Password replaces the link's password; an empty one removes it.
+	Password *string `json:"password"`
+}
+
+// UpdateURLHandler handles PATCH requests editing one of the caller's links,
+// or a workspace link they are an editor of, while keeping its short code
+// and visit count.
+func (h *Handler) UpdateURLHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req updateURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	urlMapping, ok := h.accessibleURLMapping(w, user, mux.Vars(r)["shortCode"], models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	destinationChanged := false
+	if req.OriginalURL != nil {
+		sanitizedURL, err := utils.SanitizeURL(*req.OriginalURL)
+		if err != nil {
+			http.Error(w, "Invalid URL", http.StatusBadRequest)
+			return
+		}
+		if sanitizedURL != urlMapping.OriginalURL {
+			// The store drops the old preview along with the destination
+			urlMapping.OriginalURL, urlMapping.Preview = sanitizedURL, nil
+			destinationChanged = true
+		}
+	}
+	if req.RedirectType != nil {
+		if *req.RedirectType != 0 && !utils.IsValidRedirectStatus(*req.RedirectType) {
+			writeError(w, http.StatusBadRequest, "invalid_redirect_type", "redirectType must be 301, 302, 307 or 308")
+			return
+		}
+		urlMapping.RedirectType = *req.RedirectType
+	}
+	if req.Title != nil {
+		urlMapping.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.Description != nil {
+		urlMapping.Description = strings.TrimSpace(*req.Description)
+	}
+	if req.Tags != nil {
+		urlMapping.Tags = *req.Tags
+	}
+	var err error
+	if urlMapping.Tags, err = validateLinkMetadata(urlMapping.Title, urlMapping.Description, urlMapping.Tags); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if req.OGTitle != nil {
+		urlMapping.OGTitle = *req.OGTitle
+	}
+	if req.OGDescription != nil {
+		urlMapping.OGDescription = *req.OGDescription
+	}
+	if req.OGImage != nil {
+		urlMapping.OGImage = *req.OGImage
+	}
+	if err := validateSocialCard(&urlMapping); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if req.Password != nil {
+		urlMapping.PasswordHash = ""
+		if *req.Password != "" {
+			if urlMapping.PasswordHash, err = hashLinkPassword(*req.Password); err != nil {
+				writeRequestError(w, err)
+				return
+			}
+		}
+		urlMapping.Protected = urlMapping.PasswordHash != ""
+	}
+	urlMapping.UpdatedAt = time.Now()
+
+	err = h.links.UpdateURLMapping(urlMapping)
+	switch {
+	case errors.Is(err, storage.ErrDuplicateURL):
+		writeError(w, http.StatusConflict, "url_already_shortened", "URL is already shortened by another link")
+		return
+	case errors.Is(err, storage.ErrURLNotFound):
+		http.Error(w, "Short URL not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if destinationChanged {
+		h.enqueuePreview(urlMapping)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(urlMapping)
+}
+
+// GetURLRevisionsHandler lists the previous destinations of a link the
+// caller can see.
+func (h *Handler) GetURLRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	urlMapping, ok := h.accessibleURLMapping(w, user, mux.Vars(r)["shortCode"], models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	revisions, err := h.links.GetURLRevisions(urlMapping.UserID, urlMapping.ShortCode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// accessibleURLMapping loads the mapping for shortCode and checks that user
+// may act on it with at least role; see urlMappingAccess.
+func (h *Handler) accessibleURLMapping(w http.ResponseWriter, user models.User, shortCode, role string) (models.URLMapping, bool) {
+	urlMapping, err := h.links.GetURLMappingByShortCode(shortCode)
+	if errors.Is(err, storage.ErrURLNotFound) {
+		err = errShortURLNotFound
+	} else if err == nil {
+		err = h.urlMappingAccess(user, urlMapping, role)
+	}
+	if err != nil {
+		writeRequestError(w, err)
+		return urlMapping, false
+	}
+	return urlMapping, true
+}
+
+// authorizeURLMapping checks that user may act on urlMapping with at least
+// role, or writes the error response and returns false.
+func (h *Handler) authorizeURLMapping(w http.ResponseWriter, user models.User, urlMapping models.URLMapping, role string) bool {
+	if err := h.urlMappingAccess(user, urlMapping, role); err != nil {
+		writeRequestError(w, err)
+		return false
+	}
+	return true
+}
+
+// urlMappingAccess checks that user may act on urlMapping: personal links
+// belong to their creator alone, workspace links to members with at least
+// role. Users who can't see the link get a 404, so other users' codes aren't
+// revealed, and members with a lower role a 403.
+func (h *Handler) urlMappingAccess(user models.User, urlMapping models.URLMapping, role string) error {
+	if urlMapping.WorkspaceID == 0 {
+		if urlMapping.UserID != user.ID {
+			return errShortURLNotFound
+		}
+		return nil
+	}
+
+	member, err := h.workspaces.GetMember(urlMapping.WorkspaceID, user.ID)
+	if errors.Is(err, storage.ErrNotMember) {
+		return errShortURLNotFound
+	} else if err != nil {
+		return err
+	}
+	if !models.RoleAllows(member.Role, role) {
+		return errInsufficientRole(role)
+	}
+	return nil
+}
+
+// listURLMappings responds with the page of links selected by q and the
+// request's query parameters: limit, cursor, q (search), tag, domain, expiry
+// (active, expired or never), sort (created or visits) and order (asc or
+// desc, the default).
+func (h *Handler) listURLMappings(w http.ResponseWriter, r *http.Request, q storage.URLQuery) {
+	params := r.URL.Query()
+	q.Limit = defaultPageSize
+	if s := params.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			writeError(w, http.StatusBadRequest, "invalid_limit", fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+			return
+		}
+		q.Limit = limit
+	}
+	q.Sort = params.Get("sort")
+	switch q.Sort {
+	case "":
+		q.Sort = storage.SortCreated
+	case storage.SortCreated, storage.SortVisits:
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_sort", "sort must be created or visits")
+		return
+	}
+	switch params.Get("order") {
+	case "", "desc":
+	case "asc":
+		q.Ascending = true
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_order", "order must be asc or desc")
+		return
+	}
+	q.Expiry = params.Get("expiry")
+	switch q.Expiry {
+	case "", storage.ExpiryActive, storage.ExpiryExpired, storage.ExpiryNever:
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_expiry", "expiry must be active, expired or never")
+		return
+	}
+	q.Search = strings.TrimSpace(params.Get("q"))
+	q.Domain = strings.TrimSpace(params.Get("domain"))
+	q.Tag = strings.ToLower(strings.TrimSpace(params.Get("tag")))
+	q.Cursor = params.Get("cursor")
+	q.Now = time.Now()
+
+	page, err := h.links.ListURLMappings(q)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		writeError(w, http.StatusBadRequest, "invalid_cursor", "cursor is invalid or was issued for another sort order")
+		return
+	} else if err != nil {
+		log.Printf("Error retrieving URL mappings: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// validateLinkMetadata checks the title and description lengths and returns
+// the normalized tags.
+func validateLinkMetadata(title, description string, tags []string) ([]string, error) {
+	if len(strings.TrimSpace(title)) > utils.MaxTitleLength {
+		return nil, badRequest("invalid_title", fmt.Sprintf("title must be at most %d characters", utils.MaxTitleLength))
+	}
+	if len(strings.TrimSpace(description)) > utils.MaxDescriptionLength {
+		return nil, badRequest("invalid_description", fmt.Sprintf("description must be at most %d characters", utils.MaxDescriptionLength))
+	}
+	tags, err := utils.NormalizeTags(tags)
+	if err != nil {
+		return nil, badRequest("invalid_tags", err.Error())
+	}
+	return tags, nil
+}
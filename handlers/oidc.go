@@ -0,0 +1,212 @@
+// handlers/oidc.go
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"url-shortener/auth"
+	"url-shortener/models"
+	"url-shortener/oidc"
+	"url-shortener/storage"
+)
+
+const (
+	// oidcCookieName holds the state, nonce and PKCE verifier of a login in
+	// progress, joined by dots.
+	oidcCookieName = "oidc_login"
+	oidcCookiePath = "/auth/oidc"
+	// oidcLoginTimeout is how long the user has to sign in at the provider.
+	oidcLoginTimeout = 10 * time.Minute
+	// oidcHandoffTTL is how long the frontend has to exchange the login
+	// token it is redirected with.
+	oidcHandoffTTL = time.Minute
+)
+
+var errEmailNotVerified = errors.New("the provider has not verified the email address")
+
+// OIDCLoginHandler starts single sign-on by sending the browser to the
+// provider.
+func (h *Handler) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if h.sso == nil {
+		writeError(w, http.StatusNotFound, "oidc_disabled", "single sign-on is not configured")
+		return
+	}
+
+	state, nonce, verifier := randomToken(16), randomToken(16), randomToken(32)
+	h.setOIDCCookie(w, state+"."+nonce+"."+verifier, int(oidcLoginTimeout/time.Second))
+	http.Redirect(w, r, h.sso.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+}
+
+// OIDCCallbackHandler completes single sign-on when the provider sends the
+// browser back. The user is found by their provider account, or else linked
+// or created by verified email address. The browser is then redirected to
+// the frontend with a short-lived login_token, or an oidc_error code, in
+// the URL fragment; the frontend exchanges the token at OIDCTokenHandler so
+// that no session tokens end up in URLs.
+func (h *Handler) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if h.sso == nil {
+		writeError(w, http.StatusNotFound, "oidc_disabled", "single sign-on is not configured")
+		return
+	}
+	// The login attempt is over whatever the outcome
+	h.setOIDCCookie(w, "", -1)
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		h.redirectToFrontend(w, r, url.Values{"oidc_error": {e}})
+		return
+	}
+	cookie, err := r.Cookie(oidcCookieName)
+	var parts []string
+	if err == nil {
+		parts = strings.Split(cookie.Value, ".")
+	}
+	if len(parts) != 3 || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(q.Get("state"))) != 1 {
+		h.redirectToFrontend(w, r, url.Values{"oidc_error": {"invalid_state"}})
+		return
+	}
+	nonce, verifier := parts[1], parts[2]
+
+	rawIDToken, err := h.sso.Exchange(r.Context(), q.Get("code"), verifier)
+	if err != nil {
+		log.Printf("Error exchanging OIDC code: %v", err)
+		h.redirectToFrontend(w, r, url.Values{"oidc_error": {"login_failed"}})
+		return
+	}
+	idToken, err := h.sso.Verify(r.Context(), rawIDToken, nonce)
+	if err != nil {
+		log.Printf("Error verifying OIDC ID token: %v", err)
+		h.redirectToFrontend(w, r, url.Values{"oidc_error": {"login_failed"}})
+		return
+	}
+
+	user, err := h.oidcUser(idToken)
+	if errors.Is(err, errEmailNotVerified) {
+		h.redirectToFrontend(w, r, url.Values{"oidc_error": {"email_not_verified"}})
+		return
+	} else if err != nil {
+		log.Printf("Error signing in OIDC user: %v", err)
+		h.redirectToFrontend(w, r, url.Values{"oidc_error": {"login_failed"}})
+		return
+	}
+
+	secret := randomToken(32)
+	err = h.accounts.SaveAccountToken(models.AccountToken{
+		Hash:      auth.HashToken(secret),
+		UserID:    user.ID,
+		Purpose:   models.TokenPurposeOIDCLogin,
+		ExpiresAt: time.Now().Add(oidcHandoffTTL),
+	})
+	if err != nil {
+		log.Printf("Error saving OIDC login token: %v", err)
+		h.redirectToFrontend(w, r, url.Values{"oidc_error": {"login_failed"}})
+		return
+	}
+	h.redirectToFrontend(w, r, url.Values{"login_token": {secret}})
+}
+
+// OIDCTokenHandler exchanges the login token from OIDCCallbackHandler for
+// the same token pair LoginHandler returns.
+func (h *Handler) OIDCTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req accountTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	token, ok := h.useAccountToken(w, req.Token, models.TokenPurposeOIDCLogin, time.Now())
+	if !ok {
+		return
+	}
+	user, err := h.users.GetUserByID(token.UserID)
+	if err != nil {
+		log.Printf("Error retrieving user: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	h.startSession(w, user, http.StatusOK)
+}
+
+// oidcUser returns the user signing in with idToken. A provider account seen
+// for the first time is linked to the user with the same email address,
+// who is created if needed, provided the provider verified the address.
+func (h *Handler) oidcUser(idToken oidc.IDToken) (models.User, error) {
+	identity, err := h.identities.GetIdentity(idToken.Issuer, idToken.Subject)
+	if err == nil {
+		return h.users.GetUserByID(identity.UserID)
+	} else if !errors.Is(err, storage.ErrIdentityNotFound) {
+		return models.User{}, err
+	}
+
+	if idToken.Email == "" || !idToken.EmailVerified {
+		return models.User{}, errEmailNotVerified
+	}
+	user, err := h.users.GetUserByEmail(idToken.Email)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		// Users created here have no password; they can set one with a reset
+		err = h.users.SaveUser(models.User{Email: idToken.Email})
+		if err != nil && !errors.Is(err, storage.ErrEmailTaken) {
+			return models.User{}, err
+		}
+		user, err = h.users.GetUserByEmail(idToken.Email)
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	now := time.Now()
+	if user.EmailVerifiedAt == nil {
+		// Whoever set the password of an unverified account may not own the
+		// address, so they must not keep access to the linked account
+		if user.Password != "" {
+			if err := h.users.UpdatePassword(user.ID, ""); err != nil {
+				return models.User{}, err
+			}
+			if err := h.sessions.RevokeUserSessions(user.ID, now); err != nil {
+				return models.User{}, err
+			}
+		}
+		if err := h.users.MarkEmailVerified(user.ID, now); err != nil {
+			return models.User{}, err
+		}
+	}
+
+	err = h.identities.LinkIdentity(models.Identity{
+		Issuer:    idToken.Issuer,
+		Subject:   idToken.Subject,
+		UserID:    user.ID,
+		Email:     idToken.Email,
+		CreatedAt: now,
+	})
+	if errors.Is(err, storage.ErrIdentityTaken) {
+		// A concurrent first login linked it already
+		return h.oidcUser(idToken)
+	}
+	return user, err
+}
+
+func (h *Handler) setOIDCCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    value,
+		Path:     oidcCookiePath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.publicURL, "https://"),
+		// Lax so the cookie comes along on the provider's redirect back
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// redirectToFrontend sends the browser to the frontend with params in the
+// URL fragment, which browsers don't send to servers or in Referer headers.
+func (h *Handler) redirectToFrontend(w http.ResponseWriter, r *http.Request, params url.Values) {
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, h.publicURL+"/#"+params.Encode(), http.StatusFound)
+}
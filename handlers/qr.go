@@ -0,0 +1,153 @@
+// handlers/qr.go
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"url-shortener/qr"
+	"url-shortener/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// QR code defaults; the image size is in pixels and the margin in modules.
+const (
+	defaultQRSize   = 256
+	defaultQRMargin = 4
+	maxQRMargin     = 16
+)
+
+// QRCodeHandler serves a QR code of a short link as PNG or SVG. The query
+// parameters are format (png or svg), size, margin, level (L, M, Q or H),
+// fg and bg (hex colours) and logo. Rendered images are cached.
+func (h *Handler) QRCodeHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+	opts, ok := h.qrOptions(w, r)
+	if !ok {
+		return
+	}
+
+	urlMapping, err := h.links.GetURLMappingByShortCode(shortCode)
+	switch {
+	case err == nil:
+		if urlMapping.Expired(time.Now()) {
+			http.Error(w, "Short URL has expired", http.StatusGone)
+			return
+		}
+	case errors.Is(err, storage.ErrURLNotFound):
+		if _, err := h.guests.RetrieveOriginalURL(shortCode); err != nil {
+			http.Error(w, "Short URL not found", http.StatusNotFound)
+			return
+		}
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The code only depends on the short URL, so cached images stay valid
+	// when the link is edited
+	key := fmt.Sprintf("%s|%s|%d|%d|%s|%v|%v|%t", shortCode, opts.Format, opts.Size, opts.Margin, opts.Level,
+		opts.Foreground, opts.Background, opts.Logo != nil)
+	data, found, err := h.qrCodes.Get(key)
+	if err != nil {
+		log.Printf("Error reading QR code cache: %v", err)
+	}
+	if !found {
+		data, err = qr.Render(h.publicURL+"/"+shortCode, opts)
+		switch {
+		case errors.Is(err, qr.ErrSizeTooSmall), errors.Is(err, qr.ErrLogoLevel):
+			writeError(w, http.StatusBadRequest, "invalid_qr_option", err.Error())
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := h.qrCodes.Set(key, data, h.qrConfig.CacheTTL); err != nil {
+			log.Printf("Error caching QR code: %v", err)
+		}
+	}
+
+	sum := sha256.Sum256(data)
+	w.Header().Set("Content-Type", qr.ContentType(opts.Format))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// qrOptions parses the query parameters of QRCodeHandler, or writes a 400
+// and returns false.
+func (h *Handler) qrOptions(w http.ResponseWriter, r *http.Request) (qr.Options, bool) {
+	query := r.URL.Query()
+	invalid := func(msg string) (qr.Options, bool) {
+		writeError(w, http.StatusBadRequest, "invalid_qr_option", msg)
+		return qr.Options{}, false
+	}
+
+	opts := qr.Options{Format: qr.FormatPNG, Size: defaultQRSize, Margin: defaultQRMargin, Level: "M"}
+	if v := strings.ToLower(query.Get("format")); v != "" {
+		if v != qr.FormatPNG && v != qr.FormatSVG {
+			return invalid("format must be png or svg")
+		}
+		opts.Format = v
+	}
+	if v := query.Get("size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 1 || size > h.qrConfig.MaxSize {
+			return invalid(fmt.Sprintf("size must be between 1 and %d", h.qrConfig.MaxSize))
+		}
+		opts.Size = size
+	}
+	if v := query.Get("margin"); v != "" {
+		margin, err := strconv.Atoi(v)
+		if err != nil || margin < 0 || margin > maxQRMargin {
+			return invalid(fmt.Sprintf("margin must be between 0 and %d", maxQRMargin))
+		}
+		opts.Margin = margin
+	}
+
+	var err error
+	if opts.Foreground, err = qr.ParseColor(queryDefault(query.Get("fg"), "000")); err != nil {
+		return invalid(err.Error())
+	}
+	if opts.Background, err = qr.ParseColor(queryDefault(query.Get("bg"), "fff")); err != nil {
+		return invalid(err.Error())
+	}
+
+	if v := query.Get("logo"); v != "" {
+		logo, err := strconv.ParseBool(v)
+		if err != nil {
+			return invalid("logo must be true or false")
+		}
+		if logo {
+			if h.qrLogo == nil {
+				writeError(w, http.StatusBadRequest, "qr_logo_unavailable", "no QR code logo is configured")
+				return qr.Options{}, false
+			}
+			// The logo hides modules, which need the most error correction
+			opts.Logo, opts.Level = h.qrLogo, "H"
+		}
+	}
+	if v := strings.ToUpper(query.Get("level")); v != "" {
+		if v != "L" && v != "M" && v != "Q" && v != "H" {
+			return invalid(qr.ErrInvalidLevel.Error())
+		}
+		opts.Level = v
+	}
+	return opts, true
+}
+
+// queryDefault returns v, or def if v is empty.
+func queryDefault(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
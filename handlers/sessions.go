@@ -0,0 +1,167 @@
+// handlers/sessions.go
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	"url-shortener/auth"
+	"url-shortener/models"
+	"url-shortener/storage"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// tokenResponse is returned by signup, login and refresh. Token is a
+// short-lived access token; RefreshToken can be exchanged once for a new pair.
+type tokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int    `json:"expiresIn"` // seconds until Token expires
+}
+
+// refreshRequest is the payload accepted by RefreshTokenHandler and
+// LogoutHandler.
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// startSession creates a session for user and writes its first token pair.
+func (h *Handler) startSession(w http.ResponseWriter, user models.User, status int) {
+	session := models.Session{ID: randomToken(16), UserID: user.ID, CreatedAt: time.Now()}
+	if err := h.sessions.CreateSession(session); err != nil {
+		log.Printf("Error creating session: %v", err)
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+	h.writeTokens(w, user, session.ID, status)
+}
+
+// writeTokens issues a new access token and refresh token for the session.
+func (h *Handler) writeTokens(w http.ResponseWriter, user models.User, sessionID string, status int) {
+	now := time.Now()
+	claims := &auth.Claims{
+		Email:     user.Email,
+		UserID:    user.ID,
+		SessionID: sessionID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        randomToken(16),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(h.tokenTTL).Unix(),
+		},
+	}
+	accessToken, err := h.keys.Sign(claims)
+	if err != nil {
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+
+	refreshToken := randomToken(32)
+	err = h.sessions.SaveRefreshToken(models.RefreshToken{
+		Hash:      auth.HashToken(refreshToken),
+		SessionID: sessionID,
+		ExpiresAt: now.Add(h.refreshTTL),
+	})
+	if err != nil {
+		log.Printf("Error saving refresh token: %v", err)
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(tokenResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(h.tokenTTL / time.Second),
+	})
+}
+
+// RefreshTokenHandler exchanges a refresh token for a new access token and
+// refresh token. Each refresh token works once; presenting a used one means
+// it was stolen, so the whole session is revoked.
+func (h *Handler) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "refreshToken is required")
+		return
+	}
+
+	now := time.Now()
+	token, err := h.sessions.UseRefreshToken(auth.HashToken(req.RefreshToken), now)
+	switch {
+	case errors.Is(err, storage.ErrRefreshTokenReused):
+		if err := h.sessions.RevokeSession(token.SessionID, now); err != nil {
+			log.Printf("Error revoking session %s: %v", token.SessionID, err)
+		}
+		writeError(w, http.StatusUnauthorized, "invalid_grant", "refresh token was already used; the session has been revoked")
+		return
+	case errors.Is(err, storage.ErrRefreshTokenNotFound):
+		writeError(w, http.StatusUnauthorized, "invalid_grant", "unknown refresh token")
+		return
+	case err != nil:
+		log.Printf("Error using refresh token: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !now.Before(token.ExpiresAt) {
+		writeError(w, http.StatusUnauthorized, "invalid_grant", "refresh token has expired")
+		return
+	}
+
+	session, err := h.sessions.GetSession(token.SessionID)
+	if err != nil || session.RevokedAt != nil {
+		writeError(w, http.StatusUnauthorized, "invalid_grant", "session has been revoked")
+		return
+	}
+	user, err := h.users.GetUserByID(session.UserID)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "invalid_grant", "session has been revoked")
+		return
+	}
+
+	h.writeTokens(w, user, session.ID, http.StatusOK)
+}
+
+// LogoutHandler revokes a session, identified by the bearer access token or
+// by a refresh token in the body, along with all of its tokens.
+func (h *Handler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	var sessionID string
+	if principal, ok := auth.FromContext(r.Context()); ok && principal.SessionID != "" {
+		sessionID = principal.SessionID
+	} else {
+		var req refreshRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "an access token or refresh token is required")
+			return
+		}
+		// Using the token up is harmless since the session is revoked anyway.
+		token, err := h.sessions.UseRefreshToken(auth.HashToken(req.RefreshToken), time.Now())
+		if err != nil && !errors.Is(err, storage.ErrRefreshTokenReused) {
+			writeError(w, http.StatusUnauthorized, "invalid_grant", "unknown refresh token")
+			return
+		}
+		sessionID = token.SessionID
+	}
+
+	if err := h.sessions.RevokeSession(sessionID, time.Now()); err != nil && !errors.Is(err, storage.ErrSessionNotFound) {
+		log.Printf("Error revoking session %s: %v", sessionID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// randomToken returns n random bytes, base64url encoded.
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
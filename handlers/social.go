@@ -0,0 +1,108 @@
+// handlers/social.go
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"url-shortener/models"
+	"url-shortener/utils"
+)
+
+// socialCardPage is served to link unfurlers instead of the redirect, so
+// chat apps and social networks can show a preview even when the
+// destination blocks their crawler.
+var socialCardPage = template.Must(template.New("card").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<meta property="og:type" content="website">
+<meta property="og:url" content="{{.URL}}">
+<meta property="og:title" content="{{.Title}}">
+{{- with .Description}}
+<meta property="og:description" content="{{.}}">
+<meta name="description" content="{{.}}">
+{{- end}}
+{{- with .Image}}
+<meta property="og:image" content="{{.}}">
+<meta name="twitter:card" content="summary_large_image">
+{{- else}}
+<meta name="twitter:card" content="summary">
+{{- end}}
+</head>
+<body>
+<p><a href="{{.Destination}}">{{.Destination}}</a></p>
+</body>
+</html>
+`))
+
+// socialCard holds the values rendered by socialCardPage.
+type socialCard struct {
+	URL         string
+	Title       string
+	Description string
+	Image       string
+	Destination string
+}
+
+// newSocialCard picks the card of urlMapping: the owner's Open Graph fields,
+// falling back to the link's title and description, then to what was
+// fetched from the destination.
+func (h *Handler) newSocialCard(urlMapping models.URLMapping) socialCard {
+	var preview models.LinkPreview
+	if urlMapping.Preview != nil {
+		preview = *urlMapping.Preview
+	}
+	card := socialCard{
+		URL:         h.publicURL + "/" + urlMapping.ShortCode,
+		Title:       firstNonEmpty(urlMapping.OGTitle, urlMapping.Title, preview.OGTitle, preview.Title),
+		Description: firstNonEmpty(urlMapping.OGDescription, urlMapping.Description, preview.OGDescription),
+		Image:       firstNonEmpty(urlMapping.OGImage, preview.OGImage),
+		Destination: urlMapping.OriginalURL,
+	}
+	if card.Title == "" {
+		card.Title = card.URL
+	}
+	return card
+}
+
+// serveSocialCard writes the social card page of urlMapping.
+func (h *Handler) serveSocialCard(w http.ResponseWriter, urlMapping models.URLMapping) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := socialCardPage.Execute(w, h.newSocialCard(urlMapping)); err != nil {
+		log.Printf("Error rendering social card of %s: %v", urlMapping.ShortCode, err)
+	}
+}
+
+// validateSocialCard trims urlMapping's Open Graph fields and checks them.
+func validateSocialCard(urlMapping *models.URLMapping) error {
+	urlMapping.OGTitle = strings.TrimSpace(urlMapping.OGTitle)
+	urlMapping.OGDescription = strings.TrimSpace(urlMapping.OGDescription)
+	urlMapping.OGImage = strings.TrimSpace(urlMapping.OGImage)
+	if len(urlMapping.OGTitle) > utils.MaxTitleLength {
+		return badRequest("invalid_og_title", fmt.Sprintf("ogTitle must be at most %d characters", utils.MaxTitleLength))
+	}
+	if len(urlMapping.OGDescription) > utils.MaxDescriptionLength {
+		return badRequest("invalid_og_description", fmt.Sprintf("ogDescription must be at most %d characters", utils.MaxDescriptionLength))
+	}
+	if urlMapping.OGImage != "" {
+		image, err := utils.SanitizeURL(urlMapping.OGImage)
+		if err != nil {
+			return badRequest("invalid_og_image", "ogImage must be an http or https URL")
+		}
+		urlMapping.OGImage = image
+	}
+	return nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,18 @@
+// handlers/types_test.go
+package handlers
+
+// Request and response types of the handlers, exported for handlers_test.go,
+// which is in package handlers_test so that it can build the API router.
+type (
+	AccountTokenRequest     = accountTokenRequest
+	AnalyticsResponse       = analyticsResponse
+	APIError                = apiError
+	BulkResponse            = bulkResponse
+	ChangePasswordRequest   = changePasswordRequest
+	CreateInvitationRequest = createInvitationRequest
+	CreateWorkspaceRequest  = createWorkspaceRequest
+	RefreshRequest          = refreshRequest
+	ResetPasswordRequest    = resetPasswordRequest
+	TokenResponse           = tokenResponse
+	UpdateMemberRequest     = updateMemberRequest
+)
@@ -0,0 +1,239 @@
+// handlers/unlock.go
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"url-shortener/models"
+	"url-shortener/storage"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// unlockCookieName holds the token unlocking a password-protected link.
+	// Each is scoped to the path of its link.
+	unlockCookieName = "link_unlock"
+	// unlockAudience tells unlock tokens apart from the access tokens signed
+	// by the same keys.
+	unlockAudience = "link-unlock"
+	// maxLinkPasswordLength is the most bcrypt hashes.
+	maxLinkPasswordLength = 72
+)
+
+var unlockPage = template.Must(template.New("unlock").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+<link rel="stylesheet" href="https://stackpath.bootstrapcdn.com/bootstrap/4.5.2/css/bootstrap.min.css">
+</head>
+<body class="bg-light">
+<main class="container py-5" style="max-width: 28rem">
+<h1 class="h4 mb-3">This link is password protected</h1>
+{{- with .Error}}
+<div class="alert alert-danger" role="alert">{{.}}</div>
+{{- end}}
+<form method="POST">
+<input type="password" name="password" class="form-control mb-3" placeholder="Password" aria-label="Password" required autofocus>
+<button type="submit" class="btn btn-primary w-100">Continue</button>
+</form>
+</main>
+</body>
+</html>
+`))
+
+// unlockClaims are the claims of an unlock token. The subject is the short
+// code of the link.
+type unlockClaims struct {
+	// PasswordTag identifies the password the link was unlocked with, so
+	// changing the password locks it again.
+	PasswordTag string `json:"pwd"`
+	jwt.StandardClaims
+}
+
+func passwordTag(passwordHash string) string {
+	sum := sha256.Sum256([]byte(passwordHash))
+	return hex.EncodeToString(sum[:8])
+}
+
+// unlocked reports whether r carries a valid unlock token for urlMapping.
+func (h *Handler) unlocked(r *http.Request, urlMapping models.URLMapping) bool {
+	cookie, err := r.Cookie(unlockCookieName)
+	if err != nil {
+		return false
+	}
+	var claims unlockClaims
+	if err := h.keys.Parse(cookie.Value, &claims); err != nil {
+		return false
+	}
+	return claims.Audience == unlockAudience && claims.Subject == urlMapping.ShortCode &&
+		claims.PasswordTag == passwordTag(urlMapping.PasswordHash)
+}
+
+// serveUnlockForm writes the password form of a protected link.
+func serveUnlockForm(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := unlockPage.Execute(w, struct{ Error string }{message}); err != nil {
+		log.Printf("Error rendering unlock form: %v", err)
+	}
+}
+
+// UnlockURLHandler checks the password posted from the unlock form of a
+// protected link. On success it sets a cookie unlocking the link for
+// unlockTTL and sends the browser back to the link; wrong passwords are
+// rate limited per client and link.
+func (h *Handler) UnlockURLHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+	urlMapping, err := h.links.GetURLMappingByShortCode(shortCode)
+	if errors.Is(err, storage.ErrURLNotFound) {
+		http.Error(w, "Short URL not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	if urlMapping.Expired(now) {
+		http.Error(w, "Short URL has expired", http.StatusGone)
+		return
+	}
+	if !urlMapping.Protected {
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return
+	}
+
+	key := h.trustedProxies.ClientIP(r) + " " + shortCode
+	if wait := h.unlockAttempts.retryAfter(key, now); wait > 0 {
+		setRetryAfter(w, wait)
+		serveUnlockForm(w, http.StatusTooManyRequests, "Too many wrong passwords. Try again later.")
+		return
+	}
+	password := r.PostFormValue("password")
+	if bcrypt.CompareHashAndPassword([]byte(urlMapping.PasswordHash), []byte(password)) != nil {
+		h.unlockAttempts.fail(key, now)
+		serveUnlockForm(w, http.StatusUnauthorized, "Wrong password.")
+		return
+	}
+	h.unlockAttempts.reset(key)
+
+	token, err := h.keys.Sign(&unlockClaims{
+		PasswordTag: passwordTag(urlMapping.PasswordHash),
+		StandardClaims: jwt.StandardClaims{
+			Audience:  unlockAudience,
+			Subject:   shortCode,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(h.unlockTTL).Unix(),
+		},
+	})
+	if err != nil {
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     unlockCookieName,
+		Value:    token,
+		Path:     "/" + shortCode,
+		MaxAge:   int(h.unlockTTL / time.Second),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.publicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+}
+
+// hashLinkPassword checks and hashes a link password.
+func hashLinkPassword(password string) (string, error) {
+	if len(password) > maxLinkPasswordLength {
+		return "", badRequest("invalid_password", "password must be at most 72 bytes")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", errors.New("Failed to hash password")
+	}
+	return string(hash), nil
+}
+
+// setRetryAfter tells the client to wait before trying again.
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second)/time.Second)))
+}
+
+// attemptLimiter counts failed attempts per key, allowing max in a window
+// starting at the first failure.
+type attemptLimiter struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	failures map[string]*attemptWindow
+}
+
+type attemptWindow struct {
+	count int
+	start time.Time
+}
+
+// maxTrackedKeys is how many keys an attemptLimiter holds before dropping
+// those whose window ended.
+const maxTrackedKeys = 10000
+
+func newAttemptLimiter(max int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{max: max, window: window, failures: make(map[string]*attemptWindow)}
+}
+
+// retryAfter returns how long key must wait before its next attempt, or 0
+// if it may try now.
+func (l *attemptLimiter) retryAfter(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.failures[key]
+	if !ok || f.count < l.max {
+		return 0
+	}
+	if end := f.start.Add(l.window); now.Before(end) {
+		return end.Sub(now)
+	}
+	return 0
+}
+
+// fail records a failed attempt by key.
+func (l *attemptLimiter) fail(key string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.failures[key]
+	if !ok || !now.Before(f.start.Add(l.window)) {
+		if len(l.failures) >= maxTrackedKeys {
+			for k, f := range l.failures {
+				if !now.Before(f.start.Add(l.window)) {
+					delete(l.failures, k)
+				}
+			}
+		}
+		f = &attemptWindow{start: now}
+		l.failures[key] = f
+	}
+	f.count++
+}
+
+// reset forgets key's failures.
+func (l *attemptLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+}
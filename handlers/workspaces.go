@@ -0,0 +1,434 @@
+// handlers/workspaces.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"url-shortener/auth"
+	"url-shortener/mailer"
+	"url-shortener/models"
+	"url-shortener/storage"
+	"url-shortener/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// maxWorkspaceNameLength matches the workspaces.name column.
+const maxWorkspaceNameLength = 100
+
+// createWorkspaceRequest is the payload accepted by CreateWorkspaceHandler.
+type createWorkspaceRequest struct {
+	Name string `json:"name"`
+}
+
+// updateMemberRequest is the payload accepted by UpdateMemberHandler.
+type updateMemberRequest struct {
+	Role string `json:"role"`
+}
+
+// createInvitationRequest is the payload accepted by CreateInvitationHandler.
+type createInvitationRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// CreateWorkspaceHandler creates a workspace owned by the caller.
+func (h *Handler) CreateWorkspaceHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req createWorkspaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > maxWorkspaceNameLength {
+		writeError(w, http.StatusBadRequest, "invalid_name", fmt.Sprintf("name must be between 1 and %d characters", maxWorkspaceNameLength))
+		return
+	}
+
+	workspace, err := h.workspaces.CreateWorkspace(models.Workspace{Name: name, CreatedAt: time.Now()}, user.ID)
+	if err != nil {
+		log.Printf("Error creating workspace: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(workspace)
+}
+
+// ListWorkspacesHandler lists the workspaces the caller belongs to, with
+// their role in each.
+func (h *Handler) ListWorkspacesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	workspaces, err := h.workspaces.ListUserWorkspaces(user.ID)
+	if err != nil {
+		log.Printf("Error listing workspaces: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workspaces)
+}
+
+// GetWorkspaceURLsHandler lists a page of a workspace's links to its
+// members, with the query parameters of GetUserURLsHandler.
+func (h *Handler) GetWorkspaceURLsHandler(w http.ResponseWriter, r *http.Request) {
+	member, ok := h.workspaceMember(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+	h.listURLMappings(w, r, storage.URLQuery{WorkspaceID: member.WorkspaceID})
+}
+
+// ListMembersHandler lists a workspace's members to its members.
+func (h *Handler) ListMembersHandler(w http.ResponseWriter, r *http.Request) {
+	member, ok := h.workspaceMember(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	members, err := h.workspaces.ListMembers(member.WorkspaceID)
+	if err != nil {
+		log.Printf("Error listing workspace members: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// UpdateMemberHandler changes a member's role. Admins manage admins,
+// editors and viewers; only owners can make or demote owners.
+func (h *Handler) UpdateMemberHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := h.workspaceMember(w, r, models.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	var req updateMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !models.ValidRole(req.Role) {
+		writeError(w, http.StatusBadRequest, "invalid_role", "role must be owner, admin, editor or viewer")
+		return
+	}
+	target, ok := h.targetMember(w, r, caller.WorkspaceID)
+	if !ok {
+		return
+	}
+	if (target.Role == models.RoleOwner || req.Role == models.RoleOwner) && caller.Role != models.RoleOwner {
+		writeError(w, http.StatusForbidden, "insufficient_role", "only owners can manage owners")
+		return
+	}
+
+	err := h.workspaces.UpdateMemberRole(caller.WorkspaceID, target.UserID, req.Role)
+	if !writeMemberError(w, err) {
+		return
+	}
+	target.Role = req.Role
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(target)
+}
+
+// RemoveMemberHandler removes a member from a workspace. Members can always
+// remove themselves; removing others follows the rules of
+// UpdateMemberHandler.
+func (h *Handler) RemoveMemberHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := h.workspaceMember(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+	target, ok := h.targetMember(w, r, caller.WorkspaceID)
+	if !ok {
+		return
+	}
+	if target.UserID != caller.UserID {
+		if !models.RoleAllows(caller.Role, models.RoleAdmin) {
+			writeInsufficientRole(w, models.RoleAdmin)
+			return
+		}
+		if target.Role == models.RoleOwner && caller.Role != models.RoleOwner {
+			writeError(w, http.StatusForbidden, "insufficient_role", "only owners can manage owners")
+			return
+		}
+	}
+
+	err := h.workspaces.RemoveMember(caller.WorkspaceID, target.UserID)
+	if !writeMemberError(w, err) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateInvitationHandler emails an invitation to join the workspace with
+// the given role.
+func (h *Handler) CreateInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := h.workspaceMember(w, r, models.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	var req createInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := utils.ValidateEmail(req.Email); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_email", err.Error())
+		return
+	}
+	if !models.ValidRole(req.Role) {
+		writeError(w, http.StatusBadRequest, "invalid_role", "role must be owner, admin, editor or viewer")
+		return
+	}
+	if req.Role == models.RoleOwner && caller.Role != models.RoleOwner {
+		writeError(w, http.StatusForbidden, "insufficient_role", "only owners can manage owners")
+		return
+	}
+	if invitee, err := h.users.GetUserByEmail(req.Email); err == nil {
+		if _, err := h.workspaces.GetMember(caller.WorkspaceID, invitee.ID); err == nil {
+			writeError(w, http.StatusConflict, "already_member", req.Email+" is already a member of the workspace")
+			return
+		}
+	}
+
+	workspace, err := h.workspaces.GetWorkspace(caller.WorkspaceID)
+	if err != nil {
+		log.Printf("Error retrieving workspace: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	secret := randomToken(32)
+	invitation, err := h.workspaces.CreateInvitation(models.WorkspaceInvitation{
+		WorkspaceID: caller.WorkspaceID,
+		Email:       req.Email,
+		Role:        req.Role,
+		Hash:        auth.HashToken(secret),
+		InvitedBy:   caller.UserID,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(h.inviteTTL),
+	})
+	if err != nil {
+		log.Printf("Error creating invitation: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	link := h.publicURL + "/?" + url.Values{"invite_token": {secret}}.Encode()
+	err = h.mailer.Send(r.Context(), mailer.Message{
+		To:      req.Email,
+		Subject: "You're invited to join " + workspace.Name,
+		Body: fmt.Sprintf("%s invited you to join the workspace %q as %s.\n\nAccept the invitation by opening this link:\n\n%s\n\n"+
+			"The link expires in %s. If you don't have an account yet, sign up with this email address first.\n",
+			caller.Email, workspace.Name, req.Role, link, formatTTL(h.inviteTTL)),
+	})
+	if err != nil {
+		log.Printf("Error sending invitation email: %v", err)
+		if err := h.workspaces.DeleteInvitation(caller.WorkspaceID, invitation.ID); err != nil {
+			log.Printf("Error deleting invitation: %v", err)
+		}
+		http.Error(w, "Failed to send email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invitation)
+}
+
+// ListInvitationsHandler lists a workspace's pending invitations.
+func (h *Handler) ListInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := h.workspaceMember(w, r, models.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	invitations, err := h.workspaces.ListInvitations(caller.WorkspaceID)
+	if err != nil {
+		log.Printf("Error listing invitations: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// RevokeInvitationHandler withdraws a pending invitation.
+func (h *Handler) RevokeInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := h.workspaceMember(w, r, models.RoleAdmin)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["invitationId"])
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.workspaces.DeleteInvitation(caller.WorkspaceID, id)
+	if errors.Is(err, storage.ErrInvitationNotFound) {
+		writeError(w, http.StatusNotFound, "invitation_not_found", err.Error())
+		return
+	} else if err != nil {
+		log.Printf("Error deleting invitation: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptInvitationHandler adds the caller to a workspace with the token
+// from an invitation email. The invitation must have been sent to the
+// caller's email address.
+func (h *Handler) AcceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req accountTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	now := time.Now()
+	invitation, err := h.workspaces.GetInvitationByHash(auth.HashToken(req.Token))
+	if err == nil && !strings.EqualFold(invitation.Email, user.Email) {
+		writeError(w, http.StatusForbidden, "invitation_email_mismatch", "this invitation was sent to another email address")
+		return
+	}
+	if err == nil {
+		err = h.workspaces.AcceptInvitation(invitation.ID, user.ID, now)
+	}
+	if errors.Is(err, storage.ErrInvitationNotFound) || errors.Is(err, storage.ErrInvitationInvalid) {
+		writeError(w, http.StatusBadRequest, "invalid_token", storage.ErrInvitationInvalid.Error())
+		return
+	} else if err != nil {
+		log.Printf("Error accepting invitation: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	workspace, err := h.workspaces.GetWorkspace(invitation.WorkspaceID)
+	if err == nil {
+		var member models.WorkspaceMember
+		member, err = h.workspaces.GetMember(invitation.WorkspaceID, user.ID)
+		workspace.Role = member.Role
+	}
+	if err != nil {
+		log.Printf("Error retrieving workspace: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workspace)
+}
+
+// workspaceMember returns the caller's membership of the workspace named by
+// the id route variable, checking that it grants at least role.
+func (h *Handler) workspaceMember(w http.ResponseWriter, r *http.Request, role string) (models.WorkspaceMember, bool) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return models.WorkspaceMember{}, false
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, "workspace_not_found", storage.ErrWorkspaceNotFound.Error())
+		return models.WorkspaceMember{}, false
+	}
+	return h.requireWorkspaceRole(w, user, id, role)
+}
+
+// requireWorkspaceRole returns user's membership of a workspace if it grants
+// at least role. Non-members get a 404, so they can't tell which workspaces
+// exist, and members with a lower role a 403.
+func (h *Handler) requireWorkspaceRole(w http.ResponseWriter, user models.User, workspaceID int, role string) (models.WorkspaceMember, bool) {
+	member, err := h.workspaceRole(user, workspaceID, role)
+	if err != nil {
+		writeRequestError(w, err)
+		return member, false
+	}
+	return member, true
+}
+
+// workspaceRole returns user's membership of a workspace, checking that it
+// grants at least role.
+func (h *Handler) workspaceRole(user models.User, workspaceID int, role string) (models.WorkspaceMember, error) {
+	member, err := h.workspaces.GetMember(workspaceID, user.ID)
+	if errors.Is(err, storage.ErrNotMember) {
+		return member, &requestError{status: http.StatusNotFound, code: "workspace_not_found", message: storage.ErrWorkspaceNotFound.Error()}
+	} else if err != nil {
+		log.Printf("Error retrieving workspace member: %v", err)
+		return member, &requestError{status: http.StatusInternalServerError, message: "Internal Server Error"}
+	}
+	if !models.RoleAllows(member.Role, role) {
+		return member, errInsufficientRole(role)
+	}
+	return member, nil
+}
+
+// targetMember returns the member named by the userId route variable.
+func (h *Handler) targetMember(w http.ResponseWriter, r *http.Request, workspaceID int) (models.WorkspaceMember, bool) {
+	userID, err := strconv.Atoi(mux.Vars(r)["userId"])
+	var member models.WorkspaceMember
+	if err == nil {
+		member, err = h.workspaces.GetMember(workspaceID, userID)
+	}
+	if err != nil {
+		writeMemberError(w, err)
+		return member, false
+	}
+	return member, true
+}
+
+// writeMemberError maps a WorkspaceStore member error to a response. It
+// returns true, writing nothing, if err is nil.
+func writeMemberError(w http.ResponseWriter, err error) bool {
+	var numErr *strconv.NumError
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, storage.ErrNotMember), errors.As(err, &numErr):
+		writeError(w, http.StatusNotFound, "member_not_found", "member not found")
+	case errors.Is(err, storage.ErrLastOwner):
+		writeError(w, http.StatusConflict, "last_owner", err.Error())
+	default:
+		log.Printf("Error updating workspace member: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+	return false
+}
+
+func writeInsufficientRole(w http.ResponseWriter, role string) {
+	writeRequestError(w, errInsufficientRole(role))
+}
+
+func errInsufficientRole(role string) error {
+	return &requestError{status: http.StatusForbidden, code: "insufficient_role", message: "this requires the " + role + " role or higher in the workspace"}
+}
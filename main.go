@@ -1,48 +1,182 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"url-shortener/analytics"
 	"url-shortener/api"
+	"url-shortener/auth"
+	"url-shortener/config"
+	"url-shortener/handlers"
+	"url-shortener/mailer"
+	"url-shortener/oidc"
+	"url-shortener/preview"
+	"url-shortener/qr"
 	"url-shortener/storage"
+	"url-shortener/utils"
+
 	"github.com/rs/cors"
 )
 
 func main() {
 
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
-	dbSSLMode := os.Getenv("DB_SSLMODE")
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if len(cfg.Auth.Keys()) == 0 {
+		log.Println("No JWT signing key configured; using a random key, tokens will not survive a restart")
+	}
+	signingKeys, err := auth.LoadKeySet(cfg.Auth)
+	if err != nil {
+		log.Fatalf("Error loading JWT signing keys: %v", err)
+	}
+
+	db := storage.InitDB(cfg.Database)
+	pgStore := storage.NewPostgresStore(db)
+	redisClient := storage.NewRedisClient(cfg.Redis)
+
+	// Purge expired links in the background
+	reaper := &storage.Reaper{
+		Links:    pgStore,
+		Interval: cfg.Workers.ReaperInterval,
+		Archive:  cfg.Workers.ReaperArchive,
+	}
+	go reaper.Run(context.Background())
+	go (&storage.JobReaper{Jobs: pgStore}).Run(context.Background())
+
+	codes, err := newCodeGenerator(cfg.Links, redisClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Log clicks from a background goroutine so redirects don't wait on Postgres
+	clickRecorder := analytics.NewAsyncRecorder(pgStore, cfg.Workers.ClickQueueSize)
+	go clickRecorder.Run(context.Background())
+
+	// Buffer registered links' visits in Redis and write them out in batches
+	flusher := &storage.VisitFlusher{Buffer: redisClient, Links: pgStore, Interval: cfg.Workers.VisitFlushInterval}
+	go flusher.Run(context.Background())
+
+	links, err := newLinkCache(cfg.Cache, pgStore, redisClient)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	dbConnString := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s", dbUser, dbPassword, dbHost, dbPort, dbName, dbSSLMode)
-	storage.InitDB(dbConnString)
+	users := newUserCache(cfg.Cache, pgStore, redisClient)
 
-	router := api.NewRouter()
+	stores := storage.Stores{Users: users, Links: links, Guests: redisClient, Clicks: pgStore, Visits: redisClient, Sessions: pgStore, APIKeys: pgStore, Accounts: pgStore, Identities: pgStore, Workspaces: pgStore, Jobs: pgStore}
+	mail, err := mailer.New(cfg.Mail)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	handlerOpts := []handlers.Option{
+		handlers.WithCodeGenerator(codes),
+		handlers.WithClickRecorder(clickRecorder),
+		handlers.WithSigningKeys(signingKeys),
+		handlers.WithMailer(mail),
+	}
+	if cfg.Auth.OIDC.Enabled() {
+		oidcCfg := cfg.Auth.OIDC
+		if oidcCfg.RedirectURL == "" {
+			oidcCfg.RedirectURL = strings.TrimSuffix(cfg.Server.PublicURL, "/") + "/auth/oidc/callback"
+		}
+		provider, err := oidc.Discover(context.Background(), oidcCfg)
+		if err != nil {
+			log.Fatalf("Error setting up single sign-on: %v", err)
+		}
+		handlerOpts = append(handlerOpts, handlers.WithOIDC(provider))
+	}
+	if cfg.Previews.Enabled {
+		// Fetch destination titles and Open Graph metadata in the background
+		previews := preview.NewWorker(preview.NewFetcher(cfg.Previews), links, cfg.Previews.QueueSize)
+		go previews.Run(context.Background(), cfg.Previews.Workers)
+		handlerOpts = append(handlerOpts, handlers.WithPreviewQueue(previews))
+	}
+
+	if cfg.QR.LogoFile != "" {
+		logo, err := qr.LoadLogo(cfg.QR.LogoFile)
+		if err != nil {
+			log.Fatalf("Error loading QR code logo: %v", err)
+		}
+		handlerOpts = append(handlerOpts, handlers.WithQRLogo(logo))
+	}
+
+	router := api.NewRouter(cfg, stores, handlerOpts...)
 
 	// Set up CORS options
 	corsHandler := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"}, // Allows all origins
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedOrigins:   cfg.CORS.AllowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization"},
 		AllowCredentials: true,
-		Debug:            true,
+		Debug:            cfg.CORS.Debug,
 	})
 
 	// Serve static files
-	fs := http.FileServer(http.Dir("./frontend"))
+	fs := http.FileServer(http.Dir(cfg.Server.StaticDir))
 	// Serve the static files under the root path, but not interfere with API paths
 	router.PathPrefix("/").Handler(http.StripPrefix("/", fs))
 
 	// Apply the CORS middleware to the router
 	handler := corsHandler.Handler(router)
 
-	log.Println("Starting server on :8080")
-	if err := http.ListenAndServe(":8080", handler); err != nil {
+	log.Printf("Starting server on %s", cfg.Server.Addr)
+	if err := http.ListenAndServe(cfg.Server.Addr, handler); err != nil {
 		log.Fatal("ListenAndServe: ", err)
 	}
 }
+
+// newLinkCache puts the configured cache backend in front of links for
+// redirect lookups: "memory" for a per-instance LRU, "redis" for a cache
+// shared between instances, or "none".
+func newLinkCache(cfg config.CacheConfig, links storage.LinkStore, redisClient *storage.RedisClient) (storage.LinkStore, error) {
+	switch cfg.Backend {
+	case "memory":
+		return storage.NewCachedLinkStore(links, storage.NewLRUCache(cfg.Size), cfg.TTL, cfg.NegativeTTL), nil
+	case "redis":
+		return storage.NewCachedLinkStore(links, storage.NewRedisCache(redisClient, "cache:link:"), cfg.TTL, cfg.NegativeTTL), nil
+	case "none":
+		return links, nil
+	default:
+		return nil, fmt.Errorf("unknown link cache backend %q", cfg.Backend)
+	}
+}
+
+// newUserCache puts the configured cache backend in front of the user
+// lookups made by the auth middleware, unless cfg.UserTTL is 0.
+func newUserCache(cfg config.CacheConfig, users storage.UserStore, redisClient *storage.RedisClient) storage.UserStore {
+	if cfg.UserTTL <= 0 {
+		return users
+	}
+	switch cfg.Backend {
+	case "memory":
+		return storage.NewCachedUserStore(users, storage.NewLRUCache(cfg.Size), cfg.UserTTL)
+	case "redis":
+		return storage.NewCachedUserStore(users, storage.NewRedisCache(redisClient, "cache:user:"), cfg.UserTTL)
+	default:
+		return users
+	}
+}
+
+// newCodeGenerator selects the configured short code strategy.
+// Sequence-based strategies draw their IDs from Redis.
+func newCodeGenerator(cfg config.LinksConfig, redisClient *storage.RedisClient) (utils.CodeGenerator, error) {
+	switch cfg.ShortCodeStrategy {
+	case "random":
+		return utils.RandomCodeGenerator{Length: cfg.ShortCodeLength}, nil
+	case "sequence":
+		return utils.SequenceCodeGenerator{Seq: redisClient}, nil
+	case "obfuscated":
+		return utils.NewObfuscatedCodeGenerator(redisClient, cfg.ShortCodeSalt, 6), nil
+	case "words":
+		return utils.WordCodeGenerator{Words: 3}, nil
+	default:
+		return nil, fmt.Errorf("unknown short code strategy %q", cfg.ShortCodeStrategy)
+	}
+}
@@ -0,0 +1,18 @@
+-- migrations/003_add_url_expiration.sql
+
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS max_visits INTEGER;
+
+CREATE INDEX IF NOT EXISTS urls_expires_at_idx ON urls (expires_at) WHERE expires_at IS NOT NULL;
+
+-- Expired links moved aside by the reaper when archiving is enabled.
+CREATE TABLE IF NOT EXISTS urls_archive (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    original_url TEXT NOT NULL,
+    shortened_url VARCHAR(255) NOT NULL,
+    visit_count INTEGER DEFAULT 0,
+    expires_at TIMESTAMPTZ,
+    max_visits INTEGER,
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
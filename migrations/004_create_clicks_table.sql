@@ -0,0 +1,18 @@
+-- migrations/004_create_clicks_table.sql
+
+-- Append-only log of redirects. short_code is not a foreign key because guest
+-- links live only in Redis.
+CREATE TABLE IF NOT EXISTS clicks (
+    id BIGSERIAL PRIMARY KEY,
+    short_code VARCHAR(255) NOT NULL,
+    clicked_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    referrer TEXT NOT NULL DEFAULT '',
+    referrer_host VARCHAR(255) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    browser VARCHAR(64) NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    accept_language VARCHAR(255) NOT NULL DEFAULT '',
+    country VARCHAR(8) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS clicks_short_code_clicked_at_idx ON clicks (short_code, clicked_at);
@@ -0,0 +1,11 @@
+-- migrations/005_create_url_revisions_table.sql
+
+-- Previous destinations of a link, recorded whenever original_url is edited.
+CREATE TABLE IF NOT EXISTS url_revisions (
+    id SERIAL PRIMARY KEY,
+    url_id INTEGER NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    original_url TEXT NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS url_revisions_url_id_idx ON url_revisions (url_id, changed_at);
@@ -0,0 +1,6 @@
+-- migrations/006_add_url_redirect_type.sql
+
+-- HTTP status used when redirecting; NULL means the server-wide default.
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS redirect_type SMALLINT
+    CHECK (redirect_type IN (301, 302, 307, 308));
+ALTER TABLE urls_archive ADD COLUMN IF NOT EXISTS redirect_type SMALLINT;
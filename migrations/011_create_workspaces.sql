@@ -0,0 +1,44 @@
+-- migrations/011_create_workspaces.sql
+
+-- Teams that share ownership of links.
+CREATE TABLE IF NOT EXISTS workspaces (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS workspace_members (
+    workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(16) NOT NULL CHECK (role IN ('owner', 'admin', 'editor', 'viewer')),
+    joined_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (workspace_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS workspace_members_user_id_idx ON workspace_members (user_id);
+
+-- Emailed invitations, stored as SHA-256 hashes of their tokens.
+CREATE TABLE IF NOT EXISTS workspace_invitations (
+    id SERIAL PRIMARY KEY,
+    workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(16) NOT NULL CHECK (role IN ('owner', 'admin', 'editor', 'viewer')),
+    token_hash TEXT NOT NULL UNIQUE,
+    invited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    accepted_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS workspace_invitations_workspace_id_idx ON workspace_invitations (workspace_id);
+
+-- A link with a workspace_id belongs to the workspace; user_id is then the
+-- member who created it. Each URL is shortened once per user for personal
+-- links and once per workspace for workspace links.
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE;
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_user_id_original_url_key;
+CREATE UNIQUE INDEX IF NOT EXISTS urls_user_original_url_key ON urls (user_id, original_url) WHERE workspace_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS urls_workspace_original_url_key ON urls (workspace_id, original_url) WHERE workspace_id IS NOT NULL;
+
+ALTER TABLE urls_archive ADD COLUMN IF NOT EXISTS workspace_id INTEGER;
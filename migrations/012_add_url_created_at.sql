@@ -0,0 +1,10 @@
+-- migrations/012_add_url_created_at.sql
+
+-- Links created before this migration are dated to when it ran.
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE urls_archive ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ;
+
+-- Keyset pagination of link listings, which order by created_at and break
+-- ties by short code.
+CREATE INDEX IF NOT EXISTS urls_user_created_at_idx ON urls (user_id, created_at, shortened_url) WHERE workspace_id IS NULL;
+CREATE INDEX IF NOT EXISTS urls_workspace_created_at_idx ON urls (workspace_id, created_at, shortened_url) WHERE workspace_id IS NOT NULL;
@@ -0,0 +1,44 @@
+-- migrations/013_add_url_metadata.sql
+
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS title VARCHAR(255);
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS description TEXT;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS last_visited_at TIMESTAMPTZ;
+
+-- Migration 012 dated existing links to when it ran; move them back to their
+-- first recorded click or edit where that is earlier.
+UPDATE urls u SET created_at = e.first_seen
+FROM (
+    SELECT u2.id, LEAST(
+        (SELECT MIN(c.clicked_at) FROM clicks c WHERE c.short_code = u2.shortened_url),
+        (SELECT MIN(r.changed_at) FROM url_revisions r WHERE r.url_id = u2.id)
+    ) AS first_seen
+    FROM urls u2
+) e
+WHERE e.id = u.id AND e.first_seen < u.created_at;
+
+UPDATE urls SET last_visited_at = c.last_click
+FROM (SELECT short_code, MAX(clicked_at) AS last_click FROM clicks GROUP BY short_code) c
+WHERE c.short_code = urls.shortened_url AND urls.last_visited_at IS NULL;
+
+UPDATE urls SET updated_at = GREATEST(created_at,
+    (SELECT MAX(r.changed_at) FROM url_revisions r WHERE r.url_id = urls.id))
+WHERE updated_at IS NULL;
+ALTER TABLE urls ALTER COLUMN updated_at SET DEFAULT NOW();
+ALTER TABLE urls ALTER COLUMN updated_at SET NOT NULL;
+
+-- Tags of a link, lowercased. Listings filter on tag within one owner's
+-- links, so the tag leads the index.
+CREATE TABLE IF NOT EXISTS url_tags (
+    url_id INTEGER NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    tag VARCHAR(32) NOT NULL,
+    PRIMARY KEY (url_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS url_tags_tag_idx ON url_tags (tag, url_id);
+
+ALTER TABLE urls_archive ADD COLUMN IF NOT EXISTS title VARCHAR(255);
+ALTER TABLE urls_archive ADD COLUMN IF NOT EXISTS description TEXT;
+ALTER TABLE urls_archive ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+ALTER TABLE urls_archive ADD COLUMN IF NOT EXISTS last_visited_at TIMESTAMPTZ;
+ALTER TABLE urls_archive ADD COLUMN IF NOT EXISTS tags TEXT[];
@@ -0,0 +1,6 @@
+-- migrations/014_add_url_preview.sql
+
+-- Title, Open Graph tags and favicon of the destination page, as the JSON
+-- encoding of models.LinkPreview. NULL until fetched.
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS preview JSONB;
+ALTER TABLE urls_archive ADD COLUMN IF NOT EXISTS preview JSONB;
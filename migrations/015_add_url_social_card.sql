@@ -0,0 +1,11 @@
+-- migrations/015_add_url_social_card.sql
+
+-- Open Graph tags set by the link's owner for the page served to social
+-- media crawlers. NULL falls back to the title, description and preview.
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS og_title VARCHAR(255);
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS og_description TEXT;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS og_image TEXT;
+
+ALTER TABLE urls_archive ADD COLUMN IF NOT EXISTS og_title VARCHAR(255);
+ALTER TABLE urls_archive ADD COLUMN IF NOT EXISTS og_description TEXT;
+ALTER TABLE urls_archive ADD COLUMN IF NOT EXISTS og_image TEXT;
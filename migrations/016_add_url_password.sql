@@ -0,0 +1,6 @@
+-- migrations/016_add_url_password.sql
+
+-- bcrypt hash of the password visitors must enter before being redirected;
+-- NULL for links without one.
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS password_hash VARCHAR(255);
+ALTER TABLE urls_archive ADD COLUMN IF NOT EXISTS password_hash VARCHAR(255);
@@ -0,0 +1,19 @@
+-- migrations/017_create_bulk_jobs_table.sql
+
+-- Bulk creates and deletes run in the background, with their per-row
+-- results once done.
+CREATE TABLE IF NOT EXISTS bulk_jobs (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(16) NOT NULL CHECK (kind IN ('create', 'delete')),
+    status VARCHAR(16) NOT NULL CHECK (status IN ('queued', 'running', 'done')),
+    total INTEGER NOT NULL,
+    processed INTEGER NOT NULL DEFAULT 0,
+    succeeded INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    results JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS bulk_jobs_user_id_idx ON bulk_jobs (user_id);
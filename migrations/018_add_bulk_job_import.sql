@@ -0,0 +1,5 @@
+-- migrations/018_add_bulk_job_import.sql
+
+-- Imports run as bulk jobs too.
+ALTER TABLE bulk_jobs DROP CONSTRAINT IF EXISTS bulk_jobs_kind_check;
+ALTER TABLE bulk_jobs ADD CONSTRAINT bulk_jobs_kind_check CHECK (kind IN ('create', 'delete', 'import'));
@@ -0,0 +1,10 @@
+-- migrations/019_add_bulk_job_heartbeat.sql
+
+-- Runners update their jobs regularly; queued and running jobs that stop
+-- being updated were left behind by a stopped instance and are marked as
+-- interrupted.
+ALTER TABLE bulk_jobs ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE bulk_jobs DROP CONSTRAINT IF EXISTS bulk_jobs_status_check;
+ALTER TABLE bulk_jobs ADD CONSTRAINT bulk_jobs_status_check CHECK (status IN ('queued', 'running', 'done', 'interrupted'));
+
+CREATE INDEX IF NOT EXISTS bulk_jobs_unfinished_idx ON bulk_jobs (updated_at) WHERE status IN ('queued', 'running');
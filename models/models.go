@@ -1,16 +1,279 @@
 // models/models.go
 package models
 
+import "time"
+
 // URLMapping represents the structure of the URL storage.
 type URLMapping struct {
-	UserID      int    `json:"userId"`
-	ShortCode   string `json:"shortCode"`
-	OriginalURL string `json:"originalUrl"`
-	VisitCount  int    `json:"visitCount"`
+	// UserID is the user who created the mapping. When WorkspaceID is set
+	// the mapping belongs to that workspace rather than to the user.
+	UserID      int        `json:"userId"`
+	WorkspaceID int        `json:"workspaceId,omitempty"`
+	ShortCode   string     `json:"shortCode"`
+	OriginalURL string     `json:"originalUrl"`
+	VisitCount  int        `json:"visitCount"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	MaxVisits   int        `json:"maxVisits,omitempty"` // 0 means unlimited
+	// RedirectType is the HTTP status used to redirect; 0 means the server default.
+	RedirectType int    `json:"redirectType,omitempty"`
+	Title        string `json:"title,omitempty"`
+	Description  string `json:"description,omitempty"`
+	// Tags are normalized by utils.NormalizeTags and sorted.
+	Tags      []string  `json:"tags"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// LastVisitedAt is when visits were last counted, which for buffered
+	// visits is when they were flushed.
+	LastVisitedAt *time.Time `json:"lastVisitedAt,omitempty"`
+	// Preview is fetched from the destination in the background after the
+	// link is created or its destination changes.
+	Preview *LinkPreview `json:"preview,omitempty"`
+	// OGTitle, OGDescription and OGImage customize the page served to
+	// social media crawlers instead of the redirect. Empty fields fall back
+	// to the title and description, then to the fetched preview.
+	OGTitle       string `json:"ogTitle,omitempty"`
+	OGDescription string `json:"ogDescription,omitempty"`
+	OGImage       string `json:"ogImage,omitempty"`
+	// PasswordHash is the bcrypt hash of the password visitors must enter,
+	// if any. Protected mirrors whether it is set for API responses.
+	PasswordHash string `json:"-"`
+	Protected    bool   `json:"protected,omitempty"`
+}
+
+// LinkPreview is what a link's destination page says about itself. URLs are
+// absolute and empty fields were missing from the page.
+type LinkPreview struct {
+	Title         string    `json:"title,omitempty"`
+	OGTitle       string    `json:"ogTitle,omitempty"`
+	OGDescription string    `json:"ogDescription,omitempty"`
+	OGImage       string    `json:"ogImage,omitempty"`
+	Favicon       string    `json:"favicon,omitempty"`
+	FetchedAt     time.Time `json:"fetchedAt"`
+}
+
+// Expired reports whether the mapping is past its expiry time or visit limit.
+func (m URLMapping) Expired(now time.Time) bool {
+	if m.ExpiresAt != nil && !now.Before(*m.ExpiresAt) {
+		return true
+	}
+	return m.MaxVisits > 0 && m.VisitCount >= m.MaxVisits
+}
+
+// URLRevision is a previous destination of a URL mapping, replaced at ChangedAt.
+type URLRevision struct {
+	OriginalURL string    `json:"originalUrl"`
+	ChangedAt   time.Time `json:"changedAt"`
 }
 
 type User struct {
-	ID       int    `json:"id"`
-	Email    string `json:"email"`
-	Password string `json:"password"` // hashed password
-}
\ No newline at end of file
+	ID              int        `json:"id"`
+	Email           string     `json:"email"`
+	Password        string     `json:"password"` // hashed password
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt,omitempty"`
+}
+
+// Purposes of an AccountToken.
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+	// TokenPurposeOIDCLogin tokens hand a completed single sign-on over to
+	// the frontend, which exchanges them for a session.
+	TokenPurposeOIDCLogin = "oidc_login"
+)
+
+// AccountToken is a single-use token emailed to a user to verify their
+// address or reset their password. Only its SHA-256 hash is stored.
+type AccountToken struct {
+	Hash      string
+	UserID    int
+	Purpose   string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// Identity links a user to their account at an OpenID Connect provider,
+// which is identified by its issuer and the account by its subject.
+type Identity struct {
+	Issuer    string
+	Subject   string
+	UserID    int
+	Email     string
+	CreatedAt time.Time
+}
+
+// Workspace member roles, from most to least privileged. Viewers can list
+// links and see analytics, editors also create, edit and delete links,
+// admins manage members and invitations, and owners can also manage other
+// owners.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+var roleRanks = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleAdmin: 3, RoleOwner: 4}
+
+// ValidRole reports whether role is one of the workspace roles.
+func ValidRole(role string) bool {
+	return roleRanks[role] > 0
+}
+
+// RoleAllows reports whether role grants at least the permissions of
+// required.
+func RoleAllows(role, required string) bool {
+	return ValidRole(required) && roleRanks[role] >= roleRanks[required]
+}
+
+// Workspace is a team whose members share ownership of its links. Role is
+// the requesting user's role, where a response includes it.
+type Workspace struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	Role      string    `json:"role,omitempty"`
+}
+
+// WorkspaceMember is a user's membership of a workspace.
+type WorkspaceMember struct {
+	WorkspaceID int       `json:"workspaceId"`
+	UserID      int       `json:"userId"`
+	Email       string    `json:"email"`
+	Role        string    `json:"role"`
+	JoinedAt    time.Time `json:"joinedAt"`
+}
+
+// WorkspaceInvitation invites an email address to join a workspace with a
+// role. The invitation is emailed as a single-use token of which only the
+// SHA-256 hash is stored.
+type WorkspaceInvitation struct {
+	ID          int        `json:"id"`
+	WorkspaceID int        `json:"workspaceId"`
+	Email       string     `json:"email"`
+	Role        string     `json:"role"`
+	Hash        string     `json:"-"`
+	InvitedBy   int        `json:"invitedBy"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	AcceptedAt  *time.Time `json:"acceptedAt,omitempty"`
+}
+
+// Session is a login and the family of refresh tokens rotated from it.
+// Revoking the session invalidates all of its access and refresh tokens.
+type Session struct {
+	ID        string     `json:"id"`
+	UserID    int        `json:"userId"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// RefreshToken is a single-use refresh token. Only the SHA-256 hash of the
+// token is stored.
+type RefreshToken struct {
+	Hash      string
+	SessionID string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// APIKey is a personal key for programmatic access on behalf of a user.
+// Only the SHA-256 hash of the key is stored; Prefix identifies it in
+// listings.
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Hash       string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// HasScope reports whether the key was granted scope.
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Click is a single recorded redirect of a short code.
+type Click struct {
+	ShortCode      string    `json:"shortCode"`
+	ClickedAt      time.Time `json:"clickedAt"`
+	Referrer       string    `json:"referrer"`
+	ReferrerHost   string    `json:"referrerHost"`
+	UserAgent      string    `json:"userAgent"`
+	Browser        string    `json:"browser"`
+	IP             string    `json:"ip"` // anonymised
+	AcceptLanguage string    `json:"acceptLanguage"`
+	Country        string    `json:"country"`
+}
+
+// ClickBucket is the number of clicks in the time bucket starting at Start.
+type ClickBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+// ClickCount is the number of clicks sharing a dimension value, such as a
+// referrer host or browser.
+type ClickCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Kinds of BulkJob.
+const (
+	BulkCreate = "create"
+	BulkDelete = "delete"
+	BulkImport = "import"
+)
+
+// Statuses of a BulkJob.
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	// JobInterrupted jobs stopped when the instance running them did.
+	JobInterrupted = "interrupted"
+)
+
+// BulkJob is a bulk create or delete run in the background. Results are
+// set once the job is done; until then Processed tracks its progress.
+// Runners update their jobs at least every storage.BulkJobHeartbeat.
+type BulkJob struct {
+	ID         string       `json:"id"`
+	UserID     int          `json:"-"`
+	Kind       string       `json:"kind"`
+	Status     string       `json:"status"`
+	Total      int          `json:"total"`
+	Processed  int          `json:"processed"`
+	Succeeded  int          `json:"succeeded"`
+	Failed     int          `json:"failed"`
+	Results    []BulkResult `json:"results,omitempty"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	UpdatedAt  time.Time    `json:"updatedAt"`
+	FinishedAt *time.Time   `json:"finishedAt,omitempty"`
+}
+
+// BulkResult is the outcome of one row of a bulk request. Row counts the
+// data rows from 1; Error is set if the row failed.
+type BulkResult struct {
+	Row         int        `json:"row"`
+	ShortCode   string     `json:"shortCode,omitempty"`
+	OriginalURL string     `json:"originalUrl,omitempty"`
+	IsNew       bool       `json:"isNew,omitempty"`
+	Error       *BulkError `json:"error,omitempty"`
+}
+
+// BulkError is why a row of a bulk request failed, in the form of the API's
+// error responses.
+type BulkError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,135 @@
+// oidc/idtoken.go
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"time"
+	"url-shortener/auth"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// clockSkew is how far the provider's clock may be off from ours.
+const clockSkew = time.Minute
+
+// IDToken is the verified identity of a signed-in user.
+type IDToken struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// audience accepts both forms of the aud claim: a string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = audience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) contains(v string) bool {
+	for _, s := range a {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// idTokenClaims are the ID token claims we check or use. jwt-go's
+// StandardClaims can't decode an array aud, so they are declared here.
+type idTokenClaims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	ExpiresAt       int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	Nonce           string   `json:"nonce"`
+	Email           string   `json:"email"`
+	// EmailVerified is a boolean, but some providers send it as a string.
+	EmailVerified interface{} `json:"email_verified"`
+}
+
+// Valid checks the token's lifetime; jwt-go calls it after the signature.
+func (c *idTokenClaims) Valid() error {
+	now := time.Now()
+	if c.ExpiresAt == 0 || now.Add(-clockSkew).Unix() > c.ExpiresAt {
+		return fmt.Errorf("%w: token has expired", ErrInvalidIDToken)
+	}
+	if c.IssuedAt > now.Add(clockSkew).Unix() {
+		return fmt.Errorf("%w: token was issued in the future", ErrInvalidIDToken)
+	}
+	return nil
+}
+
+// Verify checks rawIDToken's signature against the provider's keys, its
+// issuer, audience and lifetime, and that it carries nonce.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (IDToken, error) {
+	var claims idTokenClaims
+	_, err := jwt.ParseWithClaims(rawIDToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := p.keys.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		// The key decides the algorithm family, never the token
+		if !methodMatchesKey(token.Method, key) {
+			return nil, fmt.Errorf("algorithm %s does not match the key", token.Method.Alg())
+		}
+		return key, nil
+	})
+	if err != nil {
+		return IDToken{}, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	switch {
+	case claims.Issuer != p.issuer:
+		return IDToken{}, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	case !claims.Audience.contains(p.clientID):
+		return IDToken{}, fmt.Errorf("%w: token is not meant for this client", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.clientID:
+		return IDToken{}, fmt.Errorf("%w: token was issued to another party", ErrInvalidIDToken)
+	case claims.Nonce != nonce:
+		return IDToken{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return IDToken{}, fmt.Errorf("%w: token has no subject", ErrInvalidIDToken)
+	}
+
+	return IDToken{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified == true || claims.EmailVerified == "true",
+	}, nil
+}
+
+func methodMatchesKey(method jwt.SigningMethod, key interface{}) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		_, ok := method.(*jwt.SigningMethodRSA)
+		return ok
+	case *ecdsa.PublicKey:
+		_, ok := method.(*jwt.SigningMethodECDSA)
+		return ok
+	case ed25519.PublicKey:
+		return method == auth.SigningMethodEdDSA
+	default:
+		return false
+	}
+}
@@ -0,0 +1,147 @@
+// oidc/oidc_test.go
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+	"url-shortener/config"
+	"url-shortener/oidc/oidctest"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const redirectURL = "http://localhost:8080/auth/oidc/callback"
+
+func discover(t *testing.T, issuer string) *Provider {
+	t.Helper()
+	p, err := Discover(context.Background(), config.OIDCConfig{
+		Issuer:      issuer,
+		ClientID:    "shortener",
+		RedirectURL: redirectURL,
+		Scopes:      []string{"openid", "email"},
+	})
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	return p
+}
+
+// authorize runs the authorization request against the mock provider and
+// returns the code it redirects back with.
+func authorize(t *testing.T, p *Provider, nonce, verifier string) string {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(p.AuthCodeURL("state", nonce, verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || loc.Query().Get("state") != "state" {
+		t.Fatalf("unexpected redirect %q", resp.Header.Get("Location"))
+	}
+	return loc.Query().Get("code")
+}
+
+func TestCodeFlow(t *testing.T) {
+	srv, idp := oidctest.NewServer("shortener")
+	defer srv.Close()
+	p := discover(t, idp.Issuer)
+	ctx := context.Background()
+
+	code := authorize(t, p, "nonce", "verifier")
+	if _, err := p.Exchange(ctx, code, "wrong-verifier"); err == nil {
+		t.Error("Exchange accepted a wrong PKCE verifier")
+	}
+
+	code = authorize(t, p, "nonce", "verifier")
+	raw, err := p.Exchange(ctx, code, "verifier")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if _, err := p.Exchange(ctx, code, "verifier"); err == nil {
+		t.Error("Exchange redeemed a code twice")
+	}
+
+	if _, err := p.Verify(ctx, raw, "other-nonce"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("Verify with wrong nonce = %v, want ErrInvalidIDToken", err)
+	}
+	id, err := p.Verify(ctx, raw, "nonce")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	want := IDToken{Issuer: idp.Issuer, Subject: "alice", Email: "alice@example.com", EmailVerified: true}
+	if id != want {
+		t.Errorf("Verify = %+v, want %+v", id, want)
+	}
+}
+
+func TestVerifyRejectsForgedTokens(t *testing.T) {
+	srv, idp := oidctest.NewServer("shortener")
+	defer srv.Close()
+	p := discover(t, idp.Issuer)
+
+	valid := jwt.MapClaims{
+		"iss": idp.Issuer, "sub": "alice", "aud": "shortener", "nonce": "n",
+		"iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix(),
+	}
+	with := func(k string, v interface{}) jwt.MapClaims {
+		c := jwt.MapClaims{}
+		for key, val := range valid {
+			c[key] = val
+		}
+		c[k] = v
+		return c
+	}
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	sign := func(method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = "oidctest"
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	signIdP := func(claims jwt.MapClaims) string {
+		s, err := idp.Sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	if _, err := p.Verify(context.Background(), signIdP(valid), "n"); err != nil {
+		t.Fatalf("Verify rejected a valid token: %v", err)
+	}
+
+	for name, raw := range map[string]string{
+		"unknown key":     sign(jwt.SigningMethodRS256, otherKey, valid),
+		"HMAC":            sign(jwt.SigningMethodHS256, []byte("secret"), valid),
+		"wrong issuer":    signIdP(with("iss", "http://evil.example")),
+		"wrong audience":  signIdP(with("aud", "other")),
+		"other party":     signIdP(with("aud", []string{"shortener", "other"})),
+		"expired":         signIdP(with("exp", time.Now().Add(-time.Hour).Unix())),
+		"no subject":      signIdP(with("sub", "")),
+		"unsigned (none)": sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid),
+	} {
+		if _, err := p.Verify(context.Background(), raw, "n"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("%s: Verify = %v, want ErrInvalidIDToken", name, err)
+		}
+	}
+}
+
+func TestAudienceClaim(t *testing.T) {
+	var a audience
+	if err := a.UnmarshalJSON([]byte(`"one"`)); err != nil || !a.contains("one") {
+		t.Errorf("string aud decoded as %v, %v", a, err)
+	}
+	if err := a.UnmarshalJSON([]byte(`["one","two"]`)); err != nil || !a.contains("two") || len(a) != 2 {
+		t.Errorf("array aud decoded as %v, %v", a, err)
+	}
+}
@@ -0,0 +1,160 @@
+// preview/fetcher.go
+
+// Package preview fetches the title, Open Graph metadata and favicon of
+// links' destination pages.
+package preview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+	"url-shortener/config"
+	"url-shortener/models"
+)
+
+// maxRedirects is how many redirects a fetch follows.
+const maxRedirects = 5
+
+var (
+	ErrForbiddenAddress = errors.New("destination resolves to a non-public address")
+	ErrNotHTML          = errors.New("destination is not an HTML page")
+)
+
+// Fetcher downloads and parses destination pages. It only connects to
+// public addresses unless configured otherwise, checking every address it
+// dials, including those of redirects, so DNS can't be used to reach
+// internal services.
+type Fetcher struct {
+	client   *http.Client
+	maxBytes int64
+}
+
+// NewFetcher creates a Fetcher with the timeout, size limit and address
+// policy of cfg.
+func NewFetcher(cfg config.PreviewsConfig) *Fetcher {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivateNetworks {
+		dialer.Control = checkAddress
+	}
+	transport := &http.Transport{
+		// No proxy: it would be dialed instead of the destination
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   cfg.Timeout,
+		ResponseHeaderTimeout: cfg.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	return &Fetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return fmt.Errorf("stopped after %d redirects", maxRedirects)
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+				}
+				return nil
+			},
+		},
+		maxBytes: int64(cfg.MaxBytes),
+	}
+}
+
+// Fetch downloads rawURL and returns its preview. Only the first maxBytes
+// of the page are read, which normally covers its head.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (models.LinkPreview, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return models.LinkPreview{}, err
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return models.LinkPreview{}, fmt.Errorf("unsupported scheme %q", req.URL.Scheme)
+	}
+	req.Header.Set("User-Agent", "url-shortener-preview/1.0")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return models.LinkPreview{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return models.LinkPreview{}, fmt.Errorf("destination returned %s", resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return models.LinkPreview{}, ErrNotHTML
+	}
+
+	preview := Parse(io.LimitReader(resp.Body, f.maxBytes), resp.Request.URL)
+	preview.FetchedAt = time.Now()
+	return preview, nil
+}
+
+// checkAddress is a net.Dialer Control function refusing connections to
+// non-public addresses.
+func checkAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublic(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	return nil
+}
+
+// reservedNets are non-public ranges that the net.IP predicates miss.
+var reservedNets = mustParseCIDRs(
+	"0.0.0.0/8",      // "this" network
+	"100.64.0.0/10",  // carrier-grade NAT
+	"192.0.0.0/24",   // IETF protocol assignments
+	"198.18.0.0/15",  // benchmarking
+	"240.0.0.0/4",    // reserved, including broadcast
+	"64:ff9b::/96",   // NAT64, which can map to private IPv4 addresses
+	"64:ff9b:1::/48", // local-use NAT64
+	"2001:db8::/32",  // documentation
+)
+
+func isPublic(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range reservedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// faviconURL is the conventional favicon location, used when a page
+// declares none.
+func faviconURL(base *url.URL) string {
+	return (&url.URL{Scheme: base.Scheme, Host: base.Host, Path: "/favicon.ico"}).String()
+}
@@ -0,0 +1,131 @@
+// preview/parse.go
+package preview
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"url-shortener/models"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Field length limits, keeping oversized pages from bloating the store.
+const (
+	maxTextLength = 500
+	maxURLLength  = 2048
+)
+
+// Parse extracts a preview from the HTML read from r, resolving URLs against
+// base. It stops at the end of the head and tolerates truncated or malformed
+// markup, keeping whatever it found.
+func Parse(r io.Reader, base *url.URL) models.LinkPreview {
+	var p models.LinkPreview
+	z := html.NewTokenizer(r)
+	inTitle := false
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return finish(p, base)
+		case html.TextToken:
+			if inTitle && p.Title == "" {
+				p.Title = clean(string(z.Text()), maxTextLength)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			switch tok.DataAtom {
+			case atom.Title:
+				inTitle = tt == html.StartTagToken
+			case atom.Meta:
+				readMeta(&p, tok, base)
+			case atom.Link:
+				readLink(&p, tok, base)
+			case atom.Body:
+				return finish(p, base)
+			}
+		case html.EndTagToken:
+			switch z.Token().DataAtom {
+			case atom.Title:
+				inTitle = false
+			case atom.Head:
+				return finish(p, base)
+			}
+		}
+	}
+}
+
+func readMeta(p *models.LinkPreview, tok html.Token, base *url.URL) {
+	// Open Graph uses property, but name is common in the wild.
+	key := strings.ToLower(attr(tok, "property"))
+	if key == "" {
+		key = strings.ToLower(attr(tok, "name"))
+	}
+	content := attr(tok, "content")
+	switch key {
+	case "og:title":
+		if p.OGTitle == "" {
+			p.OGTitle = clean(content, maxTextLength)
+		}
+	case "og:description":
+		if p.OGDescription == "" {
+			p.OGDescription = clean(content, maxTextLength)
+		}
+	case "og:image", "og:image:url", "og:image:secure_url":
+		if p.OGImage == "" {
+			p.OGImage = resolve(base, content)
+		}
+	}
+}
+
+func readLink(p *models.LinkPreview, tok html.Token, base *url.URL) {
+	if p.Favicon != "" {
+		return
+	}
+	for _, rel := range strings.Fields(strings.ToLower(attr(tok, "rel"))) {
+		if rel == "icon" || rel == "apple-touch-icon" {
+			p.Favicon = resolve(base, attr(tok, "href"))
+			return
+		}
+	}
+}
+
+func finish(p models.LinkPreview, base *url.URL) models.LinkPreview {
+	if p.Favicon == "" {
+		p.Favicon = faviconURL(base)
+	}
+	return p
+}
+
+func attr(tok html.Token, name string) string {
+	for _, a := range tok.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// resolve returns ref as an absolute http(s) URL, or "" if it isn't one.
+func resolve(base *url.URL, ref string) string {
+	u, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	s := u.String()
+	if len(s) > maxURLLength {
+		return ""
+	}
+	return s
+}
+
+// clean collapses whitespace, drops invalid UTF-8 and truncates s to max
+// runes.
+func clean(s string, max int) string {
+	s = strings.Join(strings.Fields(strings.ToValidUTF8(s, "")), " ")
+	if r := []rune(s); len(r) > max {
+		s = string(r[:max])
+	}
+	return s
+}
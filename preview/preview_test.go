@@ -0,0 +1,155 @@
+// preview/preview_test.go
+package preview
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"url-shortener/config"
+	"url-shortener/models"
+	"url-shortener/storage"
+)
+
+const page = `<!DOCTYPE html>
+<html><head>
+<title>  Example
+ Page </title>
+<meta property="og:title" content="Example OG">
+<meta name="og:description" content="About the example">
+<meta property="og:image" content="/img/card.png">
+<link rel="shortcut icon" href="//cdn.example.com/fav.ico">
+</head><body><meta property="og:title" content="ignored"></body></html>`
+
+func testConfig() config.PreviewsConfig {
+	return config.PreviewsConfig{Enabled: true, Workers: 1, QueueSize: 4, Timeout: 2 * time.Second, MaxBytes: 64 << 10}
+}
+
+func TestParse(t *testing.T) {
+	base, _ := url.Parse("https://example.com/articles/1")
+	p := Parse(strings.NewReader(page), base)
+	want := models.LinkPreview{
+		Title:         "Example Page",
+		OGTitle:       "Example OG",
+		OGDescription: "About the example",
+		OGImage:       "https://example.com/img/card.png",
+		Favicon:       "https://cdn.example.com/fav.ico",
+	}
+	if p != want {
+		t.Errorf("Parse = %+v, want %+v", p, want)
+	}
+
+	p = Parse(strings.NewReader(`<title>Bare</title><meta property="og:image" content="javascript:alert(1)">`), base)
+	if p.Title != "Bare" || p.OGImage != "" || p.Favicon != "https://example.com/favicon.ico" {
+		t.Errorf("Parse of a bare page = %+v", p)
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":      true,
+		"2606:4700::1111":    true,
+		"127.0.0.1":          false,
+		"10.1.2.3":           false,
+		"169.254.169.254":    false,
+		"100.64.0.1":         false,
+		"0.0.0.0":            false,
+		"::1":                false,
+		"fd00::1":            false,
+		"::ffff:192.168.0.1": false,
+		"64:ff9b::a00:1":     false,
+	}
+	for addr, want := range tests {
+		if got := isPublic(net.ParseIP(addr)); got != want {
+			t.Errorf("isPublic(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(page))
+		case "/redirect":
+			http.Redirect(w, r, "/page", http.StatusFound)
+		case "/large":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<head>" + strings.Repeat("<!-- padding -->", 10000) + "<title>Too late</title>"))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("\x89PNG"))
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	// The test server listens on loopback, which is refused by default.
+	if _, err := NewFetcher(testConfig()).Fetch(ctx, srv.URL+"/page"); !errors.Is(err, ErrForbiddenAddress) {
+		t.Fatalf("Fetch of a loopback address: err = %v, want ErrForbiddenAddress", err)
+	}
+
+	cfg := testConfig()
+	cfg.AllowPrivateNetworks = true
+	f := NewFetcher(cfg)
+	p, err := f.Fetch(ctx, srv.URL+"/redirect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.OGTitle != "Example OG" || p.OGImage != srv.URL+"/img/card.png" || p.FetchedAt.IsZero() {
+		t.Errorf("Fetch = %+v", p)
+	}
+	if p, err := f.Fetch(ctx, srv.URL+"/large"); err != nil || p.Title != "" {
+		t.Errorf("Fetch past the size limit = %+v, %v; want no title", p, err)
+	}
+	if _, err := f.Fetch(ctx, srv.URL+"/image"); !errors.Is(err, ErrNotHTML) {
+		t.Errorf("Fetch of an image: err = %v, want ErrNotHTML", err)
+	}
+	if _, err := f.Fetch(ctx, "file:///etc/passwd"); err == nil {
+		t.Error("Fetch of a file URL succeeded")
+	}
+}
+
+func TestWorker(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(page))
+	}))
+	defer srv.Close()
+
+	store := storage.NewMemoryStore()
+	if err := store.SaveURLMapping(models.URLMapping{ShortCode: "abc", OriginalURL: srv.URL, UserID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig()
+	cfg.AllowPrivateNetworks = true
+	w := NewWorker(NewFetcher(cfg), store, cfg.QueueSize)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx, cfg.Workers)
+
+	w.Enqueue("abc", srv.URL)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		m, err := store.GetURLMappingByShortCode("abc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Preview != nil {
+			if m.Preview.Title != "Example Page" {
+				t.Errorf("stored preview = %+v", m.Preview)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("preview was not stored")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,77 @@
+// preview/worker.go
+package preview
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"url-shortener/storage"
+)
+
+// Queue schedules preview fetches. Implementations must not block.
+type Queue interface {
+	Enqueue(shortCode, originalURL string)
+}
+
+type job struct {
+	shortCode   string
+	originalURL string
+}
+
+// Worker fetches previews for queued links on a bounded pool of goroutines
+// and stores them. Jobs are dropped when the queue is full; the link then
+// simply has no preview.
+type Worker struct {
+	fetcher *Fetcher
+	store   storage.LinkStore
+	queue   chan job
+}
+
+// NewWorker creates a Worker with room for size queued jobs. Call Run to
+// start fetching.
+func NewWorker(fetcher *Fetcher, store storage.LinkStore, size int) *Worker {
+	return &Worker{fetcher: fetcher, store: store, queue: make(chan job, size)}
+}
+
+// Enqueue schedules a fetch of originalURL for the link without blocking.
+func (w *Worker) Enqueue(shortCode, originalURL string) {
+	select {
+	case w.queue <- job{shortCode: shortCode, originalURL: originalURL}:
+	default:
+		log.Printf("Preview queue full, dropping preview of %s", shortCode)
+	}
+}
+
+// Run processes queued jobs with the given number of concurrent fetches
+// until ctx is cancelled. Pending jobs are abandoned on cancellation.
+func (w *Worker) Run(ctx context.Context, workers int) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case j := <-w.queue:
+					w.process(ctx, j)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (w *Worker) process(ctx context.Context, j job) {
+	p, err := w.fetcher.Fetch(ctx, j.originalURL)
+	if err != nil {
+		log.Printf("Error fetching preview of %s: %v", j.shortCode, err)
+		return
+	}
+	err = w.store.SetURLPreview(j.shortCode, j.originalURL, p)
+	if err != nil && !errors.Is(err, storage.ErrURLNotFound) {
+		log.Printf("Error storing preview of %s: %v", j.shortCode, err)
+	}
+}
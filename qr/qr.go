@@ -0,0 +1,245 @@
+// qr/qr.go
+
+// Package qr renders QR codes as PNG or SVG images.
+package qr
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+
+	// Registered for LoadLogo
+	_ "image/gif"
+	_ "image/jpeg"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Output formats.
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+)
+
+// logoWidth is the share of the symbol's width covered by a logo. It hides
+// well under the 25% of modules level Q recovers.
+const logoWidth = 0.2
+
+var (
+	ErrInvalidLevel = errors.New("level must be L, M, Q or H")
+	ErrInvalidColor = errors.New("colours must be hex RGB, like 1a2b3c or fff")
+	ErrSizeTooSmall = errors.New("size is too small for the code and margin")
+	// ErrLogoLevel is returned for a logo with too little error correction
+	// to read the modules it hides.
+	ErrLogoLevel = errors.New("a logo requires error correction level Q or H")
+)
+
+// Options control how a QR code is rendered.
+type Options struct {
+	Format string
+	// Size is the width and height of the image in pixels.
+	Size int
+	// Margin is the width of the quiet zone, in modules.
+	Margin int
+	// Level is the error correction level: L, M, Q or H.
+	Level      string
+	Foreground color.RGBA
+	Background color.RGBA
+	// Logo, if set, is drawn in the middle of the code.
+	Logo image.Image
+}
+
+// ContentType returns the MIME type of images in format.
+func ContentType(format string) string {
+	if format == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+var levels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// Render encodes content as a QR code image.
+func Render(content string, opts Options) ([]byte, error) {
+	level, ok := levels[opts.Level]
+	if !ok {
+		return nil, ErrInvalidLevel
+	}
+	if opts.Logo != nil && level < qrcode.High {
+		return nil, ErrLogoLevel
+	}
+	code, err := qrcode.New(content, level)
+	if err != nil {
+		return nil, err
+	}
+	code.DisableBorder = true
+	modules := code.Bitmap()
+
+	if total := len(modules) + 2*opts.Margin; opts.Size < total {
+		return nil, fmt.Errorf("%w, which need at least %d pixels", ErrSizeTooSmall, total)
+	}
+	if opts.Format == FormatSVG {
+		return renderSVG(modules, opts)
+	}
+	return renderPNG(modules, opts)
+}
+
+// layout returns the pixel size of a module and the offset of the symbol,
+// which is centred in the image with whole-pixel modules.
+func layout(modules [][]bool, opts Options) (scale, offset int) {
+	n := len(modules)
+	scale = opts.Size / (n + 2*opts.Margin)
+	return scale, (opts.Size - scale*n) / 2
+}
+
+func renderPNG(modules [][]bool, opts Options) ([]byte, error) {
+	var img draw.Image
+	rect := image.Rect(0, 0, opts.Size, opts.Size)
+	if opts.Logo == nil {
+		img = image.NewPaletted(rect, color.Palette{opts.Background, opts.Foreground})
+	} else {
+		img = image.NewRGBA(rect)
+	}
+	draw.Draw(img, rect, image.NewUniform(opts.Background), image.Point{}, draw.Src)
+
+	fg := image.NewUniform(opts.Foreground)
+	scale, offset := layout(modules, opts)
+	for y, row := range modules {
+		for x, dark := range row {
+			if dark {
+				r := image.Rect(offset+x*scale, offset+y*scale, offset+(x+1)*scale, offset+(y+1)*scale)
+				draw.Draw(img, r, fg, image.Point{}, draw.Src)
+			}
+		}
+	}
+
+	if opts.Logo != nil {
+		area := logoArea(len(modules), scale, offset)
+		draw.Draw(img, area.Inset(-scale), image.NewUniform(opts.Background), image.Point{}, draw.Src)
+		draw.Draw(img, area, scaleImage(opts.Logo, area.Dx(), area.Dy()), image.Point{}, draw.Over)
+	}
+
+	var buf bytes.Buffer
+	if err := (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func renderSVG(modules [][]bool, opts Options) ([]byte, error) {
+	total := len(modules) + 2*opts.Margin
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		opts.Size, opts.Size, total, total)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="%s"/>`, total, total, hex(opts.Background))
+
+	// One horizontal run of dark modules per subpath
+	fmt.Fprintf(&buf, `<path fill="%s" d="`, hex(opts.Foreground))
+	for y, row := range modules {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", start+opts.Margin, y+opts.Margin, x-start, x-start)
+		}
+	}
+	buf.WriteString(`"/>`)
+
+	if opts.Logo != nil {
+		// Laid out in modules, the viewBox unit, rather than pixels
+		area := logoArea(len(modules), 1, opts.Margin)
+		var logo bytes.Buffer
+		if err := png.Encode(&logo, opts.Logo); err != nil {
+			return nil, err
+		}
+		pad := area.Inset(-1)
+		fmt.Fprintf(&buf, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`, pad.Min.X, pad.Min.Y, pad.Dx(), pad.Dy(), hex(opts.Background))
+		fmt.Fprintf(&buf, `<image x="%d" y="%d" width="%d" height="%d" preserveAspectRatio="xMidYMid meet" href="data:image/png;base64,%s"/>`,
+			area.Min.X, area.Min.Y, area.Dx(), area.Dy(), base64.StdEncoding.EncodeToString(logo.Bytes()))
+	}
+	buf.WriteString(`</svg>`)
+	return buf.Bytes(), nil
+}
+
+// logoArea returns the square covered by a logo in a symbol of n modules
+// drawn at scale from offset.
+func logoArea(n, scale, offset int) image.Rectangle {
+	w := int(float64(n) * logoWidth)
+	start := (n - w) / 2
+	return image.Rect(offset+start*scale, offset+start*scale, offset+(start+w)*scale, offset+(start+w)*scale)
+}
+
+// scaleImage resizes src to w×h with nearest-neighbour sampling, keeping
+// its aspect ratio and centring it.
+func scaleImage(src image.Image, w, h int) image.Image {
+	b := src.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return image.NewRGBA(image.Rect(0, 0, w, h))
+	}
+	dw, dh := w, h
+	if b.Dx()*h > b.Dy()*w {
+		dh = b.Dy() * w / b.Dx()
+	} else {
+		dw = b.Dx() * h / b.Dy()
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	ox, oy := (w-dw)/2, (h-dh)/2
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			dst.Set(ox+x, oy+y, src.At(b.Min.X+x*b.Dx()/dw, b.Min.Y+y*b.Dy()/dh))
+		}
+	}
+	return dst
+}
+
+// ParseColor parses a hex RGB colour, with or without a leading #, in
+// either the 3 or 6 digit form.
+func ParseColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) != 6 {
+		return color.RGBA{}, ErrInvalidColor
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, ErrInvalidColor
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// LoadLogo reads a PNG, JPEG or GIF logo from path.
+func LoadLogo(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding logo %s: %v", path, err)
+	}
+	return img, nil
+}
@@ -0,0 +1,114 @@
+// qr/qr_test.go
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestRenderPNG(t *testing.T) {
+	fg, _ := ParseColor("#123456")
+	bg, _ := ParseColor("fff")
+	data, err := Render("http://localhost:8080/abc123", Options{Format: FormatPNG, Size: 300, Margin: 4, Level: "M", Foreground: fg, Background: bg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 300 || b.Dy() != 300 {
+		t.Fatalf("image is %v, want 300x300", b)
+	}
+
+	// The URL needs a version 3 code at level M, with 29 modules: 37 with
+	// the margin, so 8 pixels each, centred 34 pixels from the edge
+	if got := rgba(img.At(33, 33)); got != bg {
+		t.Errorf("quiet zone pixel = %v, want %v", got, bg)
+	}
+	if got := rgba(img.At(34, 34)); got != fg {
+		t.Errorf("finder pattern corner = %v, want %v", got, fg)
+	}
+	if got := rgba(img.At(34+8+4, 34+8+4)); got != bg {
+		t.Errorf("finder pattern ring = %v, want %v", got, bg)
+	}
+}
+
+func TestRenderSVG(t *testing.T) {
+	black, _ := ParseColor("000")
+	white, _ := ParseColor("fff")
+	data, err := Render("http://localhost:8080/abc123", Options{Format: FormatSVG, Size: 200, Margin: 2, Level: "L", Foreground: black, Background: white})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg := string(data)
+	for _, want := range []string{`width="200"`, `viewBox="0 0 29 29"`, `fill="#000000"`, `fill="#ffffff"`, `M2 2h7v1h-7z`} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("SVG lacks %s: %s", want, svg)
+		}
+	}
+}
+
+func TestRenderLogo(t *testing.T) {
+	red := color.RGBA{R: 0xff, A: 0xff}
+	logo := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(logo, logo.Bounds(), image.NewUniform(red), image.Point{}, draw.Src)
+	opts := Options{Format: FormatPNG, Size: 330, Margin: 4, Level: "M", Background: color.RGBA{0xff, 0xff, 0xff, 0xff}, Logo: logo}
+	if _, err := Render("http://localhost:8080/abc123", opts); !errors.Is(err, ErrLogoLevel) {
+		t.Errorf("logo at level M: err = %v, want ErrLogoLevel", err)
+	}
+
+	opts.Level = "H"
+	data, err := Render("http://localhost:8080/abc123", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _ := png.Decode(bytes.NewReader(data))
+	if got := rgba(img.At(165, 165)); got != red {
+		t.Errorf("centre pixel = %v, want the logo's %v", got, red)
+	}
+
+	opts.Format = FormatSVG
+	if data, err := Render("http://localhost:8080/abc123", opts); err != nil || !strings.Contains(string(data), "data:image/png;base64,") {
+		t.Errorf("SVG lacks the logo: %v", err)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	opts := Options{Format: FormatPNG, Size: 20, Margin: 4, Level: "M"}
+	if _, err := Render("http://localhost:8080/abc123", opts); !errors.Is(err, ErrSizeTooSmall) {
+		t.Errorf("tiny image: err = %v, want ErrSizeTooSmall", err)
+	}
+	opts.Size, opts.Level = 256, "X"
+	if _, err := Render("http://localhost:8080/abc123", opts); !errors.Is(err, ErrInvalidLevel) {
+		t.Errorf("level X: err = %v, want ErrInvalidLevel", err)
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := map[string]color.RGBA{
+		"#ff8800": {0xff, 0x88, 0x00, 0xff},
+		"ff8800":  {0xff, 0x88, 0x00, 0xff},
+		"f80":     {0xff, 0x88, 0x00, 0xff},
+	}
+	for in, want := range tests {
+		if got, err := ParseColor(in); err != nil || got != want {
+			t.Errorf("ParseColor(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "red", "#12345", "gggggg"} {
+		if _, err := ParseColor(in); !errors.Is(err, ErrInvalidColor) {
+			t.Errorf("ParseColor(%q): err = %v, want ErrInvalidColor", in, err)
+		}
+	}
+}
+
+func rgba(c color.Color) color.RGBA {
+	return color.RGBAModel.Convert(c).(color.RGBA)
+}
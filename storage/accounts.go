@@ -0,0 +1,39 @@
+// storage/accounts.go
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+	"url-shortener/models"
+)
+
+var ErrAccountTokenInvalid = errors.New("token is invalid or has expired")
+
+// SaveAccountToken inserts a new account token.
+func (s *PostgresStore) SaveAccountToken(token models.AccountToken) error {
+	query := `INSERT INTO account_tokens (token_hash, user_id, purpose, expires_at) VALUES ($1, $2, $3, $4)`
+	_, err := s.db.Exec(query, token.Hash, token.UserID, token.Purpose, token.ExpiresAt)
+	return err
+}
+
+// UseAccountToken marks an unexpired, unused account token used. The update
+// is conditional so that a token can't be redeemed twice concurrently.
+func (s *PostgresStore) UseAccountToken(hash, purpose string, at time.Time) (models.AccountToken, error) {
+	token := models.AccountToken{Hash: hash, Purpose: purpose, UsedAt: &at}
+	query := `UPDATE account_tokens SET used_at = $3
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3
+		RETURNING user_id, expires_at`
+	err := s.db.QueryRow(query, hash, purpose, at).Scan(&token.UserID, &token.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return models.AccountToken{}, ErrAccountTokenInvalid
+	}
+	return token, err
+}
+
+// DeleteAccountTokens drops a user's outstanding tokens for purpose.
+func (s *PostgresStore) DeleteAccountTokens(userID int, purpose string) error {
+	query := `DELETE FROM account_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`
+	_, err := s.db.Exec(query, userID, purpose)
+	return err
+}
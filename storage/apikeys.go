@@ -0,0 +1,89 @@
+// storage/apikeys.go
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+	"url-shortener/models"
+
+	"github.com/lib/pq"
+)
+
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// apiKeyColumns lists the api_keys columns read by scanAPIKey, in order.
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scopes, created_at, last_used_at, revoked_at`
+
+func scanAPIKey(row rowScanner) (models.APIKey, error) {
+	var key models.APIKey
+	var lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Hash, pq.Array(&key.Scopes),
+		&key.CreatedAt, &lastUsedAt, &revokedAt)
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, err
+}
+
+// CreateAPIKey inserts a new API key and returns it with its ID set.
+func (s *PostgresStore) CreateAPIKey(key models.APIKey) (models.APIKey, error) {
+	query := `INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+	err := s.db.QueryRow(query, key.UserID, key.Name, key.Prefix, key.Hash, pq.Array(key.Scopes), key.CreatedAt).Scan(&key.ID)
+	return key, err
+}
+
+// GetAPIKeyByHash retrieves an API key by the hash of its secret.
+func (s *PostgresStore) GetAPIKeyByHash(hash string) (models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE key_hash = $1`
+	key, err := scanAPIKey(s.db.QueryRow(query, hash))
+	if err == sql.ErrNoRows {
+		return key, ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+// ListAPIKeys returns a user's API keys, oldest first.
+func (s *PostgresStore) ListAPIKeys(userID int) ([]models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE user_id = $1 ORDER BY id`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey marks one of a user's API keys revoked. Revoking it again
+// keeps the original time.
+func (s *PostgresStore) RevokeAPIKey(userID, id int, at time.Time) error {
+	query := `UPDATE api_keys SET revoked_at = COALESCE(revoked_at, $3) WHERE id = $1 AND user_id = $2`
+	result, err := s.db.Exec(query, id, userID, at)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return err
+}
+
+// TouchAPIKey records when an API key was last used.
+func (s *PostgresStore) TouchAPIKey(id int, at time.Time) error {
+	query := `UPDATE api_keys SET last_used_at = $2 WHERE id = $1`
+	_, err := s.db.Exec(query, id, at)
+	return err
+}
@@ -0,0 +1,319 @@
+// storage/cache.go
+package storage
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+	"url-shortener/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Cache is a byte-oriented key/value cache with per-entry TTLs.
+type Cache interface {
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+}
+
+// RedisCache is a Cache shared by every app instance through Redis.
+type RedisCache struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisCache creates a RedisCache whose keys are namespaced by prefix.
+func NewRedisCache(r *RedisClient, prefix string) *RedisCache {
+	return &RedisCache{client: r.Client, prefix: prefix}
+}
+
+// Get returns the cached value for key, if any.
+func (c *RedisCache) Get(key string) ([]byte, bool, error) {
+	b, err := c.client.Get(context.Background(), c.prefix+key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("error reading cache: %v", err)
+	}
+	return b, true, nil
+}
+
+// Set caches value under key for ttl.
+func (c *RedisCache) Set(key string, value []byte, ttl time.Duration) error {
+	if err := c.client.Set(context.Background(), c.prefix+key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("error writing cache: %v", err)
+	}
+	return nil
+}
+
+// Delete evicts key.
+func (c *RedisCache) Delete(key string) error {
+	if err := c.client.Del(context.Background(), c.prefix+key).Err(); err != nil {
+		return fmt.Errorf("error invalidating cache: %v", err)
+	}
+	return nil
+}
+
+// LRUCache is an in-process Cache that evicts the least recently used entry
+// once it holds capacity entries.
+type LRUCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // front is most recently used
+	entries  map[string]*list.Element
+	now      func() time.Time
+}
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// NewLRUCache creates an LRUCache holding at most capacity entries.
+func NewLRUCache(capacity int) *LRUCache {
+	return &LRUCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		now:      time.Now,
+	}
+}
+
+// Get returns the cached value for key, if any and not expired.
+func (c *LRUCache) Get(key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	e := el.Value.(*lruEntry)
+	if !c.now().Before(e.expiresAt) {
+		c.remove(el)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(el)
+	return e.value, true, nil
+}
+
+// Set caches value under key for ttl.
+func (c *LRUCache) Set(key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expiresAt: c.now().Add(ttl)})
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+	return nil
+}
+
+// Delete evicts key.
+func (c *LRUCache) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	return nil
+}
+
+func (c *LRUCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*lruEntry).key)
+}
+
+// CachedLinkStore is a read-through cache in front of a LinkStore for
+// short-code lookups, the query behind every redirect. Unknown codes are
+// cached too (negative caching) so guest links and typos don't reach the
+// database. Password-protected links aren't cached, to keep their password
+// hashes out of a cache that may be shared, so they are always looked up.
+// Entries are invalidated whenever the mapping is written; with an
+// in-process cache, other instances see changes once the TTL lapses.
+type CachedLinkStore struct {
+	LinkStore
+	cache       Cache
+	ttl         time.Duration
+	negativeTTL time.Duration
+}
+
+// NewCachedLinkStore wraps links with cache. Found mappings are kept for ttl
+// and unknown codes for negativeTTL.
+func NewCachedLinkStore(links LinkStore, cache Cache, ttl, negativeTTL time.Duration) *CachedLinkStore {
+	return &CachedLinkStore{LinkStore: links, cache: cache, ttl: ttl, negativeTTL: negativeTTL}
+}
+
+// cachedLink is the cache entry for a short code. Found is false for
+// negative entries.
+type cachedLink struct {
+	Found   bool
+	Mapping models.URLMapping
+}
+
+// GetURLMappingByShortCode serves the mapping from the cache, falling back to
+// the wrapped store on a miss.
+func (c *CachedLinkStore) GetURLMappingByShortCode(shortCode string) (models.URLMapping, error) {
+	if b, ok, err := c.cache.Get(shortCode); err == nil && ok {
+		var entry cachedLink
+		if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&entry); err == nil {
+			if !entry.Found {
+				return models.URLMapping{}, ErrURLNotFound
+			}
+			return entry.Mapping, nil
+		}
+	}
+
+	urlMapping, err := c.LinkStore.GetURLMappingByShortCode(shortCode)
+	switch {
+	case err == nil && urlMapping.PasswordHash != "":
+	case err == nil:
+		c.store(shortCode, cachedLink{Found: true, Mapping: urlMapping}, c.ttl)
+	case errors.Is(err, ErrURLNotFound):
+		c.store(shortCode, cachedLink{}, c.negativeTTL)
+	}
+	return urlMapping, err
+}
+
+// SaveURLMapping saves the mapping and drops any negative entry for its code.
+func (c *CachedLinkStore) SaveURLMapping(urlMapping models.URLMapping) error {
+	err := c.LinkStore.SaveURLMapping(urlMapping)
+	c.Invalidate(urlMapping.ShortCode)
+	return err
+}
+
+// IncrementURLVisitCount counts the visit and drops the cached mapping, whose
+// visit count may now have reached its limit.
+func (c *CachedLinkStore) IncrementURLVisitCount(userID int, shortCode string) error {
+	err := c.LinkStore.IncrementURLVisitCount(userID, shortCode)
+	c.Invalidate(shortCode)
+	return err
+}
+
+// SetURLVisits overwrites the mapping's visits and drops its cache entry.
+func (c *CachedLinkStore) SetURLVisits(shortCode string, visitCount int, lastVisitedAt *time.Time) error {
+	err := c.LinkStore.SetURLVisits(shortCode, visitCount, lastVisitedAt)
+	c.Invalidate(shortCode)
+	return err
+}
+
+// UpdateURLMapping edits the mapping and drops its cache entry.
+func (c *CachedLinkStore) UpdateURLMapping(urlMapping models.URLMapping) error {
+	err := c.LinkStore.UpdateURLMapping(urlMapping)
+	c.Invalidate(urlMapping.ShortCode)
+	return err
+}
+
+// SetURLPreview stores the preview and drops the cached mapping.
+func (c *CachedLinkStore) SetURLPreview(shortCode, originalURL string, preview models.LinkPreview) error {
+	err := c.LinkStore.SetURLPreview(shortCode, originalURL, preview)
+	c.Invalidate(shortCode)
+	return err
+}
+
+// DeleteURLMapping deletes the mapping and its cache entry.
+func (c *CachedLinkStore) DeleteURLMapping(userID int, shortCode string) error {
+	err := c.LinkStore.DeleteURLMapping(userID, shortCode)
+	c.Invalidate(shortCode)
+	return err
+}
+
+// Invalidate evicts the cache entry for shortCode.
+func (c *CachedLinkStore) Invalidate(shortCode string) {
+	if err := c.cache.Delete(shortCode); err != nil {
+		logCacheError(err)
+	}
+}
+
+func (c *CachedLinkStore) store(shortCode string, entry cachedLink, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		logCacheError(err)
+		return
+	}
+	if err := c.cache.Set(shortCode, buf.Bytes(), ttl); err != nil {
+		logCacheError(err)
+	}
+}
+
+// CachedUserStore puts a Cache in front of the user lookups made by the auth
+// middleware on every authenticated request. Entries are invalidated when
+// the user is written; with an in-process cache, other instances see
+// changes once the TTL lapses. Password hashes are kept out of the cache,
+// which may be shared, so GetUserByID leaves them out; GetUserByEmail isn't
+// cached and returns them.
+type CachedUserStore struct {
+	UserStore
+	cache Cache
+	ttl   time.Duration
+}
+
+// NewCachedUserStore wraps users with cache, keeping users for ttl.
+func NewCachedUserStore(users UserStore, cache Cache, ttl time.Duration) *CachedUserStore {
+	return &CachedUserStore{UserStore: users, cache: cache, ttl: ttl}
+}
+
+// GetUserByID serves the user, without their password hash, from the
+// cache, falling back to the wrapped store on a miss.
+func (c *CachedUserStore) GetUserByID(id int) (models.User, error) {
+	key := strconv.Itoa(id)
+	if b, ok, err := c.cache.Get(key); err == nil && ok {
+		var user models.User
+		if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&user); err == nil {
+			return user, nil
+		}
+	}
+
+	user, err := c.UserStore.GetUserByID(id)
+	if err != nil {
+		return user, err
+	}
+	user.Password = ""
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(user); err != nil {
+		logCacheError(err)
+	} else if err := c.cache.Set(key, buf.Bytes(), c.ttl); err != nil {
+		logCacheError(err)
+	}
+	return user, nil
+}
+
+// MarkEmailVerified marks the user verified and drops their cache entry.
+func (c *CachedUserStore) MarkEmailVerified(userID int, at time.Time) error {
+	err := c.UserStore.MarkEmailVerified(userID, at)
+	c.invalidate(userID)
+	return err
+}
+
+// UpdatePassword changes the user's password and drops their cache entry.
+func (c *CachedUserStore) UpdatePassword(userID int, passwordHash string) error {
+	err := c.UserStore.UpdatePassword(userID, passwordHash)
+	c.invalidate(userID)
+	return err
+}
+
+func (c *CachedUserStore) invalidate(userID int) {
+	if err := c.cache.Delete(strconv.Itoa(userID)); err != nil {
+		logCacheError(err)
+	}
+}
+
+func logCacheError(err error) {
+	log.Printf("Cache error: %v", err)
+}
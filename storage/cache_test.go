@@ -0,0 +1,131 @@
+// storage/cache_test.go
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+	"url-shortener/models"
+)
+
+func TestLRUCacheEvictionAndTTL(t *testing.T) {
+	c := NewLRUCache(2)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Set("a", []byte("1"), time.Minute)
+	c.Set("b", []byte("2"), time.Minute)
+	c.Get("a") // a is now more recently used than b
+	c.Set("c", []byte("3"), time.Minute)
+
+	if _, ok, _ := c.Get("b"); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	if v, ok, _ := c.Get("a"); !ok || string(v) != "1" {
+		t.Errorf("got %q, %v for a", v, ok)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok, _ := c.Get("a"); ok {
+		t.Error("expired entry was returned")
+	}
+}
+
+// countingLinks counts short-code lookups reaching the wrapped store.
+type countingLinks struct {
+	*MemoryStore
+	lookups int
+}
+
+func (c *countingLinks) GetURLMappingByShortCode(shortCode string) (models.URLMapping, error) {
+	c.lookups++
+	return c.MemoryStore.GetURLMappingByShortCode(shortCode)
+}
+
+func TestCachedLinkStore(t *testing.T) {
+	backing := &countingLinks{MemoryStore: NewMemoryStore()}
+	links := NewCachedLinkStore(backing, NewLRUCache(100), time.Minute, time.Minute)
+
+	// Unknown codes are negatively cached.
+	for i := 0; i < 3; i++ {
+		if _, err := links.GetURLMappingByShortCode("promo"); !errors.Is(err, ErrURLNotFound) {
+			t.Fatalf("got %v want ErrURLNotFound", err)
+		}
+	}
+	if backing.lookups != 1 {
+		t.Errorf("negative lookups reached the store %d times, want 1", backing.lookups)
+	}
+
+	// Saving drops the negative entry.
+	links.SaveURLMapping(models.URLMapping{UserID: 1, ShortCode: "promo", OriginalURL: "https://example.com"})
+	for i := 0; i < 3; i++ {
+		if m, err := links.GetURLMappingByShortCode("promo"); err != nil || m.OriginalURL != "https://example.com" {
+			t.Fatalf("got %+v, %v", m, err)
+		}
+	}
+	if backing.lookups != 2 {
+		t.Errorf("lookups reached the store %d times, want 2", backing.lookups)
+	}
+
+	// Deleting drops the positive entry.
+	links.DeleteURLMapping(1, "promo")
+	if _, err := links.GetURLMappingByShortCode("promo"); !errors.Is(err, ErrURLNotFound) {
+		t.Errorf("deleted mapping still served: %v", err)
+	}
+
+	// Protected mappings, with their password hashes, are never cached.
+	cache := NewLRUCache(100)
+	links = NewCachedLinkStore(backing, cache, time.Minute, time.Minute)
+	links.SaveURLMapping(models.URLMapping{UserID: 1, ShortCode: "vault", OriginalURL: "https://example.com/vault",
+		PasswordHash: "hash", Protected: true})
+	for i := 0; i < 2; i++ {
+		if m, err := links.GetURLMappingByShortCode("vault"); err != nil || m.PasswordHash != "hash" {
+			t.Fatalf("got %+v, %v", m, err)
+		}
+	}
+	if _, ok, _ := cache.Get("vault"); ok {
+		t.Error("protected mapping was cached")
+	}
+	if backing.lookups != 5 {
+		t.Errorf("lookups reached the store %d times, want 5", backing.lookups)
+	}
+}
+
+// countingUsers counts ID lookups reaching the wrapped store.
+type countingUsers struct {
+	*MemoryStore
+	lookups int
+}
+
+func (c *countingUsers) GetUserByID(id int) (models.User, error) {
+	c.lookups++
+	return c.MemoryStore.GetUserByID(id)
+}
+
+func TestCachedUserStore(t *testing.T) {
+	backing := &countingUsers{MemoryStore: NewMemoryStore()}
+	backing.SaveUser(models.User{Email: "a@example.com", Password: "$2a$10$secret-hash"})
+	cache := NewLRUCache(10)
+	users := NewCachedUserStore(backing, cache, time.Minute)
+
+	// Password hashes are left out, of the cache entries too
+	for i := 0; i < 3; i++ {
+		user, err := users.GetUserByID(1)
+		if err != nil || user.Email != "a@example.com" || user.Password != "" {
+			t.Fatalf("GetUserByID = %+v, %v", user, err)
+		}
+	}
+	if backing.lookups != 1 {
+		t.Errorf("backing store saw %d lookups, want 1", backing.lookups)
+	}
+	if b, ok, _ := cache.Get("1"); !ok || bytes.Contains(b, []byte("secret-hash")) {
+		t.Errorf("cache entry %q, %v holds the password hash", b, ok)
+	}
+	if user, _ := users.GetUserByEmail("a@example.com"); user.Password != "$2a$10$secret-hash" {
+		t.Errorf("GetUserByEmail returned password %q", user.Password)
+	}
+	if _, err := users.GetUserByID(2); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("unknown user returned %v", err)
+	}
+}
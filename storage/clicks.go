@@ -0,0 +1,99 @@
+// storage/clicks.go
+package storage
+
+import (
+	"fmt"
+	"time"
+	"url-shortener/models"
+)
+
+// clickDimensionColumns maps the accepted dimensions to clicks columns.
+var clickDimensionColumns = map[string]string{
+	DimensionReferrer: "referrer_host",
+	DimensionBrowser:  "browser",
+	DimensionCountry:  "country",
+}
+
+// RecordClick appends a click to the clicks table.
+func (s *PostgresStore) RecordClick(click models.Click) error {
+	query := `INSERT INTO clicks (short_code, clicked_at, referrer, referrer_host, user_agent, browser, ip, accept_language, country)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := s.db.Exec(query, click.ShortCode, click.ClickedAt, click.Referrer, click.ReferrerHost, click.UserAgent,
+		click.Browser, click.IP, click.AcceptLanguage, click.Country)
+	return err
+}
+
+// DeleteClicks drops the clicks on shortCode.
+func (s *PostgresStore) DeleteClicks(shortCode string) error {
+	_, err := s.db.Exec(`DELETE FROM clicks WHERE short_code = $1`, shortCode)
+	return err
+}
+
+// ClickSeries counts the clicks on shortCode since the given time, grouped
+// into UTC buckets.
+func (s *PostgresStore) ClickSeries(shortCode, bucket string, since time.Time) ([]models.ClickBucket, error) {
+	if bucket != BucketHour && bucket != BucketDay && bucket != BucketWeek {
+		return nil, fmt.Errorf("unknown bucket %q", bucket)
+	}
+	query := `SELECT date_trunc($2, clicked_at AT TIME ZONE 'UTC') AS bucket, COUNT(*)
+		FROM clicks WHERE short_code = $1 AND clicked_at >= $3
+		GROUP BY bucket ORDER BY bucket`
+	rows, err := s.db.Query(query, shortCode, bucket, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var series []models.ClickBucket
+	for rows.Next() {
+		var b models.ClickBucket
+		if err := rows.Scan(&b.Start, &b.Count); err != nil {
+			return nil, err
+		}
+		b.Start = b.Start.UTC()
+		series = append(series, b)
+	}
+	return series, rows.Err()
+}
+
+// TopClickValues returns the most common values of a dimension among the
+// clicks on shortCode since the given time.
+func (s *PostgresStore) TopClickValues(shortCode, dimension string, since time.Time, limit int) ([]models.ClickCount, error) {
+	column, ok := clickDimensionColumns[dimension]
+	if !ok {
+		return nil, fmt.Errorf("unknown dimension %q", dimension)
+	}
+	query := `SELECT ` + column + `, COUNT(*) AS n FROM clicks
+		WHERE short_code = $1 AND clicked_at >= $2
+		GROUP BY ` + column + ` ORDER BY n DESC, ` + column + ` LIMIT $3`
+	rows, err := s.db.Query(query, shortCode, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []models.ClickCount
+	for rows.Next() {
+		var c models.ClickCount
+		if err := rows.Scan(&c.Value, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// TruncateToBucket returns the start of the UTC bucket containing t. Weeks
+// start on Monday, matching PostgreSQL's date_trunc.
+func TruncateToBucket(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+	switch bucket {
+	case BucketHour:
+		return t.Truncate(time.Hour)
+	case BucketWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+}
@@ -5,13 +5,14 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"url-shortener/config"
 
 	_ "github.com/lib/pq" // PostgreSQL driver
 )
 
-func InitDB(dataSourceName string) {
-	var err error
-	db, err = sql.Open("postgres", dataSourceName)
+// InitDB opens and verifies the PostgreSQL connection.
+func InitDB(cfg config.DatabaseConfig) *sql.DB {
+	db, err := sql.Open("postgres", cfg.DSN())
 	if err != nil {
 		log.Fatalf("Error opening database: %q", err)
 	}
@@ -21,4 +22,5 @@ func InitDB(dataSourceName string) {
 	}
 
 	fmt.Println("Connected to the database successfully!")
+	return db
 }
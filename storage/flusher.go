@@ -0,0 +1,56 @@
+// storage/flusher.go
+package storage
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// VisitFlusher periodically moves buffered visit counts into a LinkStore.
+type VisitFlusher struct {
+	Buffer   VisitBuffer
+	Links    LinkStore
+	Interval time.Duration
+}
+
+// Run flushes every Interval until ctx is cancelled, then flushes once more.
+func (f *VisitFlusher) Run(ctx context.Context) {
+	ticker := time.NewTicker(f.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			f.Flush()
+			return
+		case <-ticker.C:
+			f.Flush()
+		}
+	}
+}
+
+// Flush writes the currently buffered visits. Counts that fail to be written
+// are returned to the buffer so they are retried on the next flush.
+func (f *VisitFlusher) Flush() {
+	counts, err := f.Buffer.DrainBufferedVisits()
+	if err != nil {
+		log.Printf("Error draining buffered visits: %v", err)
+		return
+	}
+	if len(counts) == 0 {
+		return
+	}
+	if err := f.Links.AddURLVisitCounts(counts); err != nil {
+		log.Printf("Error flushing %d buffered visit counts: %v", len(counts), err)
+		f.requeue(counts)
+	}
+}
+
+func (f *VisitFlusher) requeue(counts map[string]int) {
+	for code, n := range counts {
+		if err := f.Buffer.BufferVisits(code, n); err != nil {
+			log.Printf("Error re-buffering %d visits to %s: %v", n, code, err)
+		}
+	}
+}
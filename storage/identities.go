@@ -0,0 +1,34 @@
+// storage/identities.go
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"url-shortener/models"
+)
+
+var (
+	ErrIdentityNotFound = errors.New("identity not found")
+	ErrIdentityTaken    = errors.New("identity is already linked")
+)
+
+// GetIdentity retrieves the identity for a provider account.
+func (s *PostgresStore) GetIdentity(issuer, subject string) (models.Identity, error) {
+	identity := models.Identity{Issuer: issuer, Subject: subject}
+	query := `SELECT user_id, email, created_at FROM user_identities WHERE issuer = $1 AND subject = $2`
+	err := s.db.QueryRow(query, issuer, subject).Scan(&identity.UserID, &identity.Email, &identity.CreatedAt)
+	if err == sql.ErrNoRows {
+		return identity, ErrIdentityNotFound
+	}
+	return identity, err
+}
+
+// LinkIdentity inserts a new identity.
+func (s *PostgresStore) LinkIdentity(identity models.Identity) error {
+	query := `INSERT INTO user_identities (issuer, subject, user_id, email, created_at) VALUES ($1, $2, $3, $4, $5)`
+	_, err := s.db.Exec(query, identity.Issuer, identity.Subject, identity.UserID, identity.Email, identity.CreatedAt)
+	if isUniqueViolation(err, "user_identities_pkey") {
+		return ErrIdentityTaken
+	}
+	return err
+}
@@ -0,0 +1,37 @@
+// storage/jobreaper.go
+package storage
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// JobReaper periodically marks the bulk jobs left behind by server
+// instances that stopped or crashed as interrupted: those queued or running
+// that weren't updated for BulkJobTimeout, although their runners update
+// them every BulkJobHeartbeat.
+type JobReaper struct {
+	Jobs JobStore
+}
+
+// Run looks for abandoned jobs every BulkJobHeartbeat until ctx is
+// cancelled, starting with those of the previous run of this instance.
+func (r *JobReaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(BulkJobHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		n, err := r.Jobs.InterruptBulkJobs(time.Now().Add(-BulkJobTimeout))
+		if err != nil {
+			log.Printf("Error interrupting abandoned bulk jobs: %v", err)
+		} else if n > 0 {
+			log.Printf("Marked %d abandoned bulk jobs as interrupted", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
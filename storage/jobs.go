@@ -0,0 +1,82 @@
+// storage/jobs.go
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+	"url-shortener/models"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+const (
+	// BulkJobHeartbeat is how often runners update their jobs, at least.
+	BulkJobHeartbeat = time.Minute
+	// BulkJobTimeout is how long a job may go without updates before its
+	// runner is presumed gone, with the server instance it ran on.
+	BulkJobTimeout = 5 * BulkJobHeartbeat
+)
+
+// CreateBulkJob inserts a new job.
+func (s *PostgresStore) CreateBulkJob(job models.BulkJob) error {
+	query := `INSERT INTO bulk_jobs (id, user_id, kind, status, total, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $6)`
+	_, err := s.db.Exec(query, job.ID, job.UserID, job.Kind, job.Status, job.Total, job.CreatedAt)
+	return err
+}
+
+// GetBulkJob retrieves a job with its results.
+func (s *PostgresStore) GetBulkJob(id string) (models.BulkJob, error) {
+	job := models.BulkJob{ID: id}
+	var results []byte
+	var finishedAt sql.NullTime
+	query := `SELECT user_id, kind, status, total, processed, succeeded, failed, results, created_at, updated_at, finished_at
+		FROM bulk_jobs WHERE id = $1`
+	err := s.db.QueryRow(query, id).Scan(&job.UserID, &job.Kind, &job.Status, &job.Total, &job.Processed,
+		&job.Succeeded, &job.Failed, &results, &job.CreatedAt, &job.UpdatedAt, &finishedAt)
+	if err == sql.ErrNoRows {
+		return job, ErrJobNotFound
+	} else if err != nil {
+		return job, err
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	if results != nil {
+		if err := json.Unmarshal(results, &job.Results); err != nil {
+			return job, err
+		}
+	}
+	return job, nil
+}
+
+// UpdateBulkJob writes the progress of a job, and its results if set.
+func (s *PostgresStore) UpdateBulkJob(job models.BulkJob) error {
+	// Progress updates leave the results column alone
+	var results *string
+	if job.Results != nil {
+		b, err := json.Marshal(job.Results)
+		if err != nil {
+			return err
+		}
+		s := string(b)
+		results = &s
+	}
+	query := `UPDATE bulk_jobs SET status = $2, processed = $3, succeeded = $4, failed = $5,
+		results = COALESCE($6::jsonb, results), finished_at = $7, updated_at = NOW() WHERE id = $1`
+	return s.execOne(query, ErrJobNotFound, job.ID, job.Status, job.Processed, job.Succeeded, job.Failed, results, job.FinishedAt)
+}
+
+// InterruptBulkJobs marks the queued and running jobs last updated before
+// the given time as interrupted.
+func (s *PostgresStore) InterruptBulkJobs(before time.Time) (int, error) {
+	query := `UPDATE bulk_jobs SET status = $1, finished_at = NOW(), updated_at = NOW()
+		WHERE status IN ($2, $3) AND updated_at < $4`
+	res, err := s.db.Exec(query, models.JobInterrupted, models.JobQueued, models.JobRunning, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,203 @@
+// storage/listing.go
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"url-shortener/models"
+)
+
+// Sort keys accepted by URLQuery.Sort.
+const (
+	SortCreated = "created"
+	SortVisits  = "visits"
+)
+
+// Expiry states accepted by URLQuery.Expiry.
+const (
+	ExpiryActive  = "active"
+	ExpiryExpired = "expired"
+	// ExpiryNever matches links with neither an expiry time nor a visit limit.
+	ExpiryNever = "never"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// URLQuery selects a page of URL mappings: a user's personal mappings, or a
+// workspace's when WorkspaceID is set. Empty filters match everything.
+type URLQuery struct {
+	UserID      int
+	WorkspaceID int
+	// Search matches a substring of the original URL or short code,
+	// ignoring case.
+	Search string
+	// Domain matches the host of the original URL, ignoring case.
+	Domain string
+	// Tag matches links with this normalized tag.
+	Tag string
+	// Expiry is one of the Expiry constants, evaluated at Now.
+	Expiry string
+	Now    time.Time
+	// Sort is SortCreated or SortVisits; ties are broken by short code.
+	Sort      string
+	Ascending bool
+	// Cursor is the NextCursor of the previous page, or empty for the first.
+	Cursor string
+	Limit  int
+}
+
+// URLPage is one page of a URL mapping listing.
+type URLPage struct {
+	Items []models.URLMapping `json:"items"`
+	// Total counts the mappings matching the query on all pages.
+	Total int `json:"total"`
+	// NextCursor fetches the following page; it is empty on the last one.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// urlCursor is the position after the last item of a page. It carries the
+// sort order so that it can't be used with another.
+type urlCursor struct {
+	Sort      string    `json:"s"`
+	Ascending bool      `json:"a,omitempty"`
+	CreatedAt time.Time `json:"c"`
+	Visits    int       `json:"v,omitempty"`
+	ShortCode string    `json:"k"`
+}
+
+// encodeURLCursor returns the cursor for the page after last.
+func encodeURLCursor(q URLQuery, last models.URLMapping) string {
+	c := urlCursor{Sort: q.Sort, Ascending: q.Ascending, ShortCode: last.ShortCode}
+	if q.Sort == SortVisits {
+		c.Visits = last.VisitCount
+	} else {
+		c.CreatedAt = last.CreatedAt
+	}
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeURLCursor parses q.Cursor, returning nil for the first page.
+func decodeURLCursor(q URLQuery) (*urlCursor, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+	var c urlCursor
+	b, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil || json.Unmarshal(b, &c) != nil || c.ShortCode == "" || c.Sort != q.Sort || c.Ascending != q.Ascending {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// after reports whether m sorts after the cursor position.
+func (c *urlCursor) after(m models.URLMapping) bool {
+	var cmp int
+	if c.Sort == SortVisits {
+		cmp = m.VisitCount - c.Visits
+	} else if m.CreatedAt.Before(c.CreatedAt) {
+		cmp = -1
+	} else if m.CreatedAt.After(c.CreatedAt) {
+		cmp = 1
+	}
+	if cmp == 0 {
+		cmp = strings.Compare(m.ShortCode, c.ShortCode)
+	}
+	if c.Ascending {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+// ListURLMappings returns a page of the mappings matching q and their total.
+func (s *PostgresStore) ListURLMappings(q URLQuery) (URLPage, error) {
+	cursor, err := decodeURLCursor(q)
+	if err != nil {
+		return URLPage{}, err
+	}
+
+	var conds []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if q.WorkspaceID != 0 {
+		conds = append(conds, "workspace_id = "+arg(q.WorkspaceID))
+	} else {
+		conds = append(conds, "user_id = "+arg(q.UserID)+" AND workspace_id IS NULL")
+	}
+	if q.Search != "" {
+		p := arg("%" + escapeLike(q.Search) + "%")
+		conds = append(conds, "(original_url ILIKE "+p+" OR shortened_url ILIKE "+p+")")
+	}
+	if q.Tag != "" {
+		conds = append(conds, "EXISTS (SELECT 1 FROM url_tags WHERE url_tags.url_id = urls.id AND url_tags.tag = "+arg(q.Tag)+")")
+	}
+	if q.Domain != "" {
+		conds = append(conds, `lower(substring(original_url FROM '^[^:]+://(?:[^@/]*@)?([^/:?#]+)')) = lower(`+arg(q.Domain)+`)`)
+	}
+	switch q.Expiry {
+	case ExpiryActive:
+		conds = append(conds, "NOT ("+expiredAt(arg(q.Now))+")")
+	case ExpiryExpired:
+		conds = append(conds, "("+expiredAt(arg(q.Now))+")")
+	case ExpiryNever:
+		conds = append(conds, "expires_at IS NULL AND max_visits IS NULL")
+	}
+	where := strings.Join(conds, " AND ")
+
+	var page URLPage
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM urls WHERE `+where, args...).Scan(&page.Total); err != nil {
+		return URLPage{}, err
+	}
+
+	key, op, dir := "created_at", "<", "DESC"
+	if q.Sort == SortVisits {
+		key = "visit_count"
+	}
+	if q.Ascending {
+		op, dir = ">", "ASC"
+	}
+	if cursor != nil {
+		var value interface{} = cursor.CreatedAt
+		if q.Sort == SortVisits {
+			value = cursor.Visits
+		}
+		where += " AND (" + key + ", shortened_url) " + op + " (" + arg(value) + ", " + arg(cursor.ShortCode) + ")"
+	}
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE ` + where +
+		` ORDER BY ` + key + ` ` + dir + `, shortened_url ` + dir + ` LIMIT ` + arg(q.Limit+1)
+	page.Items, err = s.queryURLMappings(query, args...)
+	if err != nil {
+		return URLPage{}, err
+	}
+	return paginate(q, page), nil
+}
+
+// paginate trims page.Items, which holds up to one item more than q.Limit,
+// to the limit and sets NextCursor if there was more.
+func paginate(q URLQuery, page URLPage) URLPage {
+	if len(page.Items) > q.Limit {
+		page.Items = page.Items[:q.Limit]
+		page.NextCursor = encodeURLCursor(q, page.Items[q.Limit-1])
+	}
+	if page.Items == nil {
+		page.Items = []models.URLMapping{}
+	}
+	return page
+}
+
+// expiredAt is expiredCondition with the time as the given parameter.
+func expiredAt(param string) string {
+	return strings.ReplaceAll(expiredCondition, "$1", param)
+}
+
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,1118 @@
+// storage/memory.go
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"url-shortener/models"
+)
+
+// MemoryStore is an in-process implementation of UserStore, LinkStore and
+// GuestStore. It is intended for tests and local development.
+type MemoryStore struct {
+	mu    sync.Mutex
+	users []models.User
+	links []models.URLMapping
+	// archive holds mappings moved aside by PurgeExpiredURLMappings.
+	archive []models.URLMapping
+	guests  map[string]guestEntry
+	visits  map[string]int
+	seq     uint64
+	clicks  []models.Click
+	pending map[string]int
+	// revisions holds previous destinations keyed by short code.
+	revisions map[string][]models.URLRevision
+	sessions  map[string]models.Session
+	refresh   map[string]models.RefreshToken
+	apiKeys   []models.APIKey
+	accounts  map[string]models.AccountToken
+	// identities is keyed by issuer and subject.
+	identities  map[[2]string]models.Identity
+	workspaces  []models.Workspace
+	members     []models.WorkspaceMember
+	invitations []models.WorkspaceInvitation
+	jobs        map[string]models.BulkJob
+	now         func() time.Time
+}
+
+type guestEntry struct {
+	originalURL string
+	expiresAt   time.Time
+}
+
+// Stores returns a Stores using m for every backend.
+func (m *MemoryStore) Stores() Stores {
+	return Stores{Users: m, Links: m, Guests: m, Clicks: m, Visits: m, Sessions: m, APIKeys: m, Accounts: m, Identities: m, Workspaces: m, Jobs: m}
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		guests:     make(map[string]guestEntry),
+		visits:     make(map[string]int),
+		pending:    make(map[string]int),
+		revisions:  make(map[string][]models.URLRevision),
+		sessions:   make(map[string]models.Session),
+		refresh:    make(map[string]models.RefreshToken),
+		accounts:   make(map[string]models.AccountToken),
+		identities: make(map[[2]string]models.Identity),
+		jobs:       make(map[string]models.BulkJob),
+		now:        time.Now,
+	}
+}
+
+// SaveUser saves a new user, enforcing the unique email constraint.
+func (m *MemoryStore) SaveUser(user models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Email == user.Email {
+			return ErrEmailTaken
+		}
+	}
+	user.ID = len(m.users) + 1
+	m.users = append(m.users, user)
+	return nil
+}
+
+// GetUserByEmail retrieves a user by email.
+func (m *MemoryStore) GetUserByEmail(email string) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return models.User{}, ErrUserNotFound
+}
+
+// GetUserByID retrieves a user by ID.
+func (m *MemoryStore) GetUserByID(id int) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return models.User{}, ErrUserNotFound
+}
+
+// MarkEmailVerified records that the user confirmed their email address.
+func (m *MemoryStore) MarkEmailVerified(userID int, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, u := range m.users {
+		if u.ID == userID {
+			if u.EmailVerifiedAt == nil {
+				m.users[i].EmailVerifiedAt = &at
+			}
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
+// UpdatePassword replaces the user's password hash.
+func (m *MemoryStore) UpdatePassword(userID int, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, u := range m.users {
+		if u.ID == userID {
+			m.users[i].Password = passwordHash
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
+// SaveURLMapping saves a new URL mapping, enforcing the same unique
+// constraints as the urls table.
+func (m *MemoryStore) SaveURLMapping(urlMapping models.URLMapping) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, l := range m.links {
+		if l.ShortCode == urlMapping.ShortCode {
+			return ErrShortCodeTaken
+		}
+		if sameOwner(l, urlMapping) && l.OriginalURL == urlMapping.OriginalURL {
+			return fmt.Errorf("URL %q already shortened", urlMapping.OriginalURL)
+		}
+	}
+	urlMapping.VisitCount = 0
+	if urlMapping.CreatedAt.IsZero() {
+		urlMapping.CreatedAt = m.now()
+	}
+	urlMapping.UpdatedAt = urlMapping.CreatedAt
+	if urlMapping.Tags == nil {
+		urlMapping.Tags = []string{}
+	}
+	m.links = append(m.links, urlMapping)
+	m.deleteClicks(urlMapping.ShortCode)
+	return nil
+}
+
+// GetURLMappingByShortCode retrieves a URL mapping by the short code.
+func (m *MemoryStore) GetURLMappingByShortCode(shortCode string) (models.URLMapping, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, l := range m.links {
+		if l.ShortCode == shortCode {
+			return l, nil
+		}
+	}
+	return models.URLMapping{}, ErrURLNotFound
+}
+
+// sameOwner reports whether two mappings are both personal mappings of the
+// same user or both belong to the same workspace.
+func sameOwner(a, b models.URLMapping) bool {
+	return a.WorkspaceID == b.WorkspaceID && (a.WorkspaceID != 0 || a.UserID == b.UserID)
+}
+
+// GetURLMappingByOriginalURL retrieves a user's personal URL mapping by original URL.
+func (m *MemoryStore) GetURLMappingByOriginalURL(userID int, originalURL string) (models.URLMapping, error) {
+	return m.findURLMapping(models.URLMapping{UserID: userID, OriginalURL: originalURL})
+}
+
+// GetWorkspaceURLMappingByOriginalURL retrieves a workspace's URL mapping by original URL.
+func (m *MemoryStore) GetWorkspaceURLMappingByOriginalURL(workspaceID int, originalURL string) (models.URLMapping, error) {
+	return m.findURLMapping(models.URLMapping{WorkspaceID: workspaceID, OriginalURL: originalURL})
+}
+
+func (m *MemoryStore) findURLMapping(key models.URLMapping) (models.URLMapping, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, l := range m.links {
+		if sameOwner(l, key) && l.OriginalURL == key.OriginalURL {
+			return l, nil
+		}
+	}
+	return models.URLMapping{}, ErrURLNotFound
+}
+
+// GetUserURLMappings retrieves a user's personal URL mappings.
+func (m *MemoryStore) GetUserURLMappings(userID int) ([]models.URLMapping, error) {
+	return m.filterURLMappings(func(l models.URLMapping) bool { return l.UserID == userID && l.WorkspaceID == 0 })
+}
+
+// GetWorkspaceURLMappings retrieves the URL mappings of a workspace.
+func (m *MemoryStore) GetWorkspaceURLMappings(workspaceID int) ([]models.URLMapping, error) {
+	return m.filterURLMappings(func(l models.URLMapping) bool { return l.WorkspaceID == workspaceID })
+}
+
+func (m *MemoryStore) filterURLMappings(keep func(models.URLMapping) bool) ([]models.URLMapping, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var urlMappings []models.URLMapping
+	for _, l := range m.links {
+		if keep(l) {
+			urlMappings = append(urlMappings, l)
+		}
+	}
+	return urlMappings, nil
+}
+
+// IncrementURLVisitCount adds one visit to a user's URL mapping. It returns
+// ErrVisitLimitReached instead if the mapping has used up its maxVisits.
+func (m *MemoryStore) IncrementURLVisitCount(userID int, shortCode string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, l := range m.links {
+		if l.UserID == userID && l.ShortCode == shortCode {
+			if l.MaxVisits > 0 && l.VisitCount >= l.MaxVisits {
+				return ErrVisitLimitReached
+			}
+			now := m.now()
+			m.links[i].VisitCount++
+			m.links[i].LastVisitedAt = &now
+			return nil
+		}
+	}
+	return ErrVisitLimitReached
+}
+
+// AddURLVisitCounts adds batched visit counts, keyed by short code.
+func (m *MemoryStore) AddURLVisitCounts(counts map[string]int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for i, l := range m.links {
+		if n := counts[l.ShortCode]; n > 0 {
+			m.links[i].VisitCount += n
+			m.links[i].LastVisitedAt = &now
+		}
+	}
+	return nil
+}
+
+// SetURLVisits overwrites the visit count and last visit time of a mapping.
+func (m *MemoryStore) SetURLVisits(shortCode string, visitCount int, lastVisitedAt *time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, l := range m.links {
+		if l.ShortCode == shortCode {
+			m.links[i].VisitCount, m.links[i].LastVisitedAt = visitCount, lastVisitedAt
+			return nil
+		}
+	}
+	return ErrURLNotFound
+}
+
+// GetURLVisitCount returns the visit count of a user's URL mapping.
+func (m *MemoryStore) GetURLVisitCount(userID int, shortCode string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, l := range m.links {
+		if l.UserID == userID && l.ShortCode == shortCode {
+			return l.VisitCount, nil
+		}
+	}
+	return 0, ErrURLNotFound
+}
+
+// UpdateURLMapping writes the mutable fields of a user's URL mapping and
+// records the previous destination when it changes.
+func (m *MemoryStore) UpdateURLMapping(urlMapping models.URLMapping) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	idx := -1
+	for i, l := range m.links {
+		if l.UserID == urlMapping.UserID && l.ShortCode == urlMapping.ShortCode {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return ErrURLNotFound
+	}
+	for i, l := range m.links {
+		if i != idx && sameOwner(l, m.links[idx]) && l.OriginalURL == urlMapping.OriginalURL {
+			return ErrDuplicateURL
+		}
+	}
+
+	l := &m.links[idx]
+	if l.OriginalURL != urlMapping.OriginalURL {
+		m.revisions[l.ShortCode] = append(m.revisions[l.ShortCode], models.URLRevision{OriginalURL: l.OriginalURL, ChangedAt: m.now()})
+		l.Preview = nil
+	}
+	l.OriginalURL = urlMapping.OriginalURL
+	l.RedirectType = urlMapping.RedirectType
+	l.Title = urlMapping.Title
+	l.Description = urlMapping.Description
+	l.OGTitle = urlMapping.OGTitle
+	l.OGDescription = urlMapping.OGDescription
+	l.OGImage = urlMapping.OGImage
+	l.PasswordHash = urlMapping.PasswordHash
+	l.Protected = urlMapping.PasswordHash != ""
+	l.Tags = append([]string{}, urlMapping.Tags...)
+	l.UpdatedAt = urlMapping.UpdatedAt
+	return nil
+}
+
+// SetURLPreview stores the preview fetched for a mapping, provided its
+// destination is still originalURL.
+func (m *MemoryStore) SetURLPreview(shortCode, originalURL string, preview models.LinkPreview) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, l := range m.links {
+		if l.ShortCode == shortCode && l.OriginalURL == originalURL {
+			m.links[i].Preview = &preview
+			return nil
+		}
+	}
+	return ErrURLNotFound
+}
+
+// GetURLRevisions lists the previous destinations of a user's URL mapping,
+// oldest first.
+func (m *MemoryStore) GetURLRevisions(userID int, shortCode string) ([]models.URLRevision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, l := range m.links {
+		if l.UserID == userID && l.ShortCode == shortCode {
+			return append([]models.URLRevision{}, m.revisions[shortCode]...), nil
+		}
+	}
+	return []models.URLRevision{}, nil
+}
+
+// DeleteURLMapping removes a user's URL mapping.
+func (m *MemoryStore) DeleteURLMapping(userID int, shortCode string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, l := range m.links {
+		if l.UserID == userID && l.ShortCode == shortCode {
+			m.links = append(m.links[:i], m.links[i+1:]...)
+			delete(m.revisions, shortCode)
+			m.deleteClicks(shortCode)
+			break
+		}
+	}
+	return nil
+}
+
+// PurgeExpiredURLMappings removes mappings that are expired at now and
+// returns how many were removed, archiving them when archive is true.
+func (m *MemoryStore) PurgeExpiredURLMappings(now time.Time, archive bool) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.links[:0]
+	purged := 0
+	for _, l := range m.links {
+		if !l.Expired(now) {
+			kept = append(kept, l)
+			continue
+		}
+		purged++
+		m.deleteClicks(l.ShortCode)
+		if archive {
+			m.archive = append(m.archive, l)
+		}
+	}
+	m.links = kept
+	return purged, nil
+}
+
+// ListURLMappings returns a page of the mappings matching q and their total.
+func (m *MemoryStore) ListURLMappings(q URLQuery) (URLPage, error) {
+	cursor, err := decodeURLCursor(q)
+	if err != nil {
+		return URLPage{}, err
+	}
+	matches, _ := m.filterURLMappings(func(l models.URLMapping) bool {
+		return matchesURLQuery(l, q)
+	})
+
+	sort.Slice(matches, func(i, j int) bool {
+		return (&urlCursor{Sort: q.Sort, Ascending: q.Ascending, CreatedAt: matches[i].CreatedAt,
+			Visits: matches[i].VisitCount, ShortCode: matches[i].ShortCode}).after(matches[j])
+	})
+	page := URLPage{Total: len(matches)}
+	for _, l := range matches {
+		if cursor == nil || cursor.after(l) {
+			page.Items = append(page.Items, l)
+		}
+		if len(page.Items) > q.Limit {
+			break
+		}
+	}
+	return paginate(q, page), nil
+}
+
+// matchesURLQuery applies the owner and filters of q to l, like the WHERE
+// clause of PostgresStore.ListURLMappings.
+func matchesURLQuery(l models.URLMapping, q URLQuery) bool {
+	if !sameOwner(l, models.URLMapping{UserID: q.UserID, WorkspaceID: q.WorkspaceID}) {
+		return false
+	}
+	if q.Search != "" {
+		search := strings.ToLower(q.Search)
+		if !strings.Contains(strings.ToLower(l.OriginalURL), search) && !strings.Contains(strings.ToLower(l.ShortCode), search) {
+			return false
+		}
+	}
+	if q.Tag != "" && !containsString(l.Tags, q.Tag) {
+		return false
+	}
+	if q.Domain != "" {
+		u, err := url.Parse(l.OriginalURL)
+		if err != nil || !strings.EqualFold(u.Hostname(), q.Domain) {
+			return false
+		}
+	}
+	switch q.Expiry {
+	case ExpiryActive:
+		return !l.Expired(q.Now)
+	case ExpiryExpired:
+		return l.Expired(q.Now)
+	case ExpiryNever:
+		return l.ExpiresAt == nil && l.MaxVisits == 0
+	}
+	return true
+}
+
+// GetShortCodeByURL retrieves a guest short code by its original URL.
+func (m *MemoryStore) GetShortCodeByURL(originalURL string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for code, e := range m.guests {
+		if e.originalURL == originalURL && m.live(e) {
+			return code, nil
+		}
+	}
+	return "", ErrURLNotFound
+}
+
+// StoreURLMapping stores a guest URL mapping that expires after expiration.
+// It returns ErrShortCodeTaken if the code already maps to a different URL.
+func (m *MemoryStore) StoreURLMapping(shortURLCode, originalURL string, expiration time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.guests[shortURLCode]; ok && m.live(e) && e.originalURL != originalURL {
+		return ErrShortCodeTaken
+	}
+	e := guestEntry{originalURL: originalURL}
+	if expiration > 0 {
+		e.expiresAt = m.now().Add(expiration)
+	}
+	m.guests[shortURLCode] = e
+	return nil
+}
+
+// RetrieveOriginalURL retrieves a guest original URL by its short code.
+func (m *MemoryStore) RetrieveOriginalURL(shortURLCode string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.guests[shortURLCode]
+	if !ok || !m.live(e) {
+		return "", ErrURLNotFound
+	}
+	return e.originalURL, nil
+}
+
+// IncrementVisitCount adds one visit to a guest short URL code.
+func (m *MemoryStore) IncrementVisitCount(shortURLCode string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.visits[shortURLCode]++
+	return nil
+}
+
+// GetVisitCount returns the number of visits to a guest short URL code.
+func (m *MemoryStore) GetVisitCount(shortURLCode string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.visits[shortURLCode], nil
+}
+
+func (m *MemoryStore) live(e guestEntry) bool {
+	return e.expiresAt.IsZero() || m.now().Before(e.expiresAt)
+}
+
+// NextID increments and returns the short code sequence counter.
+func (m *MemoryStore) NextID() (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.seq++
+	return m.seq, nil
+}
+
+// RecordClick appends a click to the event log.
+func (m *MemoryStore) RecordClick(click models.Click) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.clicks = append(m.clicks, click)
+	return nil
+}
+
+// DeleteClicks drops the clicks on shortCode.
+func (m *MemoryStore) DeleteClicks(shortCode string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteClicks(shortCode)
+	return nil
+}
+
+func (m *MemoryStore) deleteClicks(shortCode string) {
+	kept := m.clicks[:0]
+	for _, c := range m.clicks {
+		if c.ShortCode != shortCode {
+			kept = append(kept, c)
+		}
+	}
+	m.clicks = kept
+}
+
+// ClickSeries counts the clicks on shortCode since the given time, grouped
+// into UTC buckets.
+func (m *MemoryStore) ClickSeries(shortCode, bucket string, since time.Time) ([]models.ClickBucket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := make(map[time.Time]int)
+	for _, c := range m.clicks {
+		if c.ShortCode == shortCode && !c.ClickedAt.Before(since) {
+			counts[TruncateToBucket(c.ClickedAt, bucket)]++
+		}
+	}
+	series := make([]models.ClickBucket, 0, len(counts))
+	for start, n := range counts {
+		series = append(series, models.ClickBucket{Start: start, Count: n})
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].Start.Before(series[j].Start) })
+	return series, nil
+}
+
+// TopClickValues returns the most common values of a dimension among the
+// clicks on shortCode since the given time.
+func (m *MemoryStore) TopClickValues(shortCode, dimension string, since time.Time, limit int) ([]models.ClickCount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, c := range m.clicks {
+		if c.ShortCode != shortCode || c.ClickedAt.Before(since) {
+			continue
+		}
+		switch dimension {
+		case DimensionReferrer:
+			counts[c.ReferrerHost]++
+		case DimensionBrowser:
+			counts[c.Browser]++
+		case DimensionCountry:
+			counts[c.Country]++
+		default:
+			return nil, fmt.Errorf("unknown dimension %q", dimension)
+		}
+	}
+	top := make([]models.ClickCount, 0, len(counts))
+	for v, n := range counts {
+		top = append(top, models.ClickCount{Value: v, Count: n})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Value < top[j].Value
+	})
+	if len(top) > limit {
+		top = top[:limit]
+	}
+	return top, nil
+}
+
+// BufferVisits records n pending visits to a registered user's link.
+func (m *MemoryStore) BufferVisits(shortCode string, n int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pending[shortCode] += n
+	return nil
+}
+
+// DrainBufferedVisits returns and clears the pending visit counts.
+func (m *MemoryStore) DrainBufferedVisits() (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := m.pending
+	m.pending = make(map[string]int)
+	return counts, nil
+}
+
+// CreateSession stores a new session.
+func (m *MemoryStore) CreateSession(session models.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[session.ID] = session
+	return nil
+}
+
+// GetSession retrieves a session by ID.
+func (m *MemoryStore) GetSession(id string) (models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return models.Session{}, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// RevokeSession marks a session revoked.
+func (m *MemoryStore) RevokeSession(id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	if session.RevokedAt == nil {
+		session.RevokedAt = &at
+		m.sessions[id] = session
+	}
+	return nil
+}
+
+// RevokeUserSessions marks every session of a user revoked.
+func (m *MemoryStore) RevokeUserSessions(userID int, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, session := range m.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			session.RevokedAt = &at
+			m.sessions[id] = session
+		}
+	}
+	return nil
+}
+
+// SaveRefreshToken stores a new refresh token.
+func (m *MemoryStore) SaveRefreshToken(token models.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.refresh[token.Hash] = token
+	return nil
+}
+
+// UseRefreshToken marks a refresh token used and returns it.
+func (m *MemoryStore) UseRefreshToken(hash string, at time.Time) (models.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.refresh[hash]
+	if !ok {
+		return models.RefreshToken{}, ErrRefreshTokenNotFound
+	}
+	if token.UsedAt != nil {
+		return token, ErrRefreshTokenReused
+	}
+	token.UsedAt = &at
+	m.refresh[hash] = token
+	return token, nil
+}
+
+// CreateAPIKey stores a new API key.
+func (m *MemoryStore) CreateAPIKey(key models.APIKey) (models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key.ID = len(m.apiKeys) + 1
+	key.Scopes = append([]string(nil), key.Scopes...)
+	m.apiKeys = append(m.apiKeys, key)
+	return key, nil
+}
+
+// GetAPIKeyByHash retrieves an API key by the hash of its secret.
+func (m *MemoryStore) GetAPIKeyByHash(hash string) (models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range m.apiKeys {
+		if k.Hash == hash {
+			return k, nil
+		}
+	}
+	return models.APIKey{}, ErrAPIKeyNotFound
+}
+
+// ListAPIKeys returns a user's API keys, oldest first.
+func (m *MemoryStore) ListAPIKeys(userID int) ([]models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []models.APIKey
+	for _, k := range m.apiKeys {
+		if k.UserID == userID {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey marks one of a user's API keys revoked.
+func (m *MemoryStore) RevokeAPIKey(userID, id int, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, k := range m.apiKeys {
+		if k.ID == id && k.UserID == userID {
+			if k.RevokedAt == nil {
+				m.apiKeys[i].RevokedAt = &at
+			}
+			return nil
+		}
+	}
+	return ErrAPIKeyNotFound
+}
+
+// TouchAPIKey records when an API key was last used.
+func (m *MemoryStore) TouchAPIKey(id int, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, k := range m.apiKeys {
+		if k.ID == id {
+			m.apiKeys[i].LastUsedAt = &at
+			return nil
+		}
+	}
+	return ErrAPIKeyNotFound
+}
+
+// SaveAccountToken stores a new account token.
+func (m *MemoryStore) SaveAccountToken(token models.AccountToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.accounts[token.Hash] = token
+	return nil
+}
+
+// UseAccountToken marks an unexpired, unused account token used.
+func (m *MemoryStore) UseAccountToken(hash, purpose string, at time.Time) (models.AccountToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.accounts[hash]
+	if !ok || token.Purpose != purpose || token.UsedAt != nil || !at.Before(token.ExpiresAt) {
+		return models.AccountToken{}, ErrAccountTokenInvalid
+	}
+	token.UsedAt = &at
+	m.accounts[hash] = token
+	return token, nil
+}
+
+// DeleteAccountTokens drops a user's outstanding tokens for purpose.
+func (m *MemoryStore) DeleteAccountTokens(userID int, purpose string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, token := range m.accounts {
+		if token.UserID == userID && token.Purpose == purpose && token.UsedAt == nil {
+			delete(m.accounts, hash)
+		}
+	}
+	return nil
+}
+
+// GetIdentity returns the identity for a provider account.
+func (m *MemoryStore) GetIdentity(issuer, subject string) (models.Identity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	identity, ok := m.identities[[2]string{issuer, subject}]
+	if !ok {
+		return models.Identity{}, ErrIdentityNotFound
+	}
+	return identity, nil
+}
+
+// LinkIdentity stores a new identity.
+func (m *MemoryStore) LinkIdentity(identity models.Identity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := [2]string{identity.Issuer, identity.Subject}
+	if _, ok := m.identities[key]; ok {
+		return ErrIdentityTaken
+	}
+	m.identities[key] = identity
+	return nil
+}
+
+// CreateWorkspace stores a new workspace with its owner.
+func (m *MemoryStore) CreateWorkspace(workspace models.Workspace, ownerID int) (models.Workspace, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	workspace.ID = len(m.workspaces) + 1
+	workspace.Role = ""
+	m.workspaces = append(m.workspaces, workspace)
+	m.members = append(m.members, models.WorkspaceMember{
+		WorkspaceID: workspace.ID,
+		UserID:      ownerID,
+		Role:        models.RoleOwner,
+		JoinedAt:    workspace.CreatedAt,
+	})
+	workspace.Role = models.RoleOwner
+	return workspace, nil
+}
+
+// GetWorkspace retrieves a workspace by ID.
+func (m *MemoryStore) GetWorkspace(id int) (models.Workspace, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > len(m.workspaces) {
+		return models.Workspace{}, ErrWorkspaceNotFound
+	}
+	return m.workspaces[id-1], nil
+}
+
+// ListUserWorkspaces returns the workspaces a user belongs to.
+func (m *MemoryStore) ListUserWorkspaces(userID int) ([]models.Workspace, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	workspaces := []models.Workspace{}
+	for _, member := range m.members {
+		if member.UserID == userID {
+			w := m.workspaces[member.WorkspaceID-1]
+			w.Role = member.Role
+			workspaces = append(workspaces, w)
+		}
+	}
+	return workspaces, nil
+}
+
+// GetMember retrieves a user's membership of a workspace.
+func (m *MemoryStore) GetMember(workspaceID, userID int) (models.WorkspaceMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i := m.memberIndex(workspaceID, userID); i >= 0 {
+		return m.withEmail(m.members[i]), nil
+	}
+	return models.WorkspaceMember{}, ErrNotMember
+}
+
+// ListMembers returns a workspace's members in the order they joined.
+func (m *MemoryStore) ListMembers(workspaceID int) ([]models.WorkspaceMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := []models.WorkspaceMember{}
+	for _, member := range m.members {
+		if member.WorkspaceID == workspaceID {
+			members = append(members, m.withEmail(member))
+		}
+	}
+	return members, nil
+}
+
+// UpdateMemberRole changes a member's role.
+func (m *MemoryStore) UpdateMemberRole(workspaceID, userID int, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.memberIndex(workspaceID, userID)
+	if i < 0 {
+		return ErrNotMember
+	}
+	if role != models.RoleOwner && m.isLastOwner(i) {
+		return ErrLastOwner
+	}
+	m.members[i].Role = role
+	return nil
+}
+
+// RemoveMember removes a user from a workspace.
+func (m *MemoryStore) RemoveMember(workspaceID, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.memberIndex(workspaceID, userID)
+	if i < 0 {
+		return ErrNotMember
+	}
+	if m.isLastOwner(i) {
+		return ErrLastOwner
+	}
+	m.members = append(m.members[:i], m.members[i+1:]...)
+	return nil
+}
+
+func (m *MemoryStore) memberIndex(workspaceID, userID int) int {
+	for i, member := range m.members {
+		if member.WorkspaceID == workspaceID && member.UserID == userID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *MemoryStore) isLastOwner(i int) bool {
+	if m.members[i].Role != models.RoleOwner {
+		return false
+	}
+	for j, member := range m.members {
+		if j != i && member.WorkspaceID == m.members[i].WorkspaceID && member.Role == models.RoleOwner {
+			return false
+		}
+	}
+	return true
+}
+
+// withEmail fills in the member's email like the users join does.
+func (m *MemoryStore) withEmail(member models.WorkspaceMember) models.WorkspaceMember {
+	if member.UserID >= 1 && member.UserID <= len(m.users) {
+		member.Email = m.users[member.UserID-1].Email
+	}
+	return member
+}
+
+// CreateInvitation stores a new invitation.
+func (m *MemoryStore) CreateInvitation(inv models.WorkspaceInvitation) (models.WorkspaceInvitation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inv.ID = len(m.invitations) + 1
+	m.invitations = append(m.invitations, inv)
+	return inv, nil
+}
+
+// GetInvitationByHash retrieves an invitation by the hash of its token.
+func (m *MemoryStore) GetInvitationByHash(hash string) (models.WorkspaceInvitation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, inv := range m.invitations {
+		if inv.Hash == hash && inv.ID != 0 {
+			return inv, nil
+		}
+	}
+	return models.WorkspaceInvitation{}, ErrInvitationNotFound
+}
+
+// ListInvitations returns a workspace's pending invitations.
+func (m *MemoryStore) ListInvitations(workspaceID int) ([]models.WorkspaceInvitation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	invitations := []models.WorkspaceInvitation{}
+	for _, inv := range m.invitations {
+		if inv.ID != 0 && inv.WorkspaceID == workspaceID && inv.AcceptedAt == nil {
+			invitations = append(invitations, inv)
+		}
+	}
+	return invitations, nil
+}
+
+// DeleteInvitation withdraws a pending invitation. Deleted invitations keep
+// their slot, with a zero ID, so IDs stay positions.
+func (m *MemoryStore) DeleteInvitation(workspaceID, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > len(m.invitations) {
+		return ErrInvitationNotFound
+	}
+	inv := m.invitations[id-1]
+	if inv.ID == 0 || inv.WorkspaceID != workspaceID || inv.AcceptedAt != nil {
+		return ErrInvitationNotFound
+	}
+	m.invitations[id-1] = models.WorkspaceInvitation{}
+	return nil
+}
+
+// AcceptInvitation marks the invitation accepted and adds the member.
+func (m *MemoryStore) AcceptInvitation(id, userID int, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > len(m.invitations) {
+		return ErrInvitationInvalid
+	}
+	inv := &m.invitations[id-1]
+	if inv.ID == 0 || inv.AcceptedAt != nil || !at.Before(inv.ExpiresAt) {
+		return ErrInvitationInvalid
+	}
+	inv.AcceptedAt = &at
+	if m.memberIndex(inv.WorkspaceID, userID) < 0 {
+		m.members = append(m.members, models.WorkspaceMember{
+			WorkspaceID: inv.WorkspaceID,
+			UserID:      userID,
+			Role:        inv.Role,
+			JoinedAt:    at,
+		})
+	}
+	return nil
+}
+
+// CreateBulkJob stores a new job.
+func (m *MemoryStore) CreateBulkJob(job models.BulkJob) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.jobs[job.ID]; ok {
+		return fmt.Errorf("job %s already exists", job.ID)
+	}
+	job.UpdatedAt = job.CreatedAt
+	m.jobs[job.ID] = job
+	return nil
+}
+
+// GetBulkJob returns a job.
+func (m *MemoryStore) GetBulkJob(id string) (models.BulkJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return models.BulkJob{}, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// UpdateBulkJob writes the progress of a job, and its results if set.
+func (m *MemoryStore) UpdateBulkJob(job models.BulkJob) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.jobs[job.ID]
+	if !ok {
+		return ErrJobNotFound
+	}
+	stored.Status, stored.Processed, stored.Succeeded, stored.Failed = job.Status, job.Processed, job.Succeeded, job.Failed
+	stored.FinishedAt, stored.UpdatedAt = job.FinishedAt, m.now()
+	if job.Results != nil {
+		stored.Results = append([]models.BulkResult(nil), job.Results...)
+	}
+	m.jobs[job.ID] = stored
+	return nil
+}
+
+// InterruptBulkJobs marks the queued and running jobs last updated before
+// the given time as interrupted.
+func (m *MemoryStore) InterruptBulkJobs(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	n := 0
+	for id, job := range m.jobs {
+		if (job.Status == models.JobQueued || job.Status == models.JobRunning) && job.UpdatedAt.Before(before) {
+			job.Status, job.FinishedAt, job.UpdatedAt = models.JobInterrupted, &now, now
+			m.jobs[id] = job
+			n++
+		}
+	}
+	return n, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -4,18 +4,71 @@ package storage
 
 import (
 	"net/http"
+	"sync"
+	"time"
+	"url-shortener/config"
 
 	"golang.org/x/time/rate"
 )
 
-var limiter = rate.NewLimiter(1, 3) // 1 request per second
+// NewRateLimitMiddleware returns a middleware sharing one token bucket
+// between all requests it wraps.
+func NewRateLimitMiddleware(cfg config.RateLimitConfig) func(http.Handler) http.Handler {
+	limiter := rate.NewLimiter(rate.Limit(cfg.RequestsPerSecond), cfg.Burst)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !limiter.Allow() {
+				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// maxLimiterKeys is how many keys a keyed rate limit holds before dropping
+// those that have been idle long enough for their bucket to refill.
+const maxLimiterKeys = 10000
+
+type keyedLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewKeyedRateLimitMiddleware returns a middleware giving each key, as
+// returned by key for the request, a token bucket of its own.
+func NewKeyedRateLimitMiddleware(cfg config.RateLimitConfig, key func(*http.Request) string) func(http.Handler) http.Handler {
+	var mu sync.Mutex
+	limiters := make(map[string]*keyedLimiter)
+	refill := time.Duration(float64(cfg.Burst) / cfg.RequestsPerSecond * float64(time.Second))
+
+	allow := func(k string) bool {
+		mu.Lock()
+		defer mu.Unlock()
 
-func RateLimitMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !limiter.Allow() {
-			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
-			return
+		now := time.Now()
+		l, ok := limiters[k]
+		if !ok {
+			if len(limiters) >= maxLimiterKeys {
+				for k, l := range limiters {
+					if now.Sub(l.lastSeen) >= refill {
+						delete(limiters, k)
+					}
+				}
+			}
+			l = &keyedLimiter{limiter: rate.NewLimiter(rate.Limit(cfg.RequestsPerSecond), cfg.Burst)}
+			limiters[k] = l
 		}
-		next.ServeHTTP(w, r)
-	})
+		l.lastSeen = now
+		return l.limiter.AllowN(now, 1)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !allow(key(r)) {
+				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
 }
@@ -0,0 +1,42 @@
+// storage/reaper.go
+package storage
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Reaper periodically purges expired URL mappings from a LinkStore.
+type Reaper struct {
+	Links    LinkStore
+	Interval time.Duration
+	// Archive moves expired mappings to the archive instead of deleting them.
+	Archive bool
+}
+
+// Run purges expired mappings every Interval until ctx is cancelled.
+func (r *Reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.reap()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Reaper) reap() {
+	n, err := r.Links.PurgeExpiredURLMappings(time.Now(), r.Archive)
+	if err != nil {
+		log.Printf("Error purging expired URL mappings: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Purged %d expired URL mappings", n)
+	}
+}
@@ -5,9 +5,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
 	"strconv"
 	"time"
+	"url-shortener/config"
 
 	"github.com/go-redis/redis/v8"
 )
@@ -17,26 +17,17 @@ type RedisClient struct {
 	Client *redis.Client
 }
 
-var ErrURLNotFound = errors.New("URL not found")
+var (
+	ErrURLNotFound    = errors.New("URL not found")
+	ErrShortCodeTaken = errors.New("short code already in use")
+)
 
 // NewRedisClient creates a new Redis client.
-func NewRedisClient() *RedisClient {
-	// Retrieve Redis connection info from environment variables
-	redisAddr := os.Getenv("REDIS_ADDR")
-	redisPassword := os.Getenv("REDIS_PASSWORD")
-	redisDB := os.Getenv("REDIS_DB")
-
-	// Parse Redis DB index
-	dbIndex, err := strconv.Atoi(redisDB)
-	if err != nil {
-		dbIndex = 0
-	}
-
-	// Initialize the Redis client
+func NewRedisClient(cfg config.RedisConfig) *RedisClient {
 	client := redis.NewClient(&redis.Options{
-		Addr:     redisAddr,
-		Password: redisPassword,
-		DB:       dbIndex,
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
 	})
 
 	return &RedisClient{Client: client}
@@ -58,14 +49,25 @@ func (r *RedisClient) GetShortCodeByURL(originalURL string) (string, error) {
 }
 
 // StoreURLMapping stores the short URL code and the original URL in Redis.
+// It returns ErrShortCodeTaken if the code already maps to a different URL.
 func (r *RedisClient) StoreURLMapping(shortURLCode, originalURL string, expiration time.Duration) error {
 	ctx := context.Background()
 
-	// Use the short URL code as the key and the original URL as the value.
-	err := r.Client.Set(ctx, shortURLCode, originalURL, expiration).Err()
+	// Use the short URL code as the key and the original URL as the value,
+	// without overwriting another guest's mapping.
+	ok, err := r.Client.SetNX(ctx, shortURLCode, originalURL, expiration).Result()
 	if err != nil {
 		return fmt.Errorf("error storing URL mapping: %v", err)
 	}
+	if !ok {
+		existing, err := r.Client.Get(ctx, shortURLCode).Result()
+		if err != nil && err != redis.Nil {
+			return fmt.Errorf("error storing URL mapping: %v", err)
+		}
+		if existing != originalURL {
+			return ErrShortCodeTaken
+		}
+	}
 
 	// Use the original URL as the key and the short URL code as the value.---reverse map
 	err = r.Client.Set(ctx, "reverse:"+originalURL, shortURLCode, expiration).Err()
@@ -83,7 +85,7 @@ func (r *RedisClient) RetrieveOriginalURL(shortURLCode string) (string, error) {
 	// Retrieve the original URL from Redis using the short URL code as the key.
 	result, err := r.Client.Get(ctx, shortURLCode).Result()
 	if err == redis.Nil {
-		return "", fmt.Errorf("short URL code not found")
+		return "", ErrURLNotFound
 	} else if err != nil {
 		return "", fmt.Errorf("error retrieving original URL: %v", err)
 	}
@@ -91,6 +93,7 @@ func (r *RedisClient) RetrieveOriginalURL(shortURLCode string) (string, error) {
 	return result, nil
 }
 
+// IncrementVisitCount adds one visit to a guest short URL code.
 func (r *RedisClient) IncrementVisitCount(shortURLCode string) error {
 	ctx := context.Background()
 	// Increment the visit count using a separate key with a prefix like "visits:"
@@ -101,6 +104,7 @@ func (r *RedisClient) IncrementVisitCount(shortURLCode string) error {
 	return nil
 }
 
+// GetVisitCount returns the number of visits to a guest short URL code.
 func (r *RedisClient) GetVisitCount(shortURLCode string) (int, error) {
 	ctx := context.Background()
 	// Retrieve the visit count using the key with a prefix like "visits:"
@@ -118,3 +122,50 @@ func (r *RedisClient) GetVisitCount(shortURLCode string) (int, error) {
 	}
 	return count, nil
 }
+
+// NextID increments and returns the short code sequence counter.
+func (r *RedisClient) NextID() (uint64, error) {
+	ctx := context.Background()
+	id, err := r.Client.Incr(ctx, "shortcode:sequence").Result()
+	if err != nil {
+		return 0, fmt.Errorf("error incrementing short code sequence: %v", err)
+	}
+	return uint64(id), nil
+}
+
+// pendingVisitsKey is the Redis hash buffering registered links' visits.
+const pendingVisitsKey = "pending:visits"
+
+// drainScript reads and deletes a hash in one atomic step.
+var drainScript = redis.NewScript(`
+local counts = redis.call("HGETALL", KEYS[1])
+redis.call("DEL", KEYS[1])
+return counts
+`)
+
+// BufferVisits records n pending visits to a registered user's link.
+func (r *RedisClient) BufferVisits(shortCode string, n int) error {
+	ctx := context.Background()
+	if err := r.Client.HIncrBy(ctx, pendingVisitsKey, shortCode, int64(n)).Err(); err != nil {
+		return fmt.Errorf("error buffering visit: %v", err)
+	}
+	return nil
+}
+
+// DrainBufferedVisits returns and clears the pending visit counts.
+func (r *RedisClient) DrainBufferedVisits() (map[string]int, error) {
+	ctx := context.Background()
+	result, err := drainScript.Run(ctx, r.Client, []string{pendingVisitsKey}).StringSlice()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("error draining buffered visits: %v", err)
+	}
+	counts := make(map[string]int, len(result)/2)
+	for i := 0; i+1 < len(result); i += 2 {
+		n, err := strconv.Atoi(result[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("error converting buffered visit count to integer: %v", err)
+		}
+		counts[result[i]] = n
+	}
+	return counts, nil
+}
@@ -0,0 +1,93 @@
+// storage/sessions.go
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+	"url-shortener/models"
+)
+
+var (
+	ErrSessionNotFound      = errors.New("session not found")
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenReused   = errors.New("refresh token already used")
+)
+
+// CreateSession inserts a new session.
+func (s *PostgresStore) CreateSession(session models.Session) error {
+	query := `INSERT INTO sessions (id, user_id, created_at) VALUES ($1, $2, $3)`
+	_, err := s.db.Exec(query, session.ID, session.UserID, session.CreatedAt)
+	return err
+}
+
+// GetSession retrieves a session by ID.
+func (s *PostgresStore) GetSession(id string) (models.Session, error) {
+	var session models.Session
+	var revokedAt sql.NullTime
+	query := `SELECT id, user_id, created_at, revoked_at FROM sessions WHERE id = $1`
+	err := s.db.QueryRow(query, id).Scan(&session.ID, &session.UserID, &session.CreatedAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return session, ErrSessionNotFound
+	}
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+	return session, err
+}
+
+// RevokeSession marks a session revoked. Revoking it again keeps the
+// original time.
+func (s *PostgresStore) RevokeSession(id string, at time.Time) error {
+	query := `UPDATE sessions SET revoked_at = COALESCE(revoked_at, $2) WHERE id = $1`
+	result, err := s.db.Exec(query, id, at)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrSessionNotFound
+	}
+	return err
+}
+
+// RevokeUserSessions marks every active session of a user revoked.
+func (s *PostgresStore) RevokeUserSessions(userID int, at time.Time) error {
+	query := `UPDATE sessions SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL`
+	_, err := s.db.Exec(query, userID, at)
+	return err
+}
+
+// SaveRefreshToken inserts a new refresh token.
+func (s *PostgresStore) SaveRefreshToken(token models.RefreshToken) error {
+	query := `INSERT INTO refresh_tokens (token_hash, session_id, expires_at) VALUES ($1, $2, $3)`
+	_, err := s.db.Exec(query, token.Hash, token.SessionID, token.ExpiresAt)
+	return err
+}
+
+// UseRefreshToken marks a refresh token used and returns it. The update is
+// conditional so that two concurrent uses can't both succeed.
+func (s *PostgresStore) UseRefreshToken(hash string, at time.Time) (models.RefreshToken, error) {
+	token := models.RefreshToken{Hash: hash}
+	query := `UPDATE refresh_tokens SET used_at = $2 WHERE token_hash = $1 AND used_at IS NULL
+		RETURNING session_id, expires_at`
+	err := s.db.QueryRow(query, hash, at).Scan(&token.SessionID, &token.ExpiresAt)
+	if err == nil {
+		token.UsedAt = &at
+		return token, nil
+	}
+	if err != sql.ErrNoRows {
+		return token, err
+	}
+
+	var usedAt sql.NullTime
+	query = `SELECT session_id, expires_at, used_at FROM refresh_tokens WHERE token_hash = $1`
+	err = s.db.QueryRow(query, hash).Scan(&token.SessionID, &token.ExpiresAt, &usedAt)
+	if err == sql.ErrNoRows {
+		return token, ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return token, err
+	}
+	token.UsedAt = &usedAt.Time
+	return token, ErrRefreshTokenReused
+}
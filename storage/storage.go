@@ -3,103 +3,419 @@ package storage
 
 import (
 	"database/sql"
-	"url-shortener/models"
+	"encoding/json"
+	"errors"
 	"log"
+	"time"
+	"url-shortener/models"
+
+	"github.com/lib/pq"
 )
 
-var db *sql.DB
+var (
+	ErrUserNotFound      = errors.New("user not found")
+	ErrVisitLimitReached = errors.New("visit limit reached")
+	ErrDuplicateURL      = errors.New("URL already shortened")
+	ErrEmailTaken        = errors.New("email already registered")
+)
+
+// PostgresStore keeps users and their URL mappings in PostgreSQL.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore creates a PostgresStore backed by db.
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
 
 // SaveUser saves a new user to the PostgreSQL database.
+func (s *PostgresStore) SaveUser(user models.User) error {
+	// SQL query to insert a new user without specifying the ID
+	query := `INSERT INTO users (email, password) VALUES ($1, $2)`
+	_, err := s.db.Exec(query, user.Email, user.Password)
+	if isUniqueViolation(err, "users_email_key") {
+		return ErrEmailTaken
+	}
+	return err
+}
+
+// userColumns lists the users columns read by scanUser, in order.
+const userColumns = `id, email, password, email_verified_at`
 
-func SaveUser(user models.User) error {
-    // SQL query to insert a new user without specifying the ID
-    query := `INSERT INTO users (email, password) VALUES ($1, $2)`
-    _, err := db.Exec(query, user.Email, user.Password)
-    return err
+func scanUser(row rowScanner) (models.User, error) {
+	var user models.User
+	var verifiedAt sql.NullTime
+	err := row.Scan(&user.ID, &user.Email, &user.Password, &verifiedAt)
+	if err == sql.ErrNoRows {
+		return user, ErrUserNotFound
+	}
+	if verifiedAt.Valid {
+		user.EmailVerifiedAt = &verifiedAt.Time
+	}
+	return user, err
 }
 
 // GetUserByEmail retrieves a user by email from the PostgreSQL database.
-func GetUserByEmail(email string) (models.User, error) {
-	var user models.User
+func (s *PostgresStore) GetUserByEmail(email string) (models.User, error) {
 	// SQL query to fetch the user by email
-	query := `SELECT id, email, password FROM users WHERE email = $1`
-	err := db.QueryRow(query, email).Scan(&user.ID, &user.Email, &user.Password)
-	return user, err
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = $1`
+	return scanUser(s.db.QueryRow(query, email))
 }
 
-// SaveURLMapping saves a new URL mapping to the PostgreSQL database.
-func SaveURLMapping(urlMapping models.URLMapping) error {
-	// SQL query to insert a new URL
-	query := `INSERT INTO urls (user_id, original_url, shortened_url) VALUES ($1, $2, $3)`
-	_, err := db.Exec(query, urlMapping.UserID, urlMapping.OriginalURL, urlMapping.ShortCode)
-	return err
+// GetUserByID retrieves a user by ID from the PostgreSQL database.
+func (s *PostgresStore) GetUserByID(id int) (models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	return scanUser(s.db.QueryRow(query, id))
 }
 
-// GetUserURLMappings retrieves all URL mappings for a user from the PostgreSQL database.
-func GetUserURLMappings(userID int) ([]models.URLMapping, error) {
-    var urlMappings []models.URLMapping
-    query := `SELECT user_id, shortened_url, original_url, visit_count FROM urls WHERE user_id = $1`
-    rows, err := db.Query(query, userID)
-    if err != nil {
-        log.Printf("Error executing query: %v", err)
-        return nil, err
-    }
-    defer rows.Close()
-
-    for rows.Next() {
-        var urlMapping models.URLMapping
-        if err := rows.Scan(&urlMapping.UserID, &urlMapping.ShortCode, &urlMapping.OriginalURL, &urlMapping.VisitCount); err != nil {
-            return nil, err
-        }
-        urlMappings = append(urlMappings, urlMapping)
-    }
-    return urlMappings, nil
-}
-
-// GetURLMappingByOriginalURL retrieves a URL mapping by original URL and user ID from the PostgreSQL database.
-func GetURLMappingByOriginalURL(userID int, originalURL string) (models.URLMapping, error) {
-    var urlMapping models.URLMapping
-    query := `SELECT shortened_url FROM urls WHERE user_id = $1 AND original_url = $2`
-    err := db.QueryRow(query, userID, originalURL).Scan(&urlMapping.ShortCode)
-    if err != nil {
-        return urlMapping, err
-    }
-    urlMapping.OriginalURL = originalURL
-    urlMapping.UserID = userID
-    return urlMapping, nil
+// MarkEmailVerified records that the user confirmed their email address.
+// Verifying again keeps the original time.
+func (s *PostgresStore) MarkEmailVerified(userID int, at time.Time) error {
+	query := `UPDATE users SET email_verified_at = COALESCE(email_verified_at, $2) WHERE id = $1`
+	return s.execOne(query, ErrUserNotFound, userID, at)
 }
 
+// UpdatePassword replaces the user's password hash.
+func (s *PostgresStore) UpdatePassword(userID int, passwordHash string) error {
+	query := `UPDATE users SET password = $2 WHERE id = $1`
+	return s.execOne(query, ErrUserNotFound, userID, passwordHash)
+}
 
-// GetURLMappingByShortCode retrieves a URL mapping by the short code.
-func GetURLMappingByShortCode(shortCode string) (models.URLMapping, error) {
+// execOne runs an UPDATE or DELETE expected to affect a row, returning
+// notFound if it affected none.
+func (s *PostgresStore) execOne(query string, notFound error, args ...interface{}) error {
+	result, err := s.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return notFound
+	}
+	return err
+}
+
+// urlColumns lists the urls columns read by scanURLMapping, in order,
+// followed by the link's tags.
+const urlColumns = `user_id, workspace_id, shortened_url, original_url, visit_count, expires_at, max_visits, redirect_type,
+	title, description, created_at, updated_at, last_visited_at, preview, og_title, og_description, og_image,
+	password_hash, ARRAY(SELECT tag FROM url_tags WHERE url_tags.url_id = urls.id ORDER BY tag)`
+
+// expiredCondition matches urls rows past their expiry time ($1) or visit limit.
+const expiredCondition = `(expires_at IS NOT NULL AND expires_at <= $1) OR (max_visits IS NOT NULL AND visit_count >= max_visits)`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanURLMapping scans a row selected with urlColumns.
+func scanURLMapping(row rowScanner) (models.URLMapping, error) {
 	var urlMapping models.URLMapping
-	query := `SELECT user_id, original_url FROM urls WHERE shortened_url = $1`
-	err := db.QueryRow(query, shortCode).Scan(&urlMapping.UserID, &urlMapping.OriginalURL)
+	var expiresAt, lastVisitedAt sql.NullTime
+	var workspaceID, maxVisits, redirectType sql.NullInt64
+	var title, description, ogTitle, ogDescription, ogImage, passwordHash sql.NullString
+	var preview []byte
+	err := row.Scan(&urlMapping.UserID, &workspaceID, &urlMapping.ShortCode, &urlMapping.OriginalURL, &urlMapping.VisitCount,
+		&expiresAt, &maxVisits, &redirectType, &title, &description, &urlMapping.CreatedAt, &urlMapping.UpdatedAt, &lastVisitedAt,
+		&preview, &ogTitle, &ogDescription, &ogImage, &passwordHash, pq.Array(&urlMapping.Tags))
 	if err != nil {
 		return urlMapping, err
 	}
-	urlMapping.ShortCode = shortCode
+	if preview != nil {
+		urlMapping.Preview = new(models.LinkPreview)
+		if err := json.Unmarshal(preview, urlMapping.Preview); err != nil {
+			return urlMapping, err
+		}
+	}
+	if expiresAt.Valid {
+		urlMapping.ExpiresAt = &expiresAt.Time
+	}
+	if lastVisitedAt.Valid {
+		urlMapping.LastVisitedAt = &lastVisitedAt.Time
+	}
+	if urlMapping.Tags == nil {
+		urlMapping.Tags = []string{}
+	}
+	urlMapping.Title = title.String
+	urlMapping.Description = description.String
+	urlMapping.OGTitle = ogTitle.String
+	urlMapping.OGDescription = ogDescription.String
+	urlMapping.OGImage = ogImage.String
+	urlMapping.PasswordHash = passwordHash.String
+	urlMapping.Protected = passwordHash.Valid
+	urlMapping.WorkspaceID = int(workspaceID.Int64)
+	urlMapping.MaxVisits = int(maxVisits.Int64)
+	urlMapping.RedirectType = int(redirectType.Int64)
 	return urlMapping, nil
 }
 
-func IncrementURLVisitCount(userID int, shortCode string) error {
-    query := `UPDATE urls SET visit_count = visit_count + 1 WHERE user_id = $1 AND shortened_url = $2`
-    _, err := db.Exec(query, userID, shortCode)
-    return err
+// SaveURLMapping saves a new URL mapping to the PostgreSQL database.
+// It returns ErrShortCodeTaken if the short code is already in use.
+func (s *PostgresStore) SaveURLMapping(urlMapping models.URLMapping) error {
+	// SQL query to insert a new URL, dropping any clicks left under its code
+	// by an expired guest link
+	query := `WITH url AS (
+			INSERT INTO urls (user_id, original_url, shortened_url, expires_at, max_visits, redirect_type, workspace_id,
+				title, description, created_at, updated_at, og_title, og_description, og_image, password_hash)
+			VALUES ($1, $2, $3, $4, NULLIF($5, 0), NULLIF($6, 0), NULLIF($7, 0), NULLIF($8, ''), NULLIF($9, ''), $10, $10,
+				NULLIF($11, ''), NULLIF($12, ''), NULLIF($13, ''), NULLIF($15, ''))
+			RETURNING id
+		), stale_clicks AS (
+			DELETE FROM clicks WHERE short_code = $3
+		)
+		INSERT INTO url_tags (url_id, tag) SELECT url.id, unnest($14::text[]) FROM url`
+	_, err := s.db.Exec(query, urlMapping.UserID, urlMapping.OriginalURL, urlMapping.ShortCode, urlMapping.ExpiresAt,
+		urlMapping.MaxVisits, urlMapping.RedirectType, urlMapping.WorkspaceID, urlMapping.Title, urlMapping.Description,
+		urlMapping.CreatedAt, urlMapping.OGTitle, urlMapping.OGDescription, urlMapping.OGImage, pq.Array(urlMapping.Tags),
+		urlMapping.PasswordHash)
+	if isUniqueViolation(err, "urls_shortened_url_key") {
+		return ErrShortCodeTaken
+	}
+	return err
+}
+
+// GetUserURLMappings retrieves a user's personal URL mappings, leaving out
+// those they created in workspaces.
+func (s *PostgresStore) GetUserURLMappings(userID int) ([]models.URLMapping, error) {
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE user_id = $1 AND workspace_id IS NULL`
+	return s.queryURLMappings(query, userID)
+}
+
+// GetWorkspaceURLMappings retrieves the URL mappings of a workspace.
+func (s *PostgresStore) GetWorkspaceURLMappings(workspaceID int) ([]models.URLMapping, error) {
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE workspace_id = $1`
+	return s.queryURLMappings(query, workspaceID)
+}
+
+func (s *PostgresStore) queryURLMappings(query string, args ...interface{}) ([]models.URLMapping, error) {
+	var urlMappings []models.URLMapping
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		log.Printf("Error executing query: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		urlMapping, err := scanURLMapping(rows)
+		if err != nil {
+			return nil, err
+		}
+		urlMappings = append(urlMappings, urlMapping)
+	}
+	return urlMappings, rows.Err()
+}
+
+// GetURLMappingByOriginalURL retrieves a user's personal URL mapping by original URL.
+func (s *PostgresStore) GetURLMappingByOriginalURL(userID int, originalURL string) (models.URLMapping, error) {
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE user_id = $1 AND workspace_id IS NULL AND original_url = $2`
+	urlMapping, err := scanURLMapping(s.db.QueryRow(query, userID, originalURL))
+	if err == sql.ErrNoRows {
+		return urlMapping, ErrURLNotFound
+	}
+	return urlMapping, err
 }
 
+// GetWorkspaceURLMappingByOriginalURL retrieves a workspace's URL mapping by original URL.
+func (s *PostgresStore) GetWorkspaceURLMappingByOriginalURL(workspaceID int, originalURL string) (models.URLMapping, error) {
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE workspace_id = $1 AND original_url = $2`
+	urlMapping, err := scanURLMapping(s.db.QueryRow(query, workspaceID, originalURL))
+	if err == sql.ErrNoRows {
+		return urlMapping, ErrURLNotFound
+	}
+	return urlMapping, err
+}
 
-func GetURLVisitCount(userID int, shortCode string) (int, error) {
-    var visitCount int
-    query := `SELECT visit_count FROM urls WHERE user_id = $1 AND shortened_url = $2`
-    err := db.QueryRow(query, userID, shortCode).Scan(&visitCount)
-    return visitCount, err
+// GetURLMappingByShortCode retrieves a URL mapping by the short code.
+func (s *PostgresStore) GetURLMappingByShortCode(shortCode string) (models.URLMapping, error) {
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE shortened_url = $1`
+	urlMapping, err := scanURLMapping(s.db.QueryRow(query, shortCode))
+	if err == sql.ErrNoRows {
+		return urlMapping, ErrURLNotFound
+	}
+	return urlMapping, err
 }
 
+// IncrementURLVisitCount adds one visit to a user's URL mapping. It returns
+// ErrVisitLimitReached instead if the mapping has used up its maxVisits.
+func (s *PostgresStore) IncrementURLVisitCount(userID int, shortCode string) error {
+	query := `UPDATE urls SET visit_count = visit_count + 1, last_visited_at = NOW()
+		WHERE user_id = $1 AND shortened_url = $2 AND (max_visits IS NULL OR visit_count < max_visits)`
+	res, err := s.db.Exec(query, userID, shortCode)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrVisitLimitReached
+	}
+	return nil
+}
 
+// AddURLVisitCounts adds batched visit counts, keyed by short code, in a
+// single statement. Codes that no longer exist are ignored.
+func (s *PostgresStore) AddURLVisitCounts(counts map[string]int) error {
+	codes := make([]string, 0, len(counts))
+	increments := make([]int64, 0, len(counts))
+	for code, n := range counts {
+		codes = append(codes, code)
+		increments = append(increments, int64(n))
+	}
+	query := `UPDATE urls SET visit_count = visit_count + v.n, last_visited_at = NOW()
+		FROM (SELECT unnest($1::text[]) AS code, unnest($2::int[]) AS n) v
+		WHERE urls.shortened_url = v.code`
+	_, err := s.db.Exec(query, pq.Array(codes), pq.Array(increments))
+	return err
+}
+
+// SetURLVisits overwrites the visit count and last visit time of a mapping.
+func (s *PostgresStore) SetURLVisits(shortCode string, visitCount int, lastVisitedAt *time.Time) error {
+	return s.execOne(`UPDATE urls SET visit_count = $1, last_visited_at = $2 WHERE shortened_url = $3`, ErrURLNotFound,
+		visitCount, lastVisitedAt, shortCode)
+}
+
+// GetURLVisitCount returns the visit count of a user's URL mapping.
+func (s *PostgresStore) GetURLVisitCount(userID int, shortCode string) (int, error) {
+	var visitCount int
+	query := `SELECT visit_count FROM urls WHERE user_id = $1 AND shortened_url = $2`
+	err := s.db.QueryRow(query, userID, shortCode).Scan(&visitCount)
+	if err == sql.ErrNoRows {
+		return 0, ErrURLNotFound
+	}
+	return visitCount, err
+}
 
-func DeleteURLMapping(userID int, shortCode string) error {
-    query := `DELETE FROM urls WHERE user_id = $1 AND shortened_url = $2`
-    _, err := db.Exec(query, userID, shortCode)
-    return err
-}
\ No newline at end of file
+// UpdateURLMapping writes the mutable fields of a user's URL mapping and
+// records the previous destination in url_revisions when it changes.
+func (s *PostgresStore) UpdateURLMapping(urlMapping models.URLMapping) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	var previousURL string
+	query := `SELECT id, original_url FROM urls WHERE user_id = $1 AND shortened_url = $2 FOR UPDATE`
+	err = tx.QueryRow(query, urlMapping.UserID, urlMapping.ShortCode).Scan(&id, &previousURL)
+	if err == sql.ErrNoRows {
+		return ErrURLNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if previousURL != urlMapping.OriginalURL {
+		_, err = tx.Exec(`INSERT INTO url_revisions (url_id, original_url) VALUES ($1, $2)`, id, previousURL)
+		if err != nil {
+			return err
+		}
+	}
+	// The preview describes the previous destination, so it is dropped
+	_, err = tx.Exec(`UPDATE urls SET original_url = $1, redirect_type = NULLIF($2, 0), title = NULLIF($3, ''),
+		description = NULLIF($4, ''), updated_at = $5, preview = CASE WHEN original_url = $1 THEN preview END,
+		og_title = NULLIF($7, ''), og_description = NULLIF($8, ''), og_image = NULLIF($9, ''),
+		password_hash = NULLIF($10, '') WHERE id = $6`,
+		urlMapping.OriginalURL, urlMapping.RedirectType, urlMapping.Title, urlMapping.Description, urlMapping.UpdatedAt, id,
+		urlMapping.OGTitle, urlMapping.OGDescription, urlMapping.OGImage, urlMapping.PasswordHash)
+	if isUniqueViolation(err, "urls_user_original_url_key") || isUniqueViolation(err, "urls_workspace_original_url_key") {
+		return ErrDuplicateURL
+	} else if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM url_tags WHERE url_id = $1 AND NOT tag = ANY($2::text[])`, id, pq.Array(urlMapping.Tags))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO url_tags (url_id, tag) SELECT $1, unnest($2::text[]) ON CONFLICT DO NOTHING`, id, pq.Array(urlMapping.Tags))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetURLPreview stores the preview fetched for a mapping, provided its
+// destination is still originalURL. Otherwise it returns ErrURLNotFound.
+func (s *PostgresStore) SetURLPreview(shortCode, originalURL string, preview models.LinkPreview) error {
+	b, err := json.Marshal(preview)
+	if err != nil {
+		return err
+	}
+	return s.execOne(`UPDATE urls SET preview = $1 WHERE shortened_url = $2 AND original_url = $3`, ErrURLNotFound,
+		b, shortCode, originalURL)
+}
+
+// GetURLRevisions lists the previous destinations of a user's URL mapping,
+// oldest first.
+func (s *PostgresStore) GetURLRevisions(userID int, shortCode string) ([]models.URLRevision, error) {
+	query := `SELECT r.original_url, r.changed_at FROM url_revisions r
+		JOIN urls u ON u.id = r.url_id
+		WHERE u.user_id = $1 AND u.shortened_url = $2
+		ORDER BY r.changed_at, r.id`
+	rows, err := s.db.Query(query, userID, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []models.URLRevision{}
+	for rows.Next() {
+		var rev models.URLRevision
+		if err := rows.Scan(&rev.OriginalURL, &rev.ChangedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// DeleteURLMapping removes a user's URL mapping.
+func (s *PostgresStore) DeleteURLMapping(userID int, shortCode string) error {
+	query := `WITH deleted AS (
+			DELETE FROM urls WHERE user_id = $1 AND shortened_url = $2 RETURNING shortened_url
+		)
+		DELETE FROM clicks WHERE short_code IN (SELECT shortened_url FROM deleted)`
+	_, err := s.db.Exec(query, userID, shortCode)
+	return err
+}
+
+// PurgeExpiredURLMappings removes mappings that are expired at now, and
+// their clicks, and returns how many were removed. When archive is true the
+// rows are moved to urls_archive instead of being discarded; their clicks
+// are dropped either way, since the codes may be reused.
+func (s *PostgresStore) PurgeExpiredURLMappings(now time.Time, archive bool) (int, error) {
+	query := `WITH expired AS (
+			DELETE FROM urls WHERE ` + expiredCondition + ` RETURNING shortened_url
+		), expired_clicks AS (
+			DELETE FROM clicks WHERE short_code IN (SELECT shortened_url FROM expired)
+		)
+		SELECT COUNT(*) FROM expired`
+	if archive {
+		query = `WITH expired AS (
+			DELETE FROM urls WHERE ` + expiredCondition + `
+			RETURNING id, user_id, workspace_id, original_url, shortened_url, visit_count, expires_at, max_visits, redirect_type,
+				title, description, created_at, updated_at, last_visited_at, preview, og_title, og_description, og_image, password_hash,
+				ARRAY(SELECT tag FROM url_tags WHERE url_tags.url_id = urls.id ORDER BY tag) AS tags
+		), expired_clicks AS (
+			DELETE FROM clicks WHERE short_code IN (SELECT shortened_url FROM expired)
+		), archived AS (
+			INSERT INTO urls_archive (id, user_id, workspace_id, original_url, shortened_url, visit_count, expires_at, max_visits,
+				redirect_type, title, description, created_at, updated_at, last_visited_at, preview, og_title, og_description, og_image,
+				password_hash, tags)
+			SELECT id, user_id, workspace_id, original_url, shortened_url, visit_count, expires_at, max_visits, redirect_type,
+				title, description, created_at, updated_at, last_visited_at, preview, og_title, og_description, og_image, password_hash, tags
+			FROM expired
+		)
+		SELECT COUNT(*) FROM expired`
+	}
+	var n int
+	err := s.db.QueryRow(query, now).Scan(&n)
+	return n, err
+}
+
+// isUniqueViolation reports whether err is a PostgreSQL unique_violation on
+// the named constraint.
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
@@ -0,0 +1,224 @@
+// storage/store.go
+package storage
+
+import (
+	"time"
+	"url-shortener/models"
+	"url-shortener/utils"
+)
+
+// UserStore persists registered user accounts.
+type UserStore interface {
+	SaveUser(user models.User) error
+	GetUserByEmail(email string) (models.User, error)
+	GetUserByID(id int) (models.User, error)
+	MarkEmailVerified(userID int, at time.Time) error
+	UpdatePassword(userID int, passwordHash string) error
+}
+
+// LinkStore persists the URL mappings owned by registered users.
+type LinkStore interface {
+	SaveURLMapping(urlMapping models.URLMapping) error
+	GetURLMappingByShortCode(shortCode string) (models.URLMapping, error)
+	// GetURLMappingByOriginalURL and GetUserURLMappings only consider the
+	// user's personal mappings, not those they created in workspaces.
+	GetURLMappingByOriginalURL(userID int, originalURL string) (models.URLMapping, error)
+	GetUserURLMappings(userID int) ([]models.URLMapping, error)
+	GetWorkspaceURLMappingByOriginalURL(workspaceID int, originalURL string) (models.URLMapping, error)
+	GetWorkspaceURLMappings(workspaceID int) ([]models.URLMapping, error)
+	// ListURLMappings returns a page of the mappings matching q. It returns
+	// ErrInvalidCursor if q.Cursor wasn't issued for the same sort order.
+	ListURLMappings(q URLQuery) (URLPage, error)
+	IncrementURLVisitCount(userID int, shortCode string) error
+	AddURLVisitCounts(counts map[string]int) error
+	// SetURLVisits overwrites the visit count and last visit time of a
+	// mapping, as when restoring it from an export.
+	SetURLVisits(shortCode string, visitCount int, lastVisitedAt *time.Time) error
+	GetURLVisitCount(userID int, shortCode string) (int, error)
+	// UpdateURLMapping writes the mutable fields of an existing mapping,
+	// identified by its UserID and ShortCode, recording the previous
+	// destination when OriginalURL changes.
+	UpdateURLMapping(urlMapping models.URLMapping) error
+	GetURLRevisions(userID int, shortCode string) ([]models.URLRevision, error)
+	// SetURLPreview stores a fetched preview unless the mapping was deleted
+	// or its destination changed since, returning ErrURLNotFound then.
+	SetURLPreview(shortCode, originalURL string, preview models.LinkPreview) error
+	DeleteURLMapping(userID int, shortCode string) error
+	PurgeExpiredURLMappings(now time.Time, archive bool) (int, error)
+}
+
+// GuestStore holds the short-lived URL mappings created by guests.
+type GuestStore interface {
+	GetShortCodeByURL(originalURL string) (string, error)
+	StoreURLMapping(shortURLCode, originalURL string, expiration time.Duration) error
+	RetrieveOriginalURL(shortURLCode string) (string, error)
+	IncrementVisitCount(shortURLCode string) error
+	GetVisitCount(shortURLCode string) (int, error)
+}
+
+// Bucket sizes accepted by ClickStore.ClickSeries.
+const (
+	BucketHour = "hour"
+	BucketDay  = "day"
+	BucketWeek = "week"
+)
+
+// Dimensions accepted by ClickStore.TopClickValues.
+const (
+	DimensionReferrer = "referrer"
+	DimensionBrowser  = "browser"
+	DimensionCountry  = "country"
+)
+
+// ClickStore keeps the per-redirect event log used for analytics.
+type ClickStore interface {
+	RecordClick(click models.Click) error
+	// ClickSeries counts the clicks on shortCode since the given time,
+	// grouped into UTC buckets. Empty buckets are omitted.
+	ClickSeries(shortCode, bucket string, since time.Time) ([]models.ClickBucket, error)
+	// TopClickValues returns the most common values of a dimension among the
+	// clicks on shortCode since the given time, most frequent first.
+	TopClickValues(shortCode, dimension string, since time.Time, limit int) ([]models.ClickCount, error)
+	// DeleteClicks drops the clicks on shortCode. LinkStores drop those of
+	// the links they delete themselves; this is for guest links, which
+	// expire without a trace, when their code is issued again.
+	DeleteClicks(shortCode string) error
+}
+
+// VisitBuffer accumulates visits to registered users' links so they can be
+// written to the LinkStore in batches.
+type VisitBuffer interface {
+	BufferVisits(shortCode string, n int) error
+	// DrainBufferedVisits atomically returns and clears the pending counts.
+	DrainBufferedVisits() (map[string]int, error)
+}
+
+// SessionStore persists login sessions and their rotating refresh tokens.
+type SessionStore interface {
+	CreateSession(session models.Session) error
+	GetSession(id string) (models.Session, error)
+	RevokeSession(id string, at time.Time) error
+	RevokeUserSessions(userID int, at time.Time) error
+	SaveRefreshToken(token models.RefreshToken) error
+	// UseRefreshToken marks the token with the given hash as used and
+	// returns it. If it was already used, the token is returned together
+	// with ErrRefreshTokenReused.
+	UseRefreshToken(hash string, at time.Time) (models.RefreshToken, error)
+}
+
+// APIKeyStore persists users' API keys.
+type APIKeyStore interface {
+	// CreateAPIKey stores a new key and returns it with its ID set.
+	CreateAPIKey(key models.APIKey) (models.APIKey, error)
+	GetAPIKeyByHash(hash string) (models.APIKey, error)
+	ListAPIKeys(userID int) ([]models.APIKey, error)
+	RevokeAPIKey(userID, id int, at time.Time) error
+	TouchAPIKey(id int, at time.Time) error
+}
+
+// AccountTokenStore persists the single-use tokens of the email
+// verification and password reset flows.
+type AccountTokenStore interface {
+	SaveAccountToken(token models.AccountToken) error
+	// UseAccountToken marks the unexpired, unused token with the given hash
+	// and purpose used and returns it, or returns ErrAccountTokenInvalid.
+	UseAccountToken(hash, purpose string, at time.Time) (models.AccountToken, error)
+	// DeleteAccountTokens drops a user's outstanding tokens for purpose.
+	DeleteAccountTokens(userID int, purpose string) error
+}
+
+// IdentityStore persists the links between users and their accounts at
+// OpenID Connect providers.
+type IdentityStore interface {
+	// GetIdentity returns the identity for a provider account, or
+	// ErrIdentityNotFound.
+	GetIdentity(issuer, subject string) (models.Identity, error)
+	// LinkIdentity stores a new identity, or returns ErrIdentityTaken if the
+	// provider account is already linked.
+	LinkIdentity(identity models.Identity) error
+}
+
+// WorkspaceStore persists workspaces, their members and invitations.
+type WorkspaceStore interface {
+	// CreateWorkspace stores a new workspace with ownerID as its owner and
+	// returns it with its ID set.
+	CreateWorkspace(workspace models.Workspace, ownerID int) (models.Workspace, error)
+	GetWorkspace(id int) (models.Workspace, error)
+	// ListUserWorkspaces returns the workspaces a user belongs to, with Role
+	// set to the user's role.
+	ListUserWorkspaces(userID int) ([]models.Workspace, error)
+	// GetMember returns a user's membership of a workspace, or ErrNotMember.
+	GetMember(workspaceID, userID int) (models.WorkspaceMember, error)
+	ListMembers(workspaceID int) ([]models.WorkspaceMember, error)
+	// UpdateMemberRole and RemoveMember return ErrLastOwner rather than
+	// leave a workspace without owners.
+	UpdateMemberRole(workspaceID, userID int, role string) error
+	RemoveMember(workspaceID, userID int) error
+	// CreateInvitation stores a new invitation and returns it with its ID set.
+	CreateInvitation(invitation models.WorkspaceInvitation) (models.WorkspaceInvitation, error)
+	GetInvitationByHash(hash string) (models.WorkspaceInvitation, error)
+	// ListInvitations returns a workspace's invitations that were not
+	// accepted yet, oldest first.
+	ListInvitations(workspaceID int) ([]models.WorkspaceInvitation, error)
+	DeleteInvitation(workspaceID, id int) error
+	// AcceptInvitation marks a pending, unexpired invitation accepted and
+	// adds the user with its role, keeping the role of existing members. It
+	// returns ErrInvitationInvalid if the invitation can't be accepted.
+	AcceptInvitation(id, userID int, at time.Time) error
+}
+
+// JobStore persists bulk jobs.
+type JobStore interface {
+	CreateBulkJob(job models.BulkJob) error
+	// GetBulkJob returns a job, or ErrJobNotFound.
+	GetBulkJob(id string) (models.BulkJob, error)
+	// UpdateBulkJob writes a job's status, counts and finish time, and its
+	// results unless they are nil, and sets its update time to now.
+	UpdateBulkJob(job models.BulkJob) error
+	// InterruptBulkJobs marks the queued and running jobs last updated
+	// before the given time as interrupted, and returns how many there were.
+	InterruptBulkJobs(before time.Time) (int, error)
+}
+
+// Stores bundles the backends the handlers depend on.
+type Stores struct {
+	Users      UserStore
+	Links      LinkStore
+	Guests     GuestStore
+	Clicks     ClickStore
+	Visits     VisitBuffer
+	Sessions   SessionStore
+	APIKeys    APIKeyStore
+	Accounts   AccountTokenStore
+	Identities IdentityStore
+	Workspaces WorkspaceStore
+	Jobs       JobStore
+}
+
+var (
+	_ UserStore         = (*PostgresStore)(nil)
+	_ LinkStore         = (*PostgresStore)(nil)
+	_ GuestStore        = (*RedisClient)(nil)
+	_ UserStore         = (*MemoryStore)(nil)
+	_ LinkStore         = (*MemoryStore)(nil)
+	_ GuestStore        = (*MemoryStore)(nil)
+	_ ClickStore        = (*PostgresStore)(nil)
+	_ ClickStore        = (*MemoryStore)(nil)
+	_ VisitBuffer       = (*RedisClient)(nil)
+	_ VisitBuffer       = (*MemoryStore)(nil)
+	_ SessionStore      = (*PostgresStore)(nil)
+	_ SessionStore      = (*MemoryStore)(nil)
+	_ APIKeyStore       = (*PostgresStore)(nil)
+	_ APIKeyStore       = (*MemoryStore)(nil)
+	_ AccountTokenStore = (*PostgresStore)(nil)
+	_ AccountTokenStore = (*MemoryStore)(nil)
+	_ IdentityStore     = (*PostgresStore)(nil)
+	_ IdentityStore     = (*MemoryStore)(nil)
+	_ WorkspaceStore    = (*PostgresStore)(nil)
+	_ WorkspaceStore    = (*MemoryStore)(nil)
+	_ JobStore          = (*PostgresStore)(nil)
+	_ JobStore          = (*MemoryStore)(nil)
+
+	_ utils.Sequence = (*RedisClient)(nil)
+	_ utils.Sequence = (*MemoryStore)(nil)
+)
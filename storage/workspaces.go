@@ -0,0 +1,235 @@
+// storage/workspaces.go
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+	"url-shortener/models"
+)
+
+var (
+	ErrWorkspaceNotFound  = errors.New("workspace not found")
+	ErrNotMember          = errors.New("not a member of the workspace")
+	ErrLastOwner          = errors.New("a workspace needs at least one owner")
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrInvitationInvalid  = errors.New("invitation is invalid or has expired")
+)
+
+// lastOwnerCondition matches a workspace_members row ($1, $2) that is the
+// workspace's only owner.
+const lastOwnerCondition = `role = 'owner' AND (SELECT COUNT(*) FROM workspace_members
+	WHERE workspace_id = $1 AND role = 'owner') = 1`
+
+// CreateWorkspace inserts a workspace and its owner in one transaction.
+func (s *PostgresStore) CreateWorkspace(workspace models.Workspace, ownerID int) (models.Workspace, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return workspace, err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO workspaces (name, created_by, created_at) VALUES ($1, $2, $3) RETURNING id`
+	if err := tx.QueryRow(query, workspace.Name, ownerID, workspace.CreatedAt).Scan(&workspace.ID); err != nil {
+		return workspace, err
+	}
+	query = `INSERT INTO workspace_members (workspace_id, user_id, role, joined_at) VALUES ($1, $2, $3, $4)`
+	if _, err := tx.Exec(query, workspace.ID, ownerID, models.RoleOwner, workspace.CreatedAt); err != nil {
+		return workspace, err
+	}
+	workspace.Role = models.RoleOwner
+	return workspace, tx.Commit()
+}
+
+// GetWorkspace retrieves a workspace by ID.
+func (s *PostgresStore) GetWorkspace(id int) (models.Workspace, error) {
+	workspace := models.Workspace{ID: id}
+	query := `SELECT name, created_at FROM workspaces WHERE id = $1`
+	err := s.db.QueryRow(query, id).Scan(&workspace.Name, &workspace.CreatedAt)
+	if err == sql.ErrNoRows {
+		return workspace, ErrWorkspaceNotFound
+	}
+	return workspace, err
+}
+
+// ListUserWorkspaces returns the workspaces a user belongs to, by name.
+func (s *PostgresStore) ListUserWorkspaces(userID int) ([]models.Workspace, error) {
+	query := `SELECT w.id, w.name, w.created_at, m.role FROM workspaces w
+		JOIN workspace_members m ON m.workspace_id = w.id
+		WHERE m.user_id = $1 ORDER BY w.name, w.id`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workspaces := []models.Workspace{}
+	for rows.Next() {
+		var w models.Workspace
+		if err := rows.Scan(&w.ID, &w.Name, &w.CreatedAt, &w.Role); err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, w)
+	}
+	return workspaces, rows.Err()
+}
+
+// memberColumns lists the columns read by scanMember, in order, from
+// workspace_members m joined with users u.
+const memberColumns = `m.workspace_id, m.user_id, u.email, m.role, m.joined_at`
+
+func scanMember(row rowScanner) (models.WorkspaceMember, error) {
+	var m models.WorkspaceMember
+	err := row.Scan(&m.WorkspaceID, &m.UserID, &m.Email, &m.Role, &m.JoinedAt)
+	return m, err
+}
+
+// GetMember retrieves a user's membership of a workspace.
+func (s *PostgresStore) GetMember(workspaceID, userID int) (models.WorkspaceMember, error) {
+	query := `SELECT ` + memberColumns + ` FROM workspace_members m JOIN users u ON u.id = m.user_id
+		WHERE m.workspace_id = $1 AND m.user_id = $2`
+	member, err := scanMember(s.db.QueryRow(query, workspaceID, userID))
+	if err == sql.ErrNoRows {
+		return member, ErrNotMember
+	}
+	return member, err
+}
+
+// ListMembers returns a workspace's members in the order they joined.
+func (s *PostgresStore) ListMembers(workspaceID int) ([]models.WorkspaceMember, error) {
+	query := `SELECT ` + memberColumns + ` FROM workspace_members m JOIN users u ON u.id = m.user_id
+		WHERE m.workspace_id = $1 ORDER BY m.joined_at, m.user_id`
+	rows, err := s.db.Query(query, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.WorkspaceMember{}
+	for rows.Next() {
+		member, err := scanMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// UpdateMemberRole changes a member's role.
+func (s *PostgresStore) UpdateMemberRole(workspaceID, userID int, role string) error {
+	query := `UPDATE workspace_members SET role = $3
+		WHERE workspace_id = $1 AND user_id = $2 AND NOT ($3 <> 'owner' AND ` + lastOwnerCondition + `)`
+	return s.changeMember(query, workspaceID, userID, role)
+}
+
+// RemoveMember removes a user from a workspace.
+func (s *PostgresStore) RemoveMember(workspaceID, userID int) error {
+	query := `DELETE FROM workspace_members
+		WHERE workspace_id = $1 AND user_id = $2 AND NOT (` + lastOwnerCondition + `)`
+	return s.changeMember(query, workspaceID, userID)
+}
+
+// changeMember runs a query guarded by lastOwnerCondition and works out why
+// it affected no row.
+func (s *PostgresStore) changeMember(query string, workspaceID, userID int, args ...interface{}) error {
+	err := s.execOne(query, ErrLastOwner, append([]interface{}{workspaceID, userID}, args...)...)
+	if errors.Is(err, ErrLastOwner) {
+		if _, err := s.GetMember(workspaceID, userID); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// invitationColumns lists the workspace_invitations columns read by
+// scanInvitation, in order.
+const invitationColumns = `id, workspace_id, email, role, token_hash, invited_by, created_at, expires_at, accepted_at`
+
+func scanInvitation(row rowScanner) (models.WorkspaceInvitation, error) {
+	var inv models.WorkspaceInvitation
+	var invitedBy sql.NullInt64
+	var acceptedAt sql.NullTime
+	err := row.Scan(&inv.ID, &inv.WorkspaceID, &inv.Email, &inv.Role, &inv.Hash, &invitedBy,
+		&inv.CreatedAt, &inv.ExpiresAt, &acceptedAt)
+	inv.InvitedBy = int(invitedBy.Int64)
+	if acceptedAt.Valid {
+		inv.AcceptedAt = &acceptedAt.Time
+	}
+	return inv, err
+}
+
+// CreateInvitation inserts a new invitation.
+func (s *PostgresStore) CreateInvitation(inv models.WorkspaceInvitation) (models.WorkspaceInvitation, error) {
+	query := `INSERT INTO workspace_invitations (workspace_id, email, role, token_hash, invited_by, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	err := s.db.QueryRow(query, inv.WorkspaceID, inv.Email, inv.Role, inv.Hash, inv.InvitedBy, inv.CreatedAt, inv.ExpiresAt).Scan(&inv.ID)
+	return inv, err
+}
+
+// GetInvitationByHash retrieves an invitation by the hash of its token.
+func (s *PostgresStore) GetInvitationByHash(hash string) (models.WorkspaceInvitation, error) {
+	query := `SELECT ` + invitationColumns + ` FROM workspace_invitations WHERE token_hash = $1`
+	inv, err := scanInvitation(s.db.QueryRow(query, hash))
+	if err == sql.ErrNoRows {
+		return inv, ErrInvitationNotFound
+	}
+	return inv, err
+}
+
+// ListInvitations returns a workspace's pending invitations.
+func (s *PostgresStore) ListInvitations(workspaceID int) ([]models.WorkspaceInvitation, error) {
+	query := `SELECT ` + invitationColumns + ` FROM workspace_invitations
+		WHERE workspace_id = $1 AND accepted_at IS NULL ORDER BY id`
+	rows, err := s.db.Query(query, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []models.WorkspaceInvitation{}
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+// DeleteInvitation withdraws a pending invitation.
+func (s *PostgresStore) DeleteInvitation(workspaceID, id int) error {
+	query := `DELETE FROM workspace_invitations WHERE workspace_id = $1 AND id = $2 AND accepted_at IS NULL`
+	return s.execOne(query, ErrInvitationNotFound, workspaceID, id)
+}
+
+// AcceptInvitation marks the invitation accepted and adds the member in one
+// transaction. The conditional update keeps an invitation from being
+// accepted twice concurrently.
+func (s *PostgresStore) AcceptInvitation(id, userID int, at time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var workspaceID int
+	var role string
+	query := `UPDATE workspace_invitations SET accepted_at = $2
+		WHERE id = $1 AND accepted_at IS NULL AND expires_at > $2
+		RETURNING workspace_id, role`
+	err = tx.QueryRow(query, id, at).Scan(&workspaceID, &role)
+	if err == sql.ErrNoRows {
+		return ErrInvitationInvalid
+	} else if err != nil {
+		return err
+	}
+
+	query = `INSERT INTO workspace_members (workspace_id, user_id, role, joined_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (workspace_id, user_id) DO NOTHING`
+	if _, err := tx.Exec(query, workspaceID, userID, role, at); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
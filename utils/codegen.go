@@ -0,0 +1,199 @@
+// utils/codegen.go
+package utils
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	"strings"
+)
+
+// CodeGenerator produces candidate short codes. Generated codes are not
+// guaranteed to be unique; callers retry on collision.
+type CodeGenerator interface {
+	Generate() (string, error)
+}
+
+// Sequence hands out monotonically increasing IDs, e.g. backed by Redis INCR.
+type Sequence interface {
+	NextID() (uint64, error)
+}
+
+// RandomCodeGenerator generates uniformly random base62 codes using crypto/rand.
+type RandomCodeGenerator struct {
+	Length int
+}
+
+// Generate returns a random code of g.Length characters.
+func (g RandomCodeGenerator) Generate() (string, error) {
+	return randomString(g.Length, letterBytes)
+}
+
+// SequenceCodeGenerator base62-encodes IDs taken from a Sequence, producing
+// the shortest possible codes.
+type SequenceCodeGenerator struct {
+	Seq Sequence
+}
+
+// Generate returns the base62 encoding of the next sequence ID.
+func (g SequenceCodeGenerator) Generate() (string, error) {
+	id, err := g.Seq.NextID()
+	if err != nil {
+		return "", err
+	}
+	return encodeBase(id, letterBytes, 0), nil
+}
+
+// obfuscatedBits bounds the obfuscated ID space so codes stay at most
+// seven characters long (62^7 > 2^40).
+const obfuscatedBits = 40
+
+// ObfuscatedCodeGenerator encodes IDs from a Sequence hashids-style: each ID
+// is scrambled with a salt-derived bijection and written in a salt-shuffled
+// alphabet, so codes are unique but do not reveal how many links exist.
+type ObfuscatedCodeGenerator struct {
+	seq       Sequence
+	alphabet  string
+	mask      uint64
+	minLength int
+}
+
+// NewObfuscatedCodeGenerator creates an ObfuscatedCodeGenerator whose output
+// depends on salt and is padded to at least minLength characters.
+func NewObfuscatedCodeGenerator(seq Sequence, salt string, minLength int) *ObfuscatedCodeGenerator {
+	h := fnv.New64a()
+	h.Write([]byte(salt))
+	sum := h.Sum64()
+	return &ObfuscatedCodeGenerator{
+		seq:       seq,
+		alphabet:  shuffleAlphabet(letterBytes, sum),
+		mask:      sum & (1<<obfuscatedBits - 1),
+		minLength: minLength,
+	}
+}
+
+// Generate returns the obfuscated encoding of the next sequence ID.
+func (g *ObfuscatedCodeGenerator) Generate() (string, error) {
+	id, err := g.seq.NextID()
+	if err != nil {
+		return "", err
+	}
+	if id >= 1<<obfuscatedBits {
+		return "", errors.New("sequence exhausted the obfuscated code space")
+	}
+	return encodeBase(g.scramble(id), g.alphabet, g.minLength), nil
+}
+
+// scramble is a bijection on [0, 2^obfuscatedBits): multiplying by an odd
+// constant is invertible modulo a power of two, as is XOR with a mask.
+func (g *ObfuscatedCodeGenerator) scramble(id uint64) uint64 {
+	const multiplier = 0x5DEECE66D // odd
+	return ((id * multiplier) ^ g.mask) & (1<<obfuscatedBits - 1)
+}
+
+// WordCodeGenerator generates human-readable codes such as
+// "brave-amber-otter" from built-in word lists.
+type WordCodeGenerator struct {
+	// Words is the number of words per code; the last one is a noun.
+	Words int
+}
+
+// Generate returns Words random words joined by '-'.
+func (g WordCodeGenerator) Generate() (string, error) {
+	n := g.Words
+	if n < 2 {
+		n = 2
+	}
+	words := make([]string, n)
+	for i := range words {
+		list := adjectives
+		if i == n-1 {
+			list = nouns
+		}
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(len(list))))
+		if err != nil {
+			return "", fmt.Errorf("error generating word code: %v", err)
+		}
+		words[i] = list[j.Int64()]
+	}
+	return strings.Join(words, "-"), nil
+}
+
+// randomString returns n characters drawn uniformly from alphabet using
+// crypto/rand, rejecting bytes that would bias the distribution.
+func randomString(n int, alphabet string) (string, error) {
+	limit := 256 - 256%len(alphabet)
+	b := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(b) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return "", fmt.Errorf("error generating random code: %v", err)
+		}
+		for _, c := range buf {
+			if int(c) < limit && len(b) < n {
+				b = append(b, alphabet[int(c)%len(alphabet)])
+			}
+		}
+	}
+	return string(b), nil
+}
+
+// encodeBase writes n in the base given by alphabet, left-padded with the
+// alphabet's first character to minLength.
+func encodeBase(n uint64, alphabet string, minLength int) string {
+	base := uint64(len(alphabet))
+	var b []byte
+	for {
+		b = append(b, alphabet[n%base])
+		n /= base
+		if n == 0 {
+			break
+		}
+	}
+	for len(b) < minLength {
+		b = append(b, alphabet[0])
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
+
+// shuffleAlphabet deterministically permutes alphabet with a Fisher-Yates
+// shuffle driven by seed.
+func shuffleAlphabet(alphabet string, seed uint64) string {
+	b := []byte(alphabet)
+	for i := len(b) - 1; i > 0; i-- {
+		// xorshift64 step
+		seed ^= seed << 13
+		seed ^= seed >> 7
+		seed ^= seed << 17
+		j := int(seed % uint64(i+1))
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
+
+var adjectives = []string{
+	"amber", "ancient", "bold", "brave", "bright", "calm", "clever", "cosmic",
+	"crimson", "curious", "daring", "dusty", "eager", "early", "fancy", "fierce",
+	"gentle", "giant", "golden", "happy", "hidden", "humble", "icy", "jolly",
+	"kind", "lively", "lucky", "mellow", "misty", "noble", "odd", "olive",
+	"polite", "proud", "quick", "quiet", "rapid", "rosy", "royal", "rustic",
+	"shiny", "silent", "silver", "sleepy", "smooth", "snowy", "solar", "spicy",
+	"steady", "sunny", "swift", "tidy", "tiny", "vivid", "warm", "wild",
+	"windy", "wise", "witty", "young", "zany", "zesty", "lunar", "frosty",
+}
+
+var nouns = []string{
+	"anchor", "badger", "beacon", "bison", "breeze", "canyon", "cedar", "comet",
+	"coral", "crane", "dolphin", "falcon", "fern", "forest", "fox", "galaxy",
+	"garden", "glacier", "harbor", "hawk", "heron", "island", "jaguar", "lagoon",
+	"lantern", "lemur", "lynx", "maple", "meadow", "meteor", "moose", "nebula",
+	"oasis", "ocean", "orchid", "otter", "owl", "panda", "pebble", "pine",
+	"planet", "prairie", "quartz", "raven", "reef", "river", "robin", "sparrow",
+	"spruce", "summit", "thunder", "tiger", "trail", "tulip", "valley", "walrus",
+	"willow", "wolf", "yak", "zebra", "koala", "puffin", "orbit", "harvest",
+}
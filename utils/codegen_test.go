@@ -0,0 +1,70 @@
+// utils/codegen_test.go
+package utils
+
+import (
+	"regexp"
+	"testing"
+)
+
+type counter struct{ n uint64 }
+
+func (c *counter) NextID() (uint64, error) {
+	c.n++
+	return c.n, nil
+}
+
+func TestRandomCodeGenerator(t *testing.T) {
+	code, err := RandomCodeGenerator{Length: 8}.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^[a-zA-Z0-9]{8}$`).MatchString(code) {
+		t.Errorf("unexpected random code %q", code)
+	}
+}
+
+func TestSequenceCodeGenerator(t *testing.T) {
+	g := SequenceCodeGenerator{Seq: &counter{n: 60}}
+	for _, want := range []string{"9", "ba", "bb"} {
+		if got, _ := g.Generate(); got != want {
+			t.Errorf("got %q want %q", got, want)
+		}
+	}
+}
+
+func TestObfuscatedCodeGeneratorIsUnique(t *testing.T) {
+	g := NewObfuscatedCodeGenerator(&counter{}, "pepper", 6)
+	seen := make(map[string]bool)
+	for i := 0; i < 10000; i++ {
+		code, err := g.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) < 6 || len(code) > 7 {
+			t.Fatalf("code %q has length %d", code, len(code))
+		}
+		if seen[code] {
+			t.Fatalf("duplicate code %q after %d IDs", code, i)
+		}
+		seen[code] = true
+	}
+
+	other, _ := NewObfuscatedCodeGenerator(&counter{}, "salt", 6).Generate()
+	first, _ := NewObfuscatedCodeGenerator(&counter{}, "pepper", 6).Generate()
+	if other == first {
+		t.Errorf("different salts produced the same code %q", first)
+	}
+}
+
+func TestWordCodeGenerator(t *testing.T) {
+	code, err := WordCodeGenerator{Words: 3}.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^[a-z]+-[a-z]+-[a-z]+$`).MatchString(code) {
+		t.Errorf("unexpected word code %q", code)
+	}
+	if err := ValidateAlias(code); err != nil {
+		t.Errorf("word code %q is not a valid alias: %v", code, err)
+	}
+}
@@ -2,20 +2,102 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
-	"math/rand"
+	"net"
+	"net/http"
+	"net/mail"
 	"net/url"
+	"sort"
+	"strings"
+	"unicode"
 )
 
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
-// GenerateRandomString creates a random string of a given length.
+// GenerateRandomString creates a random string of a given length using
+// crypto/rand. It panics if the system's secure random source fails.
 func GenerateRandomString(n int) string {
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+	s, err := randomString(n, letterBytes)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// IsValidRedirectStatus reports whether code is a redirect status a link may
+// use: 301 or 308 for permanent moves, 302 or 307 for temporary ones.
+func IsValidRedirectStatus(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// TrustedProxies are the reverse proxies whose X-Forwarded-For headers are
+// believed when working out a request's client address.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a list of IP addresses and CIDR ranges.
+func ParseTrustedProxies(entries []string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", entry)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %q", entry)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (p TrustedProxies) contains(ip net.IP) bool {
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
 	}
-	return string(b)
+	return false
+}
+
+// ClientIP returns the originating client address of r. That is the peer's
+// address unless the peer is a trusted proxy, in which case X-Forwarded-For
+// is read from the right, skipping the trusted proxies that appended to it:
+// entries left of those were written by the client and can't be believed.
+func (p TrustedProxies) ClientIP(r *http.Request) string {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil || !p.contains(ip) {
+		return addr
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// A malformed entry ends what can be believed
+			break
+		}
+		if addr = hop.String(); !p.contains(hop) {
+			break
+		}
+	}
+	return addr
 }
 
 // checks if the URL is valid and returns a sanitized URL.
@@ -33,3 +115,152 @@ func SanitizeURL(inputURL string) (string, error) {
 	// Return the sanitized URL
 	return parsedURL.String(), nil
 }
+
+const (
+	minAliasLength = 3
+	maxAliasLength = 64
+)
+
+// reservedAliases are path segments already claimed by the router and
+// therefore unusable as short codes. Keep in sync with api/router.go.
+var reservedAliases = map[string]bool{
+	"analytics":    true,
+	"auth":         true,
+	"bulk":         true,
+	"create":       true,
+	"delete":       true,
+	"export":       true,
+	"import":       true,
+	"invitations":  true,
+	"login":        true,
+	"logout":       true,
+	"password":     true,
+	"signup":       true,
+	"token":        true,
+	"urls":         true,
+	"user":         true,
+	"verify-email": true,
+	"workspaces":   true,
+}
+
+var (
+	ErrAliasLength   = fmt.Errorf("alias must be between %d and %d characters", minAliasLength, maxAliasLength)
+	ErrAliasCharset  = errors.New("alias may only contain letters, digits, '-' and '_'")
+	ErrAliasReserved = errors.New("alias is reserved")
+)
+
+// IsReservedAlias reports whether code collides with a route name.
+func IsReservedAlias(code string) bool {
+	return reservedAliases[strings.ToLower(code)]
+}
+
+// ValidateAlias checks a custom short code against the length, charset and
+// reserved-name policy.
+func ValidateAlias(alias string) error {
+	if len(alias) < minAliasLength || len(alias) > maxAliasLength {
+		return ErrAliasLength
+	}
+	for _, c := range alias {
+		if !isAliasRune(c) {
+			return ErrAliasCharset
+		}
+	}
+	if IsReservedAlias(alias) {
+		return ErrAliasReserved
+	}
+	return nil
+}
+
+func isAliasRune(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_'
+}
+
+// Password policy. bcrypt ignores everything past 72 bytes.
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+	maxEmailLength    = 254
+)
+
+var (
+	ErrEmailInvalid    = errors.New("email address is invalid")
+	ErrPasswordLength  = fmt.Errorf("password must be between %d and %d characters", minPasswordLength, maxPasswordLength)
+	ErrPasswordTooWeak = errors.New("password must contain both letters and digits or symbols")
+)
+
+// ValidateEmail checks that email is a bare address such as
+// "alice@example.com" with a dotted domain.
+func ValidateEmail(email string) error {
+	if len(email) > maxEmailLength {
+		return ErrEmailInvalid
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return ErrEmailInvalid
+	}
+	at := strings.LastIndex(email, "@")
+	domain := email[at+1:]
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return ErrEmailInvalid
+	}
+	return nil
+}
+
+// ValidatePassword checks a new password against the strength policy.
+func ValidatePassword(password string) error {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return ErrPasswordLength
+	}
+	var letters, others bool
+	for _, c := range password {
+		if unicode.IsLetter(c) {
+			letters = true
+		} else {
+			others = true
+		}
+	}
+	if !letters || !others {
+		return ErrPasswordTooWeak
+	}
+	return nil
+}
+
+// Limits on link metadata.
+const (
+	MaxTitleLength       = 255
+	MaxDescriptionLength = 1000
+	maxTagLength         = 32
+	maxTags              = 10
+)
+
+var (
+	ErrTagInvalid  = fmt.Errorf("tags must be 1 to %d letters, digits, '-' or '_'", maxTagLength)
+	ErrTooManyTags = fmt.Errorf("a link can have at most %d tags", maxTags)
+)
+
+// NormalizeTags lowercases and trims tags, drops duplicates and sorts them,
+// returning an error if any tag is invalid.
+func NormalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || len(tag) > maxTagLength {
+			return nil, ErrTagInvalid
+		}
+		for _, c := range tag {
+			if !isAliasRune(c) {
+				return nil, ErrTagInvalid
+			}
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxTags {
+		return nil, ErrTooManyTags
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
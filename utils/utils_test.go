@@ -0,0 +1,87 @@
+// utils/utils_test.go
+package utils
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateEmail(t *testing.T) {
+	for email, valid := range map[string]bool{
+		"alice@example.com":         true,
+		"alice+tag@mail.example.io": true,
+		"alice":                     false,
+		"alice@localhost":           false,
+		"Alice <alice@example.com>": false,
+		"":                          false,
+	} {
+		if err := ValidateEmail(email); (err == nil) != valid {
+			t.Errorf("ValidateEmail(%q) = %v, want valid %v", email, err, valid)
+		}
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	for password, want := range map[string]error{
+		"s3cret-passw0rd": nil,
+		"short1":          ErrPasswordLength,
+		"onlyletters":     ErrPasswordTooWeak,
+		"1234567890":      ErrPasswordTooWeak,
+	} {
+		if err := ValidatePassword(password); err != want {
+			t.Errorf("ValidatePassword(%q) = %v, want %v", password, err, want)
+		}
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := NormalizeTags([]string{" Launch", "q3", "launch", "blog_post"})
+	if err != nil || !reflect.DeepEqual(tags, []string{"blog_post", "launch", "q3"}) {
+		t.Errorf("NormalizeTags = %v, %v", tags, err)
+	}
+	for _, bad := range [][]string{{""}, {"two words"}, {strings.Repeat("x", 33)}, strings.Split("a,b,c,d,e,f,g,h,i,j,k", ",")} {
+		if _, err := NormalizeTags(bad); err == nil {
+			t.Errorf("NormalizeTags(%q) accepted invalid tags", bad)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		remoteAddr, forwardedFor, want string
+	}{
+		{"198.51.100.7:4321", "", "198.51.100.7"},
+		{"198.51.100.7:4321", "203.0.113.9", "198.51.100.7"},
+		{"10.1.2.3:4321", "", "10.1.2.3"},
+		{"10.1.2.3:4321", "203.0.113.9", "203.0.113.9"},
+		{"10.1.2.3:4321", "1.1.1.1, 203.0.113.9, 192.0.2.1", "203.0.113.9"},
+		{"10.1.2.3:4321", "203.0.113.9, not-an-ip, 10.4.4.4", "10.4.4.4"},
+		{"10.1.2.3:4321", "10.9.9.9", "10.9.9.9"},
+		{"[2001:db8::1]:4321", "2001:db9::5", "2001:db9::5"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		}
+		if got := proxies.ClientIP(r); got != tt.want {
+			t.Errorf("ClientIP from %s for %q = %q, want %q", tt.remoteAddr, tt.forwardedFor, got, tt.want)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.1.2.3:4321"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9")
+	if got := TrustedProxies(nil).ClientIP(r); got != "10.1.2.3" {
+		t.Errorf("ClientIP without trusted proxies = %q, want the peer address", got)
+	}
+	if _, err := ParseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("ParseTrustedProxies accepted an invalid range")
+	}
+}